@@ -0,0 +1,93 @@
+package apdu
+
+import "fmt"
+
+// AdditionalInterfaces are the additional interfaces supported by the terminal.
+type AdditionalInterfaces byte
+
+const (
+	// InterfaceUICCCLF is the UICC-CLF interface of ETSI TS 102 613.
+	InterfaceUICCCLF AdditionalInterfaces = 0x01
+)
+
+// EUICCCapabilities are the eUICC related capabilities of the terminal, i.e. of the LPA in the terminal.
+type EUICCCapabilities byte
+
+const (
+	// CapabilityLocalUserInterface is the Local User Interface of the device (LUId).
+	CapabilityLocalUserInterface EUICCCapabilities = 1 << iota
+	// CapabilityLocalProfileDownload is the Local Profile Download of the device (LPDd).
+	CapabilityLocalProfileDownload
+	// CapabilityLocalDiscoveryService is the Local Discovery Service of the device (LDSd).
+	CapabilityLocalDiscoveryService
+	// CapabilityLUIeSCWS is the LUIe based on SCWS.
+	CapabilityLUIeSCWS
+	// CapabilityMetadataUpdateAlerting is the metadata update alerting.
+	CapabilityMetadataUpdateAlerting
+	// CapabilityEnterprise is the enterprise capable device.
+	CapabilityEnterprise
+	// CapabilityLUIeE4 is the LUIe using E4.
+	CapabilityLUIeE4
+	// CapabilityLPAProxy is the LPR (LPA Proxy).
+	CapabilityLPAProxy
+)
+
+// TerminalCapability is the data of the TERMINAL CAPABILITY command.
+// An empty or nil TerminalCapability sends no command, for the cards rejecting it.
+//
+// See ETSI TS 102 221, Section 11.1.19 (TERMINAL CAPABILITY).
+type TerminalCapability struct {
+	// ExtendedLogicalChannels indicates that the terminal supports the extended logical channels (tag 81).
+	ExtendedLogicalChannels bool
+	// AdditionalInterfaces are the additional interfaces supported by the terminal (tag 82). Zero omits them.
+	AdditionalInterfaces AdditionalInterfaces
+	// EUICC are the eUICC related capabilities of the terminal (tag 83). Zero omits them.
+	EUICC EUICCCapabilities
+}
+
+// DefaultTerminalCapability is the capability sent by the drivers unless configured otherwise:
+// extended logical channels, the UICC-CLF interface and the LUId, LPDd and LDSd of an LPA in the terminal.
+var DefaultTerminalCapability = TerminalCapability{
+	ExtendedLogicalChannels: true,
+	AdditionalInterfaces:    InterfaceUICCCLF,
+	EUICC:                   CapabilityLocalUserInterface | CapabilityLocalProfileDownload | CapabilityLocalDiscoveryService,
+}
+
+// Empty reports whether the capability has nothing to send. A nil capability is empty.
+func (c *TerminalCapability) Empty() bool {
+	return c == nil || !c.ExtendedLogicalChannels && c.AdditionalInterfaces == 0 && c.EUICC == 0
+}
+
+// Bytes returns the terminal capability template, tag A9.
+func (c *TerminalCapability) Bytes() []byte {
+	var value []byte
+	if c.ExtendedLogicalChannels {
+		value = append(value, 0x81, 0x00)
+	}
+	if c.AdditionalInterfaces != 0 {
+		value = append(value, 0x82, 0x01, byte(c.AdditionalInterfaces))
+	}
+	if c.EUICC != 0 {
+		value = append(value, 0x83, 0x01, byte(c.EUICC))
+	}
+	return append([]byte{0xA9, byte(len(value))}, value...)
+}
+
+// APDU returns the TERMINAL CAPABILITY command.
+func (c *TerminalCapability) APDU() []byte {
+	request := Request{CLA: 0x80, INS: 0xAA, Data: c.Bytes()}
+	return request.APDU()
+}
+
+// Send sends the TERMINAL CAPABILITY command with transmit, unless the capability is empty.
+// The capability is only informative and some cards reject it, so only transmission errors are returned,
+// even when transmit reports the status word of a rejecting card as an error.
+func (c *TerminalCapability) Send(transmit func(command []byte) ([]byte, error)) error {
+	if c.Empty() {
+		return nil
+	}
+	if response, err := transmit(c.APDU()); err != nil && len(response) < 2 {
+		return fmt.Errorf("terminal capability: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package apdu
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTerminalCapability(t *testing.T) {
+	capability := DefaultTerminalCapability
+	assert.Equal(t, []byte{0x80, 0xAA, 0x00, 0x00, 0x0A, 0xA9, 0x08, 0x81, 0x00, 0x82, 0x01, 0x01, 0x83, 0x01, 0x07}, capability.APDU())
+
+	capability = TerminalCapability{EUICC: CapabilityLocalProfileDownload | CapabilityLPAProxy}
+	assert.Equal(t, []byte{0xA9, 0x03, 0x83, 0x01, 0x82}, capability.Bytes())
+
+	rejected := func([]byte) ([]byte, error) { return []byte{0x6D, 0x00}, errors.New("unexpected response: 6D00") }
+	assert.NoError(t, capability.Send(rejected))
+	failed := func([]byte) ([]byte, error) { return nil, errors.New("broken pipe") }
+	assert.Error(t, capability.Send(failed))
+
+	var empty TerminalCapability
+	assert.NoError(t, empty.Send(func([]byte) ([]byte, error) {
+		t.Fatal("empty capability sent")
+		return nil, nil
+	}))
+}
@@ -2,40 +2,72 @@ package apdu
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"slices"
 	"sync"
 )
 
+// StatusError is returned when the card answers a command with an unexpected status word.
+type StatusError uint16
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("returned an unexpected response with status %04X", uint16(e))
+}
+
+// ChannelLost reports whether the status word means the logical channel is no longer open,
+// e.g. because the card was refreshed after a profile switch.
+func (e StatusError) ChannelLost() bool {
+	// 6881: logical channel not supported, 6E00: class not supported.
+	return e == 0x6881 || e == 0x6E00
+}
+
 type Transmitter struct {
 	MSS            int
 	mutex          sync.Mutex
 	channel        SmartCardChannel
+	AID            []byte
 	logicalChannel byte
+	opened         bool
 	response       *bytes.Buffer
 }
 
-func NewTransmitter(channel SmartCardChannel, AID []byte, MSS int) (io.ReadWriteCloser, error) {
-	var err error
-	if err = channel.Connect(); err != nil {
+func NewTransmitter(channel SmartCardChannel, AID []byte, MSS int) (*Transmitter, error) {
+	transmitter, err := Connect(channel, MSS)
+	if err != nil {
+		return nil, err
+	}
+	if err = transmitter.Select(AID); err != nil {
+		_ = channel.Disconnect()
 		return nil, err
 	}
-	var transmitter Transmitter
-	transmitter.channel = channel
-	if transmitter.logicalChannel, err = channel.OpenLogicalChannel(AID); err != nil {
+	return transmitter, nil
+}
+
+// Connect connects the channel without opening a logical channel, see Select.
+func Connect(channel SmartCardChannel, MSS int) (*Transmitter, error) {
+	if err := channel.Connect(); err != nil {
 		return nil, err
 	}
-	transmitter.MSS = MSS
-	return &transmitter, nil
+	return &Transmitter{channel: channel, MSS: MSS}, nil
 }
 
 func (t *Transmitter) Read(p []byte) (n int, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 	return t.response.Read(p)
 }
 
+// Write sends the command with STORE DATA and collects the response to be read with Read.
+// The whole exchange holds the lock, so the APDUs of concurrent writes are never interleaved.
 func (t *Transmitter) Write(command []byte) (n int, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 	t.response = new(bytes.Buffer)
+	if !t.opened {
+		return 0, errors.New("no logical channel is open")
+	}
 	request := Request{CLA: 0x80, INS: 0xE2}
 	var response Response
 	chunks := byte(len(command) / t.MSS)
@@ -60,14 +92,12 @@ func (t *Transmitter) Write(command []byte) (n int, err error) {
 }
 
 func (t *Transmitter) transmit(request *Request) (response Response, err error) {
-	t.mutex.Lock()
-	defer t.mutex.Unlock()
 	t.setChannelToCLA(request, t.logicalChannel)
 	if response, err = t.channel.Transmit(request.APDU()); err != nil {
 		return
 	}
 	if !response.OK() && !response.HasMore() {
-		err = fmt.Errorf("returned an unexpected response with status %04X", response.SW())
+		err = StatusError(response.SW())
 	}
 	return
 }
@@ -102,9 +132,37 @@ func (t *Transmitter) readCommandResponse(w io.Writer, le byte) error {
 	return nil
 }
 
-func (t *Transmitter) Close() error {
-	if err := t.channel.CloseLogicalChannel(t.logicalChannel); err != nil {
+// Reopen opens a new logical channel to the application, e.g. after a card refresh closed the previous one.
+// The previous channel is closed first, ignoring the error as the card may have closed it already.
+func (t *Transmitter) Reopen() error {
+	return t.Select(t.AID)
+}
+
+// Select opens a logical channel to the application with the given AID, in place of the open one.
+// The channel stays connected, so the AIDs of a card can be tried in turn.
+func (t *Transmitter) Select(AID []byte) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.opened {
+		_ = t.channel.CloseLogicalChannel(t.logicalChannel)
+		t.opened = false
+	}
+	channel, err := t.channel.OpenLogicalChannel(AID)
+	if err != nil {
 		return err
 	}
+	t.AID, t.logicalChannel, t.opened = AID, channel, true
+	return nil
+}
+
+func (t *Transmitter) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.opened {
+		if err := t.channel.CloseLogicalChannel(t.logicalChannel); err != nil {
+			return err
+		}
+		t.opened = false
+	}
 	return t.channel.Disconnect()
 }
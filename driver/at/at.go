@@ -1,87 +1,170 @@
 package at
 
 import (
-	"bufio"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/damonto/euicc-go/apdu"
 )
 
+// Options configures the AT driver.
+type Options struct {
+	// BaudRate is the serial port speed. It defaults to 115200.
+	BaudRate int
+	// Timeout is the time to wait for the final result code of a command. It defaults to 30 seconds.
+	Timeout time.Duration
+	// OnUnsolicited receives the unsolicited result codes sent by the modem, e.g. "+CREG: 1".
+	// It is called from the reader goroutine and must not block or run commands. It defaults to nil (discarded).
+	OnUnsolicited func(line string)
+	// TerminalCapability is sent to the card on Connect. It defaults to apdu.DefaultTerminalCapability;
+	// an empty TerminalCapability sends nothing.
+	TerminalCapability *apdu.TerminalCapability
+}
+
+func (opts *Options) setDefaults() {
+	if opts.BaudRate == 0 {
+		opts.BaudRate = 115200
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if opts.TerminalCapability == nil {
+		capability := apdu.DefaultTerminalCapability
+		opts.TerminalCapability = &capability
+	}
+}
+
 type AT struct {
-	s       io.ReadWriteCloser
-	channel byte
+	e          *engine
+	timeout    time.Duration
+	capability *apdu.TerminalCapability
+	channel    byte
+	// csim and cgla report whether the modem supports AT+CSIM and the
+	// AT+CCHO, AT+CGLA and AT+CCHC logical channel commands.
+	csim bool
+	cgla bool
+	// session is the +CCHO session of the open logical channel, or -1 if the channel is managed through AT+CSIM.
+	session int
+	// capabilitySent is set once the terminal capability was sent to the card.
+	capabilitySent bool
 }
 
 func New(device string) (apdu.SmartCardChannel, error) {
-	var at AT
-	var err error
-	if at.s, err = Open(device); err != nil {
+	return NewWithOptions(device, nil)
+}
+
+// NewWithOptions opens the serial device with the given options.
+func NewWithOptions(device string, opts *Options) (apdu.SmartCardChannel, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	opts.setDefaults()
+	port, err := Open(device, opts.BaudRate)
+	if err != nil {
 		return nil, fmt.Errorf("open serial port %s: %w", device, err)
 	}
-	return &at, nil
+	return newAT(port, opts), nil
 }
 
-func (a *AT) run(command string) (string, error) {
-	if _, err := a.s.Write([]byte(command + "\r\n")); err != nil {
-		return "", err
-	}
-	reader := bufio.NewReader(a.s)
-	var sb strings.Builder
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			return "", err
-		}
-		line = strings.TrimSpace(line)
-		switch {
-		case strings.Contains(line, "OK"):
-			return strings.TrimSpace(sb.String()), nil
-		case strings.Contains(line, "ERR"):
-			return "", errors.New(line)
-		default:
-			sb.WriteString(line + "\n")
-		}
+// NewFromConn uses an already open stream to the modem, e.g. a connection returned by DialTCP or DialRFC2217.
+// Options.BaudRate is ignored. The stream is closed on Disconnect.
+func NewFromConn(conn io.ReadWriteCloser, opts *Options) apdu.SmartCardChannel {
+	if opts == nil {
+		opts = new(Options)
 	}
+	opts.setDefaults()
+	return newAT(conn, opts)
+}
+
+func newAT(port io.ReadWriteCloser, opts *Options) *AT {
+	return &AT{e: newEngine(port, opts.OnUnsolicited), timeout: opts.Timeout, capability: opts.TerminalCapability, session: -1}
+}
+
+// Run sends an AT command and returns its information response lines.
+// The error is ErrError, a *CMEError or a *CMSError if the modem does not answer OK.
+func (a *AT) Run(command string, timeout time.Duration) ([]string, error) {
+	return a.e.run(command, timeout)
+}
+
+func (a *AT) run(command string) ([]string, error) {
+	return a.e.run(command, a.timeout)
 }
 
 func (a *AT) Transmit(command []byte) ([]byte, error) {
 	cmd := fmt.Sprintf("%X", command)
-	cmd = fmt.Sprintf("AT+CSIM=%d,%q", len(cmd), cmd)
-	r, err := a.run(cmd)
+	if a.session >= 0 && !isBasicChannel(command) {
+		cmd = fmt.Sprintf("AT+CGLA=%d,%d,%q", a.session, len(cmd), cmd)
+	} else if a.csim {
+		cmd = fmt.Sprintf("AT+CSIM=%d,%q", len(cmd), cmd)
+	} else {
+		return nil, errors.New("modem does not support AT+CSIM, commands are only sent on a logical channel")
+	}
+	lines, err := a.run(cmd)
 	if err != nil {
 		return nil, err
 	}
-	sw, err := a.sw(r)
+	sw, err := a.sw(lines)
 	if err != nil {
 		return nil, err
 	}
-	if sw[len(sw)-2] != 0x90 && sw[len(sw)-2] != 0x61 {
-		return sw, fmt.Errorf("unexpected response: %X", sw)
+	if len(sw) < 2 {
+		return nil, fmt.Errorf("invalid response: %X", sw)
 	}
+	// Other status words are left to the caller, e.g. 6881 when the card lost the logical channel.
 	return sw, nil
 }
 
-func (a *AT) sw(sw string) ([]byte, error) {
-	lastIdx := strings.LastIndex(sw, ",")
+// isBasicChannel reports whether the CLA of the command addresses the basic channel.
+func isBasicChannel(command []byte) bool {
+	return len(command) == 0 || command[0]&0x43 == 0
+}
+
+// sw parses the last +CSIM or +CGLA response line, e.g. `+CSIM: 4,"9000"`.
+func (a *AT) sw(lines []string) ([]byte, error) {
+	if len(lines) == 0 {
+		return nil, errors.New("invalid response")
+	}
+	line := lines[len(lines)-1]
+	lastIdx := strings.LastIndex(line, ",")
 	if lastIdx == -1 {
 		return nil, errors.New("invalid response")
 	}
-	return hex.DecodeString(sw[lastIdx+2 : len(sw)-1])
+	return hex.DecodeString(strings.Trim(line[lastIdx+1:], "\" "))
+}
+
+// supports reports whether the modem accepts the test command of an extended AT command.
+func (a *AT) supports(command string) bool {
+	_, err := a.run(command + "=?")
+	return err == nil
 }
 
 func (a *AT) Connect() error {
-	if _, err := a.run("AT+CSIM=?"); err != nil {
-		return err
+	a.csim = a.supports("AT+CSIM")
+	a.cgla = a.supports("AT+CCHO") && a.supports("AT+CGLA") && a.supports("AT+CCHC")
+	if !a.csim && !a.cgla {
+		return errors.New("modem supports neither AT+CSIM nor AT+CCHO/AT+CGLA/AT+CCHC")
+	}
+	// Without AT+CSIM, the capability is sent on the first logical channel opened with AT+CCHO.
+	a.capabilitySent = a.csim
+	if !a.csim {
+		return nil
 	}
-	_, err := a.Transmit([]byte{0x80, 0xAA, 0x00, 0x00, 0x0A, 0xA9, 0x08, 0x81, 0x00, 0x82, 0x01, 0x01, 0x83, 0x01, 0x07})
-	return err
+	return a.capability.Send(a.Transmit)
 }
 
 func (a *AT) OpenLogicalChannel(AID []byte) (byte, error) {
+	if a.cgla {
+		channel, err := a.openLogicalChannel(AID)
+		if err == nil || !a.csim {
+			return channel, err
+		}
+	}
 	channel, err := a.Transmit([]byte{0x00, 0x70, 0x00, 0x00, 0x01})
 	if err != nil {
 		return 0, err
@@ -95,16 +178,63 @@ func (a *AT) OpenLogicalChannel(AID []byte) (byte, error) {
 		return 0, err
 	}
 	if sw[len(sw)-2] != 0x90 && sw[len(sw)-2] != 0x61 {
+		a.CloseLogicalChannel(a.channel)
 		return 0, fmt.Errorf("select AID: %X", sw)
 	}
 	return a.channel, nil
 }
 
+// openLogicalChannel opens a logical channel and selects AID with AT+CCHO.
+// The modem only tells the session ID, an arbitrary integer, not the number of the channel.
+// The commands on a logical channel are sent with AT+CGLA to the session, and the modem addresses
+// the channel of the session, so the returned number only codes the CLA of the commands.
+func (a *AT) openLogicalChannel(AID []byte) (byte, error) {
+	lines, err := a.run(fmt.Sprintf("AT+CCHO=%q", fmt.Sprintf("%X", AID)))
+	if err != nil {
+		return 0, fmt.Errorf("open logical channel: %w", err)
+	}
+	if len(lines) == 0 {
+		return 0, errors.New("open logical channel: empty response")
+	}
+	r := lines[len(lines)-1]
+	session, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(r, "+CCHO:")))
+	if err != nil || session < 0 {
+		return 0, fmt.Errorf("open logical channel: invalid session %q", r)
+	}
+	a.session = session
+	a.channel = 1
+	if !a.capabilitySent {
+		err := a.capability.Send(func(command []byte) ([]byte, error) {
+			command = slices.Clone(command)
+			command[0] |= a.channel
+			return a.Transmit(command)
+		})
+		if err != nil {
+			a.CloseLogicalChannel(a.channel)
+			return 0, err
+		}
+		a.capabilitySent = true
+	}
+	return a.channel, nil
+}
+
 func (a *AT) CloseLogicalChannel(channel byte) error {
-	_, err := a.Transmit([]byte{0x00, 0x70, 0x80, channel, 0x00})
-	return err
+	if a.session >= 0 && channel == a.channel {
+		session := a.session
+		a.session = -1
+		_, err := a.run(fmt.Sprintf("AT+CCHC=%d", session))
+		return err
+	}
+	sw, err := a.Transmit([]byte{0x00, 0x70, 0x80, channel, 0x00})
+	if err != nil {
+		return err
+	}
+	if sw[len(sw)-2] != 0x90 {
+		return fmt.Errorf("close logical channel: %X", sw)
+	}
+	return nil
 }
 
 func (a *AT) Disconnect() error {
-	return a.s.Close()
+	return a.e.close()
 }
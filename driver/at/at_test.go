@@ -0,0 +1,199 @@
+package at
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/stretchr/testify/assert"
+)
+
+// modem answers AT commands from a script and records the commands it received.
+type modem struct {
+	script   map[string]string
+	mutex    sync.Mutex
+	commands []string
+	reader   *io.PipeReader
+	writer   *io.PipeWriter
+}
+
+func newModem(script map[string]string) *modem {
+	m := &modem{script: script}
+	m.reader, m.writer = io.Pipe()
+	return m
+}
+
+func (m *modem) Read(p []byte) (int, error) {
+	return m.reader.Read(p)
+}
+
+func (m *modem) Write(p []byte) (int, error) {
+	command := strings.TrimSpace(string(p))
+	m.mutex.Lock()
+	m.commands = append(m.commands, command)
+	m.mutex.Unlock()
+	response, ok := m.script[command]
+	if !ok {
+		response = "ERROR"
+	}
+	if response != "" {
+		go m.writer.Write([]byte("\r\n" + response + "\r\n"))
+	}
+	return len(p), nil
+}
+
+func (m *modem) Close() error { return m.writer.Close() }
+
+func (m *modem) last() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.commands[len(m.commands)-1]
+}
+
+func TestAT_LogicalChannelCommands(t *testing.T) {
+	m := newModem(map[string]string{
+		"AT+CSIM=?": "ERROR",
+		"AT+CCHO=?": "OK",
+		"AT+CGLA=?": "OK",
+		"AT+CCHC=?": "OK",
+		`AT+CCHO="A0000005591010FFFFFFFF8900000100"`:      "+CCHO: 257\r\n\r\nOK",
+		`AT+CGLA=257,30,"81AA00000AA9088100820101830107"`: "+CGLA: 4,\"9000\"\r\n\r\nOK",
+		`AT+CGLA=257,16,"81E2910003BF3E00"`:               "+CGLA: 4,\"9000\"\r\n\r\nOK",
+		`AT+CGLA=257,16,"81E2910003BF2D00"`:               "+CGLA: 4,\"6881\"\r\n\r\nOK",
+		"AT+CCHC=257":                                     "OK",
+	})
+	capability := apdu.DefaultTerminalCapability
+	a := newAT(m, &Options{Timeout: time.Second, TerminalCapability: &capability})
+	assert.NoError(t, a.Connect())
+	channel, err := a.OpenLogicalChannel([]byte{0xA0, 0x00, 0x00, 0x05, 0x59, 0x10, 0x10, 0xFF, 0xFF, 0xFF, 0xFF, 0x89, 0x00, 0x00, 0x01, 0x00})
+	assert.NoError(t, err)
+	assert.Equal(t, byte(1), channel)
+	// The terminal capability goes to the card on the logical channel, the modem lacking AT+CSIM.
+	assert.Contains(t, m.commands, `AT+CGLA=257,30,"81AA00000AA9088100820101830107"`)
+	_, err = a.Transmit([]byte{0x80, 0xE2, 0x91, 0x00, 0x03, 0xBF, 0x3E, 0x00})
+	assert.EqualError(t, err, "modem does not support AT+CSIM, commands are only sent on a logical channel")
+	sw, err := a.Transmit([]byte{0x81, 0xE2, 0x91, 0x00, 0x03, 0xBF, 0x3E, 0x00})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x90, 0x00}, sw)
+	// The status words of a failed command are the response, for apdu to classify them.
+	sw, err = a.Transmit([]byte{0x81, 0xE2, 0x91, 0x00, 0x03, 0xBF, 0x2D, 0x00})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x68, 0x81}, sw)
+	assert.NoError(t, a.CloseLogicalChannel(channel))
+	assert.Equal(t, "AT+CCHC=257", m.last())
+}
+
+func TestAT_FallbackToCSIM(t *testing.T) {
+	m := newModem(map[string]string{
+		"AT+CSIM=?": "OK",
+		"AT+CCHO=?": "OK",
+		"AT+CGLA=?": "OK",
+		"AT+CCHC=?": "OK",
+		`AT+CSIM=30,"80AA00000AA9088100820101830107"`:             "+CSIM: 4,\"9000\"\r\nOK",
+		`AT+CSIM=10,"0070000001"`:                                 "+CSIM: 6,\"019000\"\r\nOK",
+		`AT+CSIM=42,"01A4040010A0000005591010FFFFFFFF8900000100"`: "+CSIM: 4,\"9000\"\r\nOK",
+		`AT+CSIM=10,"0070800100"`:                                 "+CSIM: 4,\"9000\"\r\nOK",
+	})
+	a := newAT(m, &Options{Timeout: time.Second})
+	assert.NoError(t, a.Connect())
+	channel, err := a.OpenLogicalChannel([]byte{0xA0, 0x00, 0x00, 0x05, 0x59, 0x10, 0x10, 0xFF, 0xFF, 0xFF, 0xFF, 0x89, 0x00, 0x00, 0x01, 0x00})
+	assert.NoError(t, err)
+	assert.Equal(t, byte(1), channel)
+	assert.NoError(t, a.CloseLogicalChannel(channel))
+}
+
+func TestAT_Run(t *testing.T) {
+	var urcs []string
+	var mutex sync.Mutex
+	m := newModem(map[string]string{
+		"AT+CSIM=10,\"0070000001\"": "AT+CSIM=10,\"0070000001\"\r\n+CREG: 1\r\n+CSIM: 6,\"01OK00\"\r\nRING\r\nOK",
+		"AT+CPIN?":                  "+CME ERROR: 10",
+		"AT+CMGS=?":                 "+CMS ERROR: SMS service reserved",
+		"AT+SILENT":                 "",
+	})
+	a := newAT(m, &Options{
+		Timeout: time.Second,
+		OnUnsolicited: func(line string) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			urcs = append(urcs, line)
+		},
+	})
+	defer a.Disconnect()
+
+	lines, err := a.run(`AT+CSIM=10,"0070000001"`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`+CSIM: 6,"01OK00"`}, lines)
+
+	_, err = a.run("AT+CPIN?")
+	var cme *CMEError
+	assert.True(t, errors.As(err, &cme))
+	assert.Equal(t, 10, cme.Code)
+
+	_, err = a.run("AT+CMGS=?")
+	var cms *CMSError
+	assert.True(t, errors.As(err, &cms))
+	assert.Equal(t, "SMS service reserved", cms.Message)
+
+	_, err = a.run("AT+UNKNOWN")
+	assert.ErrorIs(t, err, ErrError)
+
+	_, err = a.Run("AT+SILENT", 10*time.Millisecond)
+	assert.ErrorIs(t, err, ErrTimeout)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []string{"+CREG: 1", "RING"}, urcs)
+}
+
+func TestDialRFC2217(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var buf bytes.Buffer
+		chunk := make([]byte, 256)
+		for !bytes.Contains(buf.Bytes(), []byte("AT\r")) {
+			n, err := conn.Read(chunk)
+			if err != nil {
+				return
+			}
+			buf.Write(chunk[:n])
+		}
+		// Ask the client to echo, which it must refuse, then answer the command.
+		conn.Write([]byte{telnetIAC, telnetWILL, 1, telnetIAC, telnetDO, optionComPort})
+		conn.Write([]byte("\r\nOK\r\n"))
+		n, _ := conn.Read(chunk)
+		buf.Write(chunk[:n])
+		received <- buf.Bytes()
+	}()
+
+	conn, err := DialRFC2217(listener.Addr().String(), time.Second, &SerialSettings{BaudRate: 9600})
+	assert.NoError(t, err)
+	a := NewFromConn(conn, &Options{Timeout: time.Second}).(*AT)
+	_, err = a.run("AT")
+	assert.NoError(t, err)
+	assert.NoError(t, a.Disconnect())
+
+	data := <-received
+	assert.True(t, bytes.Contains(data, subnegotiation(comPortSetBaudRate, 0x00, 0x00, 0x25, 0x80)))
+	assert.True(t, bytes.Contains(data, subnegotiation(comPortSetParity, byte(ParityNone))))
+	assert.True(t, bytes.HasSuffix(data, []byte{telnetIAC, telnetDONT, 1}))
+}
+
+func TestTelnet_Escape(t *testing.T) {
+	assert.Equal(t, []byte{telnetIAC, telnetSB, optionComPort, comPortSetBaudRate, 0x00, 0xFF, 0xFF, 0x00, 0x00, telnetIAC, telnetSE},
+		subnegotiation(comPortSetBaudRate, 0x00, 0xFF, 0x00, 0x00))
+}
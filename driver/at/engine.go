@@ -0,0 +1,215 @@
+package at
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrError is the plain ERROR final result code.
+	ErrError = errors.New("at: ERROR")
+	// ErrTimeout is returned when the modem does not send a final result code in time.
+	ErrTimeout = errors.New("at: command timed out")
+)
+
+// CMEError is the +CME ERROR final result code (3GPP TS 27.007, Section 9.2).
+// Code is -1 when the modem reports the error in verbose format only.
+type CMEError struct {
+	Code    int
+	Message string
+}
+
+func (e *CMEError) Error() string {
+	if e.Message != "" {
+		return "at: +CME ERROR: " + e.Message
+	}
+	return fmt.Sprintf("at: +CME ERROR: %d", e.Code)
+}
+
+// CMSError is the +CMS ERROR final result code (3GPP TS 27.005, Section 3.2.5).
+// Code is -1 when the modem reports the error in verbose format only.
+type CMSError struct {
+	Code    int
+	Message string
+}
+
+func (e *CMSError) Error() string {
+	if e.Message != "" {
+		return "at: +CMS ERROR: " + e.Message
+	}
+	return fmt.Sprintf("at: +CMS ERROR: %d", e.Code)
+}
+
+// command is the command waiting for its final result code.
+type command struct {
+	text   string
+	prefix string
+	lines  []string
+	done   chan error
+}
+
+// engine runs AT commands over a byte stream.
+// A single goroutine reads the stream, so no buffered byte is lost between commands.
+type engine struct {
+	port    io.ReadWriteCloser
+	onURC   func(string)
+	exec    sync.Mutex
+	mutex   sync.Mutex
+	command *command
+	closed  chan struct{}
+	err     error
+}
+
+func newEngine(port io.ReadWriteCloser, onURC func(string)) *engine {
+	e := &engine{port: port, onURC: onURC, closed: make(chan struct{})}
+	go e.read()
+	return e
+}
+
+func (e *engine) read() {
+	reader := bufio.NewReader(e.port)
+	for {
+		line, err := reader.ReadString('\n')
+		if line = strings.TrimSpace(line); line != "" {
+			e.dispatch(line)
+		}
+		if err != nil {
+			e.mutex.Lock()
+			e.err = err
+			e.mutex.Unlock()
+			close(e.closed)
+			return
+		}
+	}
+}
+
+// dispatch routes a line to the running command or to the unsolicited result code handler.
+func (e *engine) dispatch(line string) {
+	e.mutex.Lock()
+	c := e.command
+	switch {
+	case c == nil && isFinal(line):
+		// Late result of a command that timed out.
+		e.mutex.Unlock()
+		return
+	case c == nil:
+	case line == c.text:
+		// Command echo (ATE1).
+		e.mutex.Unlock()
+		return
+	case isFinal(line):
+		e.command = nil
+		e.mutex.Unlock()
+		c.done <- result(line)
+		return
+	case !unsolicited(line, c.prefix):
+		c.lines = append(c.lines, line)
+		e.mutex.Unlock()
+		return
+	}
+	e.mutex.Unlock()
+	if e.onURC != nil {
+		e.onURC(line)
+	}
+}
+
+// run sends the command and returns its information response lines.
+func (e *engine) run(text string, timeout time.Duration) ([]string, error) {
+	e.exec.Lock()
+	defer e.exec.Unlock()
+	c := &command{text: text, prefix: prefix(text), done: make(chan error, 1)}
+	e.mutex.Lock()
+	if e.err != nil {
+		e.mutex.Unlock()
+		return nil, e.err
+	}
+	e.command = c
+	e.mutex.Unlock()
+	if _, err := e.port.Write([]byte(text + "\r")); err != nil {
+		e.abort(c)
+		return nil, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-c.done:
+		return c.lines, err
+	case <-timer.C:
+		e.abort(c)
+		return nil, fmt.Errorf("%w: %s", ErrTimeout, text)
+	case <-e.closed:
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+		return nil, e.err
+	}
+}
+
+func (e *engine) abort(c *command) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.command == c {
+		e.command = nil
+	}
+}
+
+func (e *engine) close() error {
+	return e.port.Close()
+}
+
+// prefix returns the information response prefix of an extended command, e.g. "+CSIM:" for "AT+CSIM=...".
+func prefix(text string) string {
+	name := strings.TrimPrefix(strings.ToUpper(text), "AT")
+	if name == "" || !strings.ContainsRune("+^$%*", rune(name[0])) {
+		return ""
+	}
+	if index := strings.IndexAny(name, "=?"); index != -1 {
+		name = name[:index]
+	}
+	return name + ":"
+}
+
+// unsolicited reports whether the line received while running a command is an unsolicited result code.
+func unsolicited(line, prefix string) bool {
+	if line == "RING" {
+		return true
+	}
+	if !strings.ContainsRune("+^$%*", rune(line[0])) {
+		return false
+	}
+	return prefix == "" || !strings.HasPrefix(line, prefix)
+}
+
+func isFinal(line string) bool {
+	return line == "OK" || line == "ERROR" ||
+		strings.HasPrefix(line, "+CME ERROR:") ||
+		strings.HasPrefix(line, "+CMS ERROR:")
+}
+
+// result converts a final result code into an error.
+func result(line string) error {
+	switch {
+	case line == "OK":
+		return nil
+	case strings.HasPrefix(line, "+CME ERROR:"):
+		code, message := errorCode(strings.TrimPrefix(line, "+CME ERROR:"))
+		return &CMEError{Code: code, Message: message}
+	case strings.HasPrefix(line, "+CMS ERROR:"):
+		code, message := errorCode(strings.TrimPrefix(line, "+CMS ERROR:"))
+		return &CMSError{Code: code, Message: message}
+	}
+	return ErrError
+}
+
+func errorCode(value string) (int, string) {
+	value = strings.TrimSpace(value)
+	if code, err := strconv.Atoi(value); err == nil {
+		return code, ""
+	}
+	return -1, value
+}
@@ -0,0 +1,75 @@
+package at
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/euicc-go/driver"
+)
+
+// The AT driver registers the following schemes:
+//
+//	at:///dev/ttyUSB2?baud=115200&timeout=30s   serial port, "at:COM3" on Windows
+//	at+tcp://192.168.1.1:3333?timeout=30s       raw TCP stream, see DialTCP
+//	at+rfc2217://192.168.1.1:2001?baud=115200   RFC 2217 serial port, see DialRFC2217
+func init() {
+	driver.Register("at", openSerial)
+	driver.Register("at+tcp", openTCP)
+	driver.Register("at+rfc2217", openRFC2217)
+}
+
+func openSerial(u *url.URL) (apdu.SmartCardChannel, error) {
+	opts, err := parseOptions(u)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithOptions(driver.Device(u), opts)
+}
+
+func openTCP(u *url.URL) (apdu.SmartCardChannel, error) {
+	opts, err := parseOptions(u)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := DialTCP(u.Host, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return NewFromConn(conn, opts), nil
+}
+
+func openRFC2217(u *url.URL) (apdu.SmartCardChannel, error) {
+	opts, err := parseOptions(u)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := DialRFC2217(u.Host, 10*time.Second, &SerialSettings{BaudRate: opts.BaudRate})
+	if err != nil {
+		return nil, err
+	}
+	return NewFromConn(conn, opts), nil
+}
+
+// parseOptions reads the "baud" and "timeout" query parameters.
+func parseOptions(u *url.URL) (*Options, error) {
+	query := u.Query()
+	opts := new(Options)
+	if value := query.Get("baud"); value != "" {
+		baudRate, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid baud rate %q: %w", value, err)
+		}
+		opts.BaudRate = baudRate
+	}
+	if value := query.Get("timeout"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout %q: %w", value, err)
+		}
+		opts.Timeout = timeout
+	}
+	return opts, nil
+}
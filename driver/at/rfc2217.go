@@ -0,0 +1,295 @@
+package at
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Telnet commands and options used by RFC 854, RFC 856, RFC 858 and RFC 2217.
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	optionBinary  = 0
+	optionSGA     = 3
+	optionComPort = 44
+
+	comPortSetBaudRate = 1
+	comPortSetDataSize = 2
+	comPortSetParity   = 3
+	comPortSetStopSize = 4
+	comPortSetControl  = 5
+)
+
+// Parity is the parity of a remote serial port, as encoded by RFC 2217.
+type Parity byte
+
+const (
+	ParityNone  Parity = 1
+	ParityOdd   Parity = 2
+	ParityEven  Parity = 3
+	ParityMark  Parity = 4
+	ParitySpace Parity = 5
+)
+
+// StopBits is the number of stop bits of a remote serial port, as encoded by RFC 2217.
+type StopBits byte
+
+const (
+	StopBits1   StopBits = 1
+	StopBits2   StopBits = 2
+	StopBits1_5 StopBits = 3
+)
+
+// FlowControl is the flow control of a remote serial port, as encoded by RFC 2217.
+type FlowControl byte
+
+const (
+	FlowControlNone     FlowControl = 1
+	FlowControlXONXOFF  FlowControl = 2
+	FlowControlHardware FlowControl = 3
+)
+
+// SerialSettings are the line settings applied to a remote serial port.
+type SerialSettings struct {
+	// BaudRate is the serial port speed. It defaults to 115200.
+	BaudRate int
+	// DataBits is the character size. It defaults to 8.
+	DataBits int
+	// Parity defaults to ParityNone.
+	Parity Parity
+	// StopBits defaults to StopBits1.
+	StopBits StopBits
+	// FlowControl defaults to FlowControlNone.
+	FlowControl FlowControl
+}
+
+func (s *SerialSettings) setDefaults() {
+	if s.BaudRate == 0 {
+		s.BaudRate = 115200
+	}
+	if s.DataBits == 0 {
+		s.DataBits = 8
+	}
+	if s.Parity == 0 {
+		s.Parity = ParityNone
+	}
+	if s.StopBits == 0 {
+		s.StopBits = StopBits1
+	}
+	if s.FlowControl == 0 {
+		s.FlowControl = FlowControlNone
+	}
+}
+
+// DialTCP connects to a modem exposed as a raw TCP stream, e.g. by ser2net in raw mode.
+//
+//	conn, err := at.DialTCP("192.168.1.1:3333", 10*time.Second)
+//	ch := at.NewFromConn(conn, nil)
+func DialTCP(address string, timeout time.Duration) (io.ReadWriteCloser, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", address, err)
+	}
+	return conn, nil
+}
+
+// DialRFC2217 connects to a modem exposed as an RFC 2217 telnet serial port, e.g. by ser2net in telnet mode,
+// and applies the line settings to the remote port.
+func DialRFC2217(address string, timeout time.Duration, settings *SerialSettings) (io.ReadWriteCloser, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", address, err)
+	}
+	if settings == nil {
+		settings = new(SerialSettings)
+	}
+	settings.setDefaults()
+	t := &telnet{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		local:  map[byte]bool{optionBinary: true, optionSGA: true, optionComPort: true},
+		remote: map[byte]bool{optionBinary: true, optionSGA: true},
+	}
+	if err := t.configure(settings); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("configure %s: %w", address, err)
+	}
+	return t, nil
+}
+
+// telnet is a telnet client stream carrying binary serial data.
+type telnet struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mutex  sync.Mutex
+	local  map[byte]bool
+	remote map[byte]bool
+}
+
+func (t *telnet) configure(settings *SerialSettings) error {
+	baudRate := make([]byte, 4)
+	binary.BigEndian.PutUint32(baudRate, uint32(settings.BaudRate))
+	commands := [][]byte{
+		{telnetIAC, telnetWILL, optionComPort},
+		{telnetIAC, telnetWILL, optionBinary},
+		{telnetIAC, telnetDO, optionBinary},
+		{telnetIAC, telnetWILL, optionSGA},
+		{telnetIAC, telnetDO, optionSGA},
+		subnegotiation(comPortSetBaudRate, baudRate...),
+		subnegotiation(comPortSetDataSize, byte(settings.DataBits)),
+		subnegotiation(comPortSetParity, byte(settings.Parity)),
+		subnegotiation(comPortSetStopSize, byte(settings.StopBits)),
+		subnegotiation(comPortSetControl, byte(settings.FlowControl)),
+	}
+	for _, command := range commands {
+		if err := t.send(command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subnegotiation encodes a COM-PORT-OPTION subnegotiation, escaping IAC in the value.
+func subnegotiation(command byte, value ...byte) []byte {
+	b := []byte{telnetIAC, telnetSB, optionComPort, command}
+	for _, v := range value {
+		if b = append(b, v); v == telnetIAC {
+			b = append(b, telnetIAC)
+		}
+	}
+	return append(b, telnetIAC, telnetSE)
+}
+
+func (t *telnet) send(b []byte) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	_, err := t.conn.Write(b)
+	return err
+}
+
+// Read returns the serial data, handling the telnet commands in between.
+func (t *telnet) Read(p []byte) (int, error) {
+	var n int
+	for n < len(p) {
+		if n > 0 && t.reader.Buffered() == 0 {
+			break
+		}
+		b, err := t.reader.ReadByte()
+		if err != nil {
+			if n > 0 {
+				break
+			}
+			return 0, err
+		}
+		if b != telnetIAC {
+			p[n] = b
+			n++
+			continue
+		}
+		escaped, err := t.command()
+		if err != nil {
+			return n, err
+		}
+		if escaped {
+			p[n] = telnetIAC
+			n++
+		}
+	}
+	return n, nil
+}
+
+// command handles the telnet command following IAC. It reports whether it was an escaped IAC data byte.
+func (t *telnet) command() (bool, error) {
+	command, err := t.reader.ReadByte()
+	if err != nil {
+		return false, err
+	}
+	switch command {
+	case telnetIAC:
+		return true, nil
+	case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+		option, err := t.reader.ReadByte()
+		if err != nil {
+			return false, err
+		}
+		return false, t.negotiate(command, option)
+	case telnetSB:
+		// The COM-PORT-OPTION replies of the server are informational and skipped.
+		for {
+			b, err := t.reader.ReadByte()
+			if err != nil {
+				return false, err
+			}
+			if b != telnetIAC {
+				continue
+			}
+			if b, err = t.reader.ReadByte(); err != nil || b == telnetSE {
+				return false, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// negotiate answers an option request, acknowledging only the requests that change the option state.
+func (t *telnet) negotiate(command, option byte) error {
+	supported := option == optionBinary || option == optionSGA || option == optionComPort
+	switch command {
+	case telnetDO:
+		if !supported {
+			return t.send([]byte{telnetIAC, telnetWONT, option})
+		}
+		if !t.local[option] {
+			t.local[option] = true
+			return t.send([]byte{telnetIAC, telnetWILL, option})
+		}
+	case telnetDONT:
+		if t.local[option] {
+			t.local[option] = false
+			return t.send([]byte{telnetIAC, telnetWONT, option})
+		}
+	case telnetWILL:
+		if !supported || option == optionComPort {
+			return t.send([]byte{telnetIAC, telnetDONT, option})
+		}
+		if !t.remote[option] {
+			t.remote[option] = true
+			return t.send([]byte{telnetIAC, telnetDO, option})
+		}
+	case telnetWONT:
+		if t.remote[option] {
+			t.remote[option] = false
+			return t.send([]byte{telnetIAC, telnetDONT, option})
+		}
+	}
+	return nil
+}
+
+// Write sends serial data, escaping IAC bytes.
+func (t *telnet) Write(p []byte) (int, error) {
+	b := make([]byte, 0, len(p))
+	for _, v := range p {
+		if b = append(b, v); v == telnetIAC {
+			b = append(b, telnetIAC)
+		}
+	}
+	if err := t.send(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (t *telnet) Close() error {
+	return t.conn.Close()
+}
@@ -4,6 +4,7 @@
 package at
 
 import (
+	"fmt"
 	"io"
 	"os"
 
@@ -15,13 +16,18 @@ type SerialPort struct {
 	oldTermios *unix.Termios
 }
 
-func Open(name string) (io.ReadWriteCloser, error) {
+// Open opens the serial port name in raw mode at the given baud rate.
+func Open(name string, baudRate int) (io.ReadWriteCloser, error) {
+	speed, err := speed(baudRate)
+	if err != nil {
+		return nil, err
+	}
 	f, err := os.OpenFile(name, os.O_RDWR|unix.O_NOCTTY, 0666)
 	if err != nil {
 		return nil, err
 	}
 	sp := &SerialPort{f: f}
-	if err := sp.setTermios(unix.B115200); err != nil {
+	if err := sp.setTermios(speed); err != nil {
 		f.Close()
 		return nil, err
 	}
@@ -42,7 +48,7 @@ func (sp *SerialPort) setTermios(baudRate uint32) error {
 	t.Oflag &^= unix.OPOST
 	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
 	t.Cflag &^= unix.CSIZE | unix.PARENB
-	t.Cflag |= unix.CS8
+	t.Cflag |= unix.CS8 | unix.CREAD | unix.CLOCAL
 	t.Cc[unix.VMIN] = 1
 	t.Cc[unix.VTIME] = 0
 	return unix.IoctlSetTermios(fd, unix.TIOCSETA, &t)
@@ -64,3 +70,11 @@ func (sp *SerialPort) Close() error {
 	}
 	return sp.f.Close()
 }
+
+// speed returns the termios speed of a baud rate. Darwin uses the baud rate itself.
+func speed(baudRate int) (uint32, error) {
+	if baudRate <= 0 {
+		return 0, fmt.Errorf("unsupported baud rate %d", baudRate)
+	}
+	return uint32(baudRate), nil
+}
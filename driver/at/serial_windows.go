@@ -19,7 +19,11 @@ type SerialPort struct {
 	writeEvent windows.Handle
 }
 
-func Open(port string) (io.ReadWriteCloser, error) {
+// Open opens the COM port at the given baud rate.
+func Open(port string, baudRate int) (io.ReadWriteCloser, error) {
+	if baudRate <= 0 {
+		return nil, fmt.Errorf("unsupported baud rate %d", baudRate)
+	}
 	comPort := windows.StringToUTF16Ptr("\\\\.\\" + port)
 	handle, err := windows.CreateFile(comPort,
 		windows.GENERIC_READ|windows.GENERIC_WRITE,
@@ -38,7 +42,7 @@ func Open(port string) (io.ReadWriteCloser, error) {
 		windows.CloseHandle(handle)
 		return nil, fmt.Errorf("GetCommState failed: %w", err)
 	}
-	dcb.BaudRate = 115200
+	dcb.BaudRate = uint32(baudRate)
 	dcb.ByteSize = 8
 	dcb.Parity = windows.NOPARITY
 	dcb.StopBits = windows.ONESTOPBIT
@@ -83,7 +87,18 @@ func Open(port string) (io.ReadWriteCloser, error) {
 	}, nil
 }
 
+// Read blocks until at least one byte is received.
+// The comm timeouts make ReadFile return empty reads while the modem is idle, which are retried.
 func (sp *SerialPort) Read(p []byte) (int, error) {
+	for {
+		n, err := sp.read(p)
+		if n > 0 || err != nil || len(p) == 0 {
+			return n, err
+		}
+	}
+}
+
+func (sp *SerialPort) read(p []byte) (int, error) {
 	overlapped := windows.Overlapped{HEvent: sp.readEvent}
 	var bytesRead uint32
 
@@ -1,17 +1,58 @@
 package ccid
 
 import (
+	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"regexp"
 
 	"github.com/ElMostafaIdrassi/goscard"
 	"github.com/damonto/euicc-go/apdu"
 )
 
+var ErrNoReader = errors.New("no matching reader found")
+
 type CCID interface {
 	apdu.SmartCardChannel
 	ListReaders() ([]string, error)
 	SetReader(reader string)
+	// Readers returns the readers with the state of the card inserted in them.
+	Readers() ([]Reader, error)
+	// ATR returns the answer to reset of the connected card.
+	ATR() []byte
+	// Watch reports card insertions and removals until ctx is done.
+	Watch(ctx context.Context) (<-chan Event, error)
+}
+
+// Reader is a PC/SC reader and the card inserted in it.
+type Reader struct {
+	Name    string
+	Present bool
+	ATR     []byte
+}
+
+type Options struct {
+	// ShareMode is the PC/SC share mode. It defaults to goscard.SCardShareExclusive.
+	ShareMode goscard.SCardShareMode
+	// Protocols are the acceptable protocols. It defaults to T=0 and T=1.
+	Protocols goscard.SCardProtocol
+	// TerminalCapability is sent to the card on Connect. It defaults to apdu.DefaultTerminalCapability;
+	// an empty TerminalCapability sends nothing.
+	TerminalCapability *apdu.TerminalCapability
+}
+
+func (o *Options) setDefaults() {
+	if o.ShareMode == 0 {
+		o.ShareMode = goscard.SCardShareExclusive
+	}
+	if o.Protocols == 0 {
+		o.Protocols = goscard.SCardProtocolT0 | goscard.SCardProtocolT1
+	}
+	if o.TerminalCapability == nil {
+		capability := apdu.DefaultTerminalCapability
+		o.TerminalCapability = &capability
+	}
 }
 
 type CCIDReader struct {
@@ -19,9 +60,19 @@ type CCIDReader struct {
 	card    goscard.Card
 	channel byte
 	reader  string
+	atr     []byte
+	options Options
 }
 
 func New() (CCID, error) {
+	return NewWithOptions(nil)
+}
+
+func NewWithOptions(opts *Options) (CCID, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	opts.setDefaults()
 	if err := goscard.Initialize(goscard.NewDefaultLogger(goscard.LogLevelNone)); err != nil {
 		return nil, err
 	}
@@ -29,7 +80,7 @@ func New() (CCID, error) {
 	if err != nil {
 		return nil, err
 	}
-	ccid := &CCIDReader{context: context}
+	ccid := &CCIDReader{context: context, options: *opts}
 	return ccid, nil
 }
 
@@ -44,18 +95,104 @@ func (c *CCIDReader) ListReaders() ([]string, error) {
 	return readers, nil
 }
 
+func (c *CCIDReader) Readers() ([]Reader, error) {
+	names, err := c.ListReaders()
+	if err != nil {
+		return nil, err
+	}
+	states := make([]goscard.SCardReaderState, len(names))
+	for i, name := range names {
+		states[i] = goscard.SCardReaderState{Reader: name, CurrentState: goscard.SCardStateUnaware}
+	}
+	if _, err := c.context.GetStatusChange(goscard.NewTimeout(0), states); err != nil {
+		return nil, err
+	}
+	readers := make([]Reader, len(states))
+	for i, state := range states {
+		readers[i] = reader(state)
+	}
+	return readers, nil
+}
+
 func (c *CCIDReader) SetReader(reader string) {
 	c.reader = reader
 }
 
+// SelectReader selects the first reader whose name matches the regular expression pattern,
+// preferring a reader with a card inserted.
+func SelectReader(c CCID, pattern string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	return selectReader(c, func(r Reader) bool { return re.MatchString(r.Name) })
+}
+
+// SelectReaderByATR selects the first reader holding a card whose ATR matches atr under mask.
+// A nil mask requires an exact match.
+func SelectReaderByATR(c CCID, atr, mask []byte) (string, error) {
+	return selectReader(c, func(r Reader) bool { return r.Present && matchATR(r.ATR, atr, mask) })
+}
+
+func selectReader(c CCID, match func(Reader) bool) (string, error) {
+	readers, err := c.Readers()
+	if err != nil {
+		return "", err
+	}
+	var selected *Reader
+	for i := range readers {
+		if !match(readers[i]) {
+			continue
+		}
+		if readers[i].Present {
+			selected = &readers[i]
+			break
+		}
+		if selected == nil {
+			selected = &readers[i]
+		}
+	}
+	if selected == nil {
+		return "", ErrNoReader
+	}
+	c.SetReader(selected.Name)
+	return selected.Name, nil
+}
+
+func matchATR(atr, expected, mask []byte) bool {
+	if len(atr) != len(expected) || (mask != nil && len(mask) != len(expected)) {
+		return false
+	}
+	for i := range atr {
+		m := byte(0xFF)
+		if mask != nil {
+			m = mask[i]
+		}
+		if atr[i]&m != expected[i]&m {
+			return false
+		}
+	}
+	return true
+}
+
 func (c *CCIDReader) Connect() error {
 	var err error
-	c.card, _, err = c.context.Connect(c.reader, goscard.SCardShareExclusive, goscard.SCardProtocolT0)
+	c.card, _, err = c.context.Connect(c.reader, c.options.ShareMode, c.options.Protocols)
 	if err != nil {
 		return err
 	}
-	_, err = c.Transmit([]byte{0x80, 0xAA, 0x00, 0x00, 0x0A, 0xA9, 0x08, 0x81, 0x00, 0x82, 0x01, 0x01, 0x83, 0x01, 0x07})
-	return err
+	status, _, err := c.card.Status()
+	if err != nil {
+		return err
+	}
+	if c.atr, err = hex.DecodeString(status.Atr); err != nil {
+		return fmt.Errorf("decode ATR: %w", err)
+	}
+	return c.options.TerminalCapability.Send(c.Transmit)
+}
+
+func (c *CCIDReader) ATR() []byte {
+	return c.atr
 }
 
 func (c *CCIDReader) Disconnect() error {
@@ -70,7 +207,11 @@ func (c *CCIDReader) Disconnect() error {
 }
 
 func (c *CCIDReader) Transmit(command []byte) ([]byte, error) {
-	r, _, err := c.card.Transmit(&goscard.SCardIoRequestT0, command, nil)
+	request := &goscard.SCardIoRequestT0
+	if c.card.ActiveProtocol() == goscard.SCardProtocolT1 {
+		request = &goscard.SCardIoRequestT1
+	}
+	r, _, err := c.card.Transmit(request, command, nil)
 	return r, err
 }
 
@@ -88,6 +229,7 @@ func (c *CCIDReader) OpenLogicalChannel(AID []byte) (byte, error) {
 		return 0, err
 	}
 	if sw[len(sw)-2] != 0x90 && sw[len(sw)-2] != 0x61 {
+		c.CloseLogicalChannel(c.channel)
 		return 0, fmt.Errorf("select AID: %X", sw)
 	}
 	return c.channel, nil
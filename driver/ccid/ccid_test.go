@@ -0,0 +1,137 @@
+package ccid
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ElMostafaIdrassi/goscard"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchATR(t *testing.T) {
+	atr := []byte{0x3B, 0x9F, 0x96, 0x80, 0x1F}
+	assert.True(t, matchATR(atr, []byte{0x3B, 0x9F, 0x96, 0x80, 0x1F}, nil))
+	assert.False(t, matchATR(atr, []byte{0x3B, 0x9F, 0x96, 0x80}, nil))
+	assert.True(t, matchATR(atr, []byte{0x3B, 0x00, 0x96, 0x00, 0x1F}, []byte{0xFF, 0x00, 0xFF, 0x00, 0xFF}))
+	assert.False(t, matchATR(atr, []byte{0x3B, 0x00, 0x97, 0x00, 0x1F}, []byte{0xFF, 0x00, 0xFF, 0x00, 0xFF}))
+}
+
+func TestParseReaderQuery(t *testing.T) {
+	query, err := ParseReaderQuery(url.Values{"share": {"shared"}, "atr": {"3B9F"}, "mask": {"FF00"}})
+	assert.NoError(t, err)
+	assert.Equal(t, &ReaderQuery{Shared: true, ATR: []byte{0x3B, 0x9F}, Mask: []byte{0xFF, 0x00}}, query)
+	query, err = ParseReaderQuery(url.Values{"reader": {"(?i)omnikey"}})
+	assert.NoError(t, err)
+	assert.False(t, query.Shared)
+	assert.Nil(t, query.Mask)
+	_, err = ParseReaderQuery(url.Values{"share": {"direct"}})
+	assert.EqualError(t, err, `unknown share mode "direct"`)
+	_, err = ParseReaderQuery(url.Values{"atr": {"3B9"}})
+	assert.Error(t, err)
+}
+
+func TestChanges(t *testing.T) {
+	empty := goscard.SCardReaderState{Reader: "R", CurrentState: goscard.SCardStateEmpty}
+	present := goscard.SCardReaderState{Reader: "R", EventState: goscard.SCardStatePresent | 1<<16, Atr: "3b9f"}
+
+	assert.Equal(t, []Event{{Type: EventInserted, Reader: "R", ATR: []byte{0x3B, 0x9F}}}, changes(empty, present))
+
+	previous := present
+	previous.CurrentState = previous.EventState
+	removed := goscard.SCardReaderState{Reader: "R", EventState: goscard.SCardStateEmpty | 2<<16}
+	assert.Equal(t, []Event{{Type: EventRemoved, Reader: "R", ATR: []byte{0x3B, 0x9F}}}, changes(previous, removed))
+
+	swapped := goscard.SCardReaderState{Reader: "R", EventState: goscard.SCardStatePresent | 3<<16, Atr: "3b8f"}
+	assert.Equal(t, []Event{
+		{Type: EventRemoved, Reader: "R", ATR: []byte{0x3B, 0x9F}},
+		{Type: EventInserted, Reader: "R", ATR: []byte{0x3B, 0x8F}},
+	}, changes(previous, swapped))
+
+	assert.Empty(t, changes(previous, present))
+}
+
+// fakeStatusContext attaches the readers of each step in turn, then waits until it is cancelled.
+type fakeStatusContext struct {
+	steps     []fakeStep
+	cancelled chan struct{}
+}
+
+// fakeStep is the attached readers, nil for none, and the state reported for each of them.
+type fakeStep struct {
+	readers []string
+	states  map[string]goscard.SCardReaderState
+}
+
+func (c *fakeStatusContext) ListReaders([]string) ([]string, uint64, error) {
+	if len(c.steps) == 0 || c.steps[0].readers == nil {
+		return nil, scardENoReadersAvailable, errors.New("no readers available")
+	}
+	return c.steps[0].readers, 0, nil
+}
+
+func (c *fakeStatusContext) GetStatusChange(_ goscard.Timeout, states []goscard.SCardReaderState) (uint64, error) {
+	if len(c.steps) == 0 {
+		<-c.cancelled
+		return scardECancelled, errors.New("cancelled")
+	}
+	step := c.steps[0]
+	c.steps = c.steps[1:]
+	states[0].EventState = goscard.SCardStateChanged
+	for i := range states[1:] {
+		state := step.states[states[i+1].Reader]
+		states[i+1].EventState, states[i+1].Atr = state.EventState, state.Atr
+	}
+	return 0, nil
+}
+
+func (c *fakeStatusContext) Cancel() (uint64, error) {
+	close(c.cancelled)
+	return 0, nil
+}
+
+func (c *fakeStatusContext) Release() (uint64, error) { return 0, nil }
+
+func TestWatcher_NoReaders(t *testing.T) {
+	present := map[string]goscard.SCardReaderState{
+		"R": {EventState: goscard.SCardStatePresent | 1<<16, Atr: "3b9f"},
+	}
+	w := newWatcher(&fakeStatusContext{
+		steps: []fakeStep{
+			{readers: nil},
+			{readers: []string{"R"}, states: present},
+		},
+		cancelled: make(chan struct{}),
+	})
+	ctx, cancel := context.WithCancel(t.Context())
+	go w.run(ctx)
+
+	assert.Equal(t, Event{Type: EventInserted, Reader: "R", ATR: []byte{0x3B, 0x9F}}, receive(t, w.events))
+	// The last reader is detached, the watcher keeps waiting for the next one.
+	assert.Equal(t, Event{Type: EventRemoved, Reader: "R", ATR: []byte{0x3B, 0x9F}}, receive(t, w.events))
+	select {
+	case event, ok := <-w.events:
+		t.Fatalf("unexpected event %v, open %t", event, ok)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	_, ok := <-w.events
+	assert.False(t, ok)
+}
+
+func receive(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("events closed")
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+	return Event{}
+}
@@ -0,0 +1,47 @@
+package ccid
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+)
+
+// ReaderQuery is the reader and share mode selected by the query of a "ccid" or "pcsc" driver URI.
+type ReaderQuery struct {
+	// Shared is set by "share=shared", the card is then shared with other applications.
+	Shared bool
+	// Reader is the regular expression matching the reader name.
+	Reader string
+	// ATR and Mask match the ATR of the card instead of the reader name.
+	ATR, Mask []byte
+}
+
+// ParseReaderQuery parses the "reader", "atr", "mask" and "share" query parameters.
+func ParseReaderQuery(query url.Values) (*ReaderQuery, error) {
+	q := &ReaderQuery{Reader: query.Get("reader")}
+	switch query.Get("share") {
+	case "", "exclusive":
+	case "shared":
+		q.Shared = true
+	default:
+		return nil, fmt.Errorf("unknown share mode %q", query.Get("share"))
+	}
+	var err error
+	if q.ATR, err = hex.DecodeString(query.Get("atr")); err != nil {
+		return nil, fmt.Errorf("invalid ATR %q: %w", query.Get("atr"), err)
+	}
+	if query.Has("mask") {
+		if q.Mask, err = hex.DecodeString(query.Get("mask")); err != nil {
+			return nil, fmt.Errorf("invalid ATR mask %q: %w", query.Get("mask"), err)
+		}
+	}
+	return q, nil
+}
+
+// Select selects the reader by ATR when it is set, or else by name.
+func (q *ReaderQuery) Select(c CCID) (string, error) {
+	if len(q.ATR) > 0 {
+		return SelectReaderByATR(c, q.ATR, q.Mask)
+	}
+	return SelectReader(c, q.Reader)
+}
@@ -0,0 +1,41 @@
+package ccid
+
+import (
+	"net/url"
+
+	"github.com/ElMostafaIdrassi/goscard"
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/euicc-go/driver"
+)
+
+// The CCID driver registers the "ccid" scheme. The reader is selected by the regular expression "reader",
+// or by "atr" and the optional "mask" in hex, and defaults to the first reader holding a card:
+//
+//	ccid:?reader=(?i)omnikey&share=shared
+//	ccid:?atr=3B9F96801F878031E073FE211B674A4C753034054BA9&mask=FFFFFFFFFFFFFFFFFFFFFFFFFFFF00000000000000
+//
+// The share mode is "exclusive" or "shared" and defaults to "exclusive".
+func init() {
+	driver.Register("ccid", open)
+}
+
+func open(u *url.URL) (apdu.SmartCardChannel, error) {
+	query, err := ParseReaderQuery(u.Query())
+	if err != nil {
+		return nil, err
+	}
+	opts := &Options{ShareMode: goscard.SCardShareExclusive}
+	if query.Shared {
+		opts.ShareMode = goscard.SCardShareShared
+	}
+	c, err := NewWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = query.Select(c); err != nil {
+		c.(*CCIDReader).context.Release()
+		goscard.Finalize()
+		return nil, err
+	}
+	return c, nil
+}
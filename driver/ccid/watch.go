@@ -0,0 +1,179 @@
+package ccid
+
+import (
+	"context"
+	"encoding/hex"
+
+	"github.com/ElMostafaIdrassi/goscard"
+)
+
+// pnpNotification is the pseudo reader that changes state when a reader is attached or detached.
+const pnpNotification = `\\?PnP?\Notification`
+
+// PC/SC return codes handled by the watcher.
+const (
+	scardECancelled          = 0x80100002
+	scardEUnknownReader      = 0x80100009
+	scardETimeout            = 0x8010000A
+	scardENoReadersAvailable = 0x8010002E
+)
+
+type EventType int
+
+const (
+	EventInserted EventType = iota + 1
+	EventRemoved
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventInserted:
+		return "inserted"
+	case EventRemoved:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// Event is a card insertion or removal. ATR is the answer to reset of the inserted or removed card.
+type Event struct {
+	Type   EventType
+	Reader string
+	ATR    []byte
+}
+
+// Watch reports card insertions and removals in every reader until ctx is done, then closes the channel.
+// Cards already inserted when Watch is called are reported as inserted.
+// The watcher uses its own PC/SC context, so it does not interfere with the connected card.
+func (c *CCIDReader) Watch(ctx context.Context) (<-chan Event, error) {
+	watcherContext, _, err := goscard.NewContext(goscard.SCardScopeSystem, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	w := newWatcher(&watcherContext)
+	go w.run(ctx)
+	return w.events, nil
+}
+
+// statusContext is the part of the PC/SC context used by the watcher.
+type statusContext interface {
+	ListReaders(groups []string) ([]string, uint64, error)
+	GetStatusChange(timeout goscard.Timeout, states []goscard.SCardReaderState) (uint64, error)
+	Cancel() (uint64, error)
+	Release() (uint64, error)
+}
+
+type watcher struct {
+	context statusContext
+	events  chan Event
+	pnp     goscard.SCardReaderState
+	readers map[string]goscard.SCardReaderState
+}
+
+func newWatcher(context statusContext) *watcher {
+	return &watcher{
+		context: context,
+		events:  make(chan Event),
+		pnp:     goscard.SCardReaderState{Reader: pnpNotification},
+		readers: make(map[string]goscard.SCardReaderState),
+	}
+}
+
+func (w *watcher) run(ctx context.Context) {
+	defer close(w.events)
+	defer w.context.Release()
+	stop := context.AfterFunc(ctx, func() { w.context.Cancel() })
+	defer stop()
+	for ctx.Err() == nil {
+		states, ok := w.states(ctx)
+		if !ok {
+			return
+		}
+		ret, err := w.context.GetStatusChange(goscard.NewInfiniteTimeout(), states)
+		if err != nil {
+			switch ret {
+			case scardETimeout, scardEUnknownReader, scardENoReadersAvailable:
+				continue
+			}
+			return
+		}
+		w.pnp.CurrentState = states[0].EventState
+		for _, state := range states[1:] {
+			previous := w.readers[state.Reader]
+			if !w.emit(ctx, changes(previous, state)...) {
+				return
+			}
+			state.CurrentState = state.EventState
+			w.readers[state.Reader] = state
+		}
+	}
+}
+
+// states returns the reader states to wait on, reporting the removal of the cards in detached readers.
+// Without any reader, only the PnP pseudo reader is waited on, until a reader is attached.
+func (w *watcher) states(ctx context.Context) ([]goscard.SCardReaderState, bool) {
+	names, ret, err := w.context.ListReaders(nil)
+	if err != nil && ret != scardENoReadersAvailable {
+		return nil, false
+	}
+	attached := make(map[string]bool, len(names))
+	states := []goscard.SCardReaderState{w.pnp}
+	for _, name := range names {
+		attached[name] = true
+		state, ok := w.readers[name]
+		if !ok {
+			state = goscard.SCardReaderState{Reader: name, CurrentState: goscard.SCardStateUnaware}
+		}
+		states = append(states, state)
+	}
+	for name, state := range w.readers {
+		if attached[name] {
+			continue
+		}
+		delete(w.readers, name)
+		if !w.emit(ctx, changes(state, goscard.SCardReaderState{Reader: name})...) {
+			return nil, false
+		}
+	}
+	return states, true
+}
+
+func (w *watcher) emit(ctx context.Context, events ...Event) bool {
+	for _, event := range events {
+		select {
+		case w.events <- event:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// changes returns the events between the previous and the current state of a reader.
+// The event counter in the upper 16 bits reveals a card swapped between two calls.
+func changes(previous, current goscard.SCardReaderState) []Event {
+	was := previous.CurrentState&goscard.SCardStatePresent != 0
+	is := current.EventState&goscard.SCardStatePresent != 0
+	swapped := was && is && previous.CurrentState>>16 != current.EventState>>16
+	var events []Event
+	if was && (!is || swapped) {
+		events = append(events, Event{Type: EventRemoved, Reader: previous.Reader, ATR: atr(previous)})
+	}
+	if is && (!was || swapped) {
+		events = append(events, Event{Type: EventInserted, Reader: current.Reader, ATR: atr(current)})
+	}
+	return events
+}
+
+func reader(state goscard.SCardReaderState) Reader {
+	r := Reader{Name: state.Reader, Present: state.EventState&goscard.SCardStatePresent != 0}
+	if r.Present {
+		r.ATR = atr(state)
+	}
+	return r
+}
+
+func atr(state goscard.SCardReaderState) []byte {
+	b, _ := hex.DecodeString(state.Atr)
+	return b
+}
@@ -0,0 +1,80 @@
+package gsmtap
+
+import (
+	"sync"
+
+	"github.com/damonto/euicc-go/apdu"
+)
+
+// Port is the well-known UDP port Wireshark uses to dissect GSMTAP frames.
+const Port = 4729
+
+const (
+	version    = 0x02
+	headerSize = 16
+	typeSIM    = 0x04
+)
+
+// SIM sub-types as defined in osmocom's gsmtap.h
+const (
+	SubTypeAPDU byte = 0x00
+	SubTypeATR  byte = 0x01
+)
+
+// Writer receives every APDU exchanged with the card.
+type Writer interface {
+	// WriteAPDU records a single command and the response returned by the card.
+	WriteAPDU(command, response []byte) error
+	Close() error
+}
+
+// Frame builds a GSMTAP SIM frame carrying the command followed by the response,
+// which is the layout the Wireshark GSM SIM dissector expects.
+func Frame(subType byte, command, response []byte) []byte {
+	frame := make([]byte, headerSize, headerSize+len(command)+len(response))
+	frame[0] = version
+	frame[1] = headerSize / 4
+	frame[2] = typeSIM
+	frame[12] = subType
+	frame = append(frame, command...)
+	return append(frame, response...)
+}
+
+// Channel wraps an apdu.SmartCardChannel and mirrors every transmitted APDU to a Writer.
+type Channel struct {
+	apdu.SmartCardChannel
+	mutex  sync.Mutex
+	writer Writer
+}
+
+// NewChannel returns a channel that forwards all calls to channel and captures
+// each command/response pair with writer.
+func NewChannel(channel apdu.SmartCardChannel, writer Writer) apdu.SmartCardChannel {
+	return &Channel{SmartCardChannel: channel, writer: writer}
+}
+
+// Transmit sends the command to the underlying channel and captures the exchange.
+// Capture errors never fail the transmission.
+func (c *Channel) Transmit(command []byte) ([]byte, error) {
+	response, err := c.SmartCardChannel.Transmit(command)
+	if len(response) > 0 {
+		c.mutex.Lock()
+		_ = c.writer.WriteAPDU(command, response)
+		c.mutex.Unlock()
+	}
+	return response, err
+}
+
+// Unwrap returns the underlying channel.
+func (c *Channel) Unwrap() apdu.SmartCardChannel {
+	return c.SmartCardChannel
+}
+
+// Disconnect disconnects the underlying channel and closes the writer.
+func (c *Channel) Disconnect() error {
+	err := c.SmartCardChannel.Disconnect()
+	if closeErr := c.writer.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package gsmtap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrame(t *testing.T) {
+	frame := Frame(SubTypeAPDU, []byte{0x80, 0xE2, 0x91, 0x00, 0x03, 0xBF, 0x3E, 0x00}, []byte{0x90, 0x00})
+	expected := []byte{
+		0x02, 0x04, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x80, 0xE2, 0x91, 0x00, 0x03, 0xBF, 0x3E, 0x00,
+		0x90, 0x00,
+	}
+	assert.Equal(t, expected, frame)
+}
+
+func TestDatagram(t *testing.T) {
+	packet := datagram(1, []byte{0x01, 0x02})
+	assert.Len(t, packet, 30)
+	assert.Equal(t, uint16(30), binary.BigEndian.Uint16(packet[2:4]))
+	assert.Equal(t, uint16(0), checksum(packet[:20]))
+	assert.Equal(t, uint16(Port), binary.BigEndian.Uint16(packet[22:24]))
+}
+
+func TestPcapWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewPcapWriter(&buf)
+	assert.NoError(t, err)
+	assert.NoError(t, w.WriteAPDU([]byte{0x00, 0xA4, 0x04, 0x00}, []byte{0x90, 0x00}))
+	data := buf.Bytes()
+	assert.Equal(t, uint32(0xA1B2C3D4), binary.LittleEndian.Uint32(data[0:4]))
+	assert.Equal(t, uint32(linkTypeIPv4), binary.LittleEndian.Uint32(data[20:24]))
+	assert.Equal(t, uint32(28+16+6), binary.LittleEndian.Uint32(data[32:36]))
+	assert.Len(t, data, 24+16+28+16+6)
+}
+
+func TestPcapNGWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewPcapNGWriter(&buf)
+	assert.NoError(t, err)
+	assert.NoError(t, w.WriteAPDU([]byte{0x00, 0xA4, 0x04, 0x00}, []byte{0x90, 0x00}))
+	data := buf.Bytes()
+	assert.Equal(t, uint32(0x0A0D0D0A), binary.LittleEndian.Uint32(data[0:4]))
+	assert.Zero(t, len(data)%4)
+	for offset := 0; offset < len(data); {
+		length := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		assert.Equal(t, uint32(length), binary.LittleEndian.Uint32(data[offset+length-4:offset+length]))
+		offset += length
+	}
+}
@@ -0,0 +1,175 @@
+package gsmtap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// linkTypeIPv4 is LINKTYPE_IPV4, GSMTAP frames are stored inside IPv4/UDP datagrams
+// so that Wireshark picks the GSMTAP dissector by port.
+const linkTypeIPv4 = 228
+
+const snapLen = 0xFFFF
+
+// PcapWriter writes GSMTAP frames to a classic libpcap capture file.
+type PcapWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+	seq   uint16
+}
+
+// NewPcapWriter writes the pcap global header to w and returns a Writer for it.
+func NewPcapWriter(w io.Writer) (*PcapWriter, error) {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], 0xA1B2C3D4)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], snapLen)
+	binary.LittleEndian.PutUint32(header[20:24], linkTypeIPv4)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &PcapWriter{w: w}, nil
+}
+
+// WriteAPDU implements Writer.
+func (p *PcapWriter) WriteAPDU(command, response []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.seq++
+	packet := datagram(p.seq, Frame(SubTypeAPDU, command, response))
+	now := time.Now()
+	record := make([]byte, 16, 16+len(packet))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:12], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(record[12:16], uint32(len(packet)))
+	_, err := p.w.Write(append(record, packet...))
+	return err
+}
+
+// Close closes the underlying writer if it implements io.Closer.
+func (p *PcapWriter) Close() error {
+	if c, ok := p.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// PcapNGWriter writes GSMTAP frames to a pcapng capture file.
+type PcapNGWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+	seq   uint16
+}
+
+// NewPcapNGWriter writes the section header and interface description blocks to w.
+func NewPcapNGWriter(w io.Writer) (*PcapNGWriter, error) {
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:4], 0x1A2B3C4D)
+	binary.LittleEndian.PutUint16(shb[4:6], 1)
+	binary.LittleEndian.PutUint64(shb[8:16], 0xFFFFFFFFFFFFFFFF)
+	if _, err := w.Write(block(0x0A0D0D0A, shb)); err != nil {
+		return nil, err
+	}
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:2], linkTypeIPv4)
+	binary.LittleEndian.PutUint32(idb[4:8], snapLen)
+	if _, err := w.Write(block(0x00000001, idb)); err != nil {
+		return nil, err
+	}
+	return &PcapNGWriter{w: w}, nil
+}
+
+// WriteAPDU implements Writer.
+func (p *PcapNGWriter) WriteAPDU(command, response []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.seq++
+	packet := datagram(p.seq, Frame(SubTypeAPDU, command, response))
+	ts := uint64(time.Now().UnixMicro())
+	epb := make([]byte, 20, 20+len(packet)+3)
+	binary.LittleEndian.PutUint32(epb[4:8], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(epb[8:12], uint32(ts))
+	binary.LittleEndian.PutUint32(epb[12:16], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(epb[16:20], uint32(len(packet)))
+	epb = append(epb, packet...)
+	_, err := p.w.Write(block(0x00000006, epb))
+	return err
+}
+
+// Close closes the underlying writer if it implements io.Closer.
+func (p *PcapNGWriter) Close() error {
+	if c, ok := p.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Create creates a capture file at path, choosing pcapng for the ".pcapng"
+// extension and classic pcap otherwise.
+func Create(path string) (Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	var w Writer
+	if strings.EqualFold(filepath.Ext(path), ".pcapng") {
+		w, err = NewPcapNGWriter(f)
+	} else {
+		w, err = NewPcapWriter(f)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// block wraps body into a pcapng block padded to 32 bits.
+func block(blockType uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	length := uint32(12 + len(body))
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, blockType)
+	binary.Write(buf, binary.LittleEndian, length)
+	buf.Write(body)
+	binary.Write(buf, binary.LittleEndian, length)
+	return buf.Bytes()
+}
+
+// datagram encapsulates payload into an IPv4/UDP datagram from and to 127.0.0.1:4729.
+func datagram(id uint16, payload []byte) []byte {
+	packet := make([]byte, 28, 28+len(payload))
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:4], uint16(28+len(payload)))
+	binary.BigEndian.PutUint16(packet[4:6], id)
+	packet[8] = 64
+	packet[9] = 17
+	copy(packet[12:16], []byte{127, 0, 0, 1})
+	copy(packet[16:20], []byte{127, 0, 0, 1})
+	binary.BigEndian.PutUint16(packet[10:12], checksum(packet[:20]))
+	binary.BigEndian.PutUint16(packet[20:22], Port)
+	binary.BigEndian.PutUint16(packet[22:24], Port)
+	binary.BigEndian.PutUint16(packet[24:26], uint16(8+len(payload)))
+	return append(packet, payload...)
+}
+
+func checksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i : i+2]))
+	}
+	for sum > 0xFFFF {
+		sum = (sum >> 16) + (sum & 0xFFFF)
+	}
+	return ^uint16(sum)
+}
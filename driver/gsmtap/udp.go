@@ -0,0 +1,35 @@
+package gsmtap
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// UDPWriter sends GSMTAP frames as UDP datagrams, e.g. to a local Wireshark listening on port 4729.
+type UDPWriter struct {
+	conn net.Conn
+}
+
+// NewUDPWriter dials address. If address has no port, the GSMTAP port is used.
+func NewUDPWriter(address string) (*UDPWriter, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, strconv.Itoa(Port))
+	}
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("dial gsmtap %s: %w", address, err)
+	}
+	return &UDPWriter{conn: conn}, nil
+}
+
+// WriteAPDU implements Writer.
+func (u *UDPWriter) WriteAPDU(command, response []byte) error {
+	_, err := u.conn.Write(Frame(SubTypeAPDU, command, response))
+	return err
+}
+
+// Close closes the UDP socket.
+func (u *UDPWriter) Close() error {
+	return u.conn.Close()
+}
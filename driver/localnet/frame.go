@@ -0,0 +1,58 @@
+package localnet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MaxFrameSize is the largest encoded packet accepted on a stream transport.
+const MaxFrameSize = 1 << 20
+
+// WriteMessage writes the message prefixed with its length, a 4-byte big-endian integer.
+func WriteMessage(w io.Writer, m *Message) error {
+	payload, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(payload)), uint32(len(payload)))
+	_, err = w.Write(append(frame, payload...))
+	return err
+}
+
+// ReadMessage reads a message written by WriteMessage or WriteFrame.
+// It returns io.EOF when the peer closed the stream between two messages.
+func ReadMessage(r io.Reader) (*Message, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > MaxFrameSize {
+		return nil, fmt.Errorf("frame too large: %d bytes", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("read frame: %w", err)
+	}
+	var m Message
+	if err := m.UnmarshalBinary(payload); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// WriteFrame writes the packet encoded with ProtocolGob, prefixed with its length.
+// Stream transports such as TCP need the length to find the packet boundaries.
+func WriteFrame(w io.Writer, p IPacketCmd) error {
+	return WriteMessage(w, &Message{Protocol: ProtocolGob, Packet: p})
+}
+
+// ReadFrame reads a packet written by WriteFrame or WriteMessage.
+// It returns io.EOF when the peer closed the stream between two packets.
+func ReadFrame(r io.Reader) (IPacketCmd, error) {
+	m, err := ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+	return m.Packet, nil
+}
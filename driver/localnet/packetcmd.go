@@ -16,11 +16,14 @@ const (
 	CmdCloseLogical Cmd = "clch"
 	CmdTransmit     Cmd = "tran"
 	CmdResponse     Cmd = "resp"
+	CmdHello        Cmd = "helo"
 )
 
 type IPacketCmd interface {
 	GetCmd() Cmd
 	GetErr() string
+	GetCode() ErrorCode
+	GetSession() string
 }
 
 type IPacketBody interface {
@@ -28,6 +31,12 @@ type IPacketBody interface {
 	GetBody() []byte
 }
 
+type IPacketHello interface {
+	IPacketCmd
+	GetVersions() []byte
+	GetCapabilities() Capabilities
+}
+
 type IPacketConnect interface {
 	IPacketCmd
 	GetDevice() string
@@ -38,6 +47,11 @@ type IPacketConnect interface {
 type PacketCmd struct {
 	Cmd Cmd
 	Err string
+	// Code is the type of the error, ErrorUnknown for the servers which do not report it.
+	Code ErrorCode
+	// Session is the token of the session, given by the server in the response to the connect request.
+	// Requests without a token belong to the session opened from the same address.
+	Session string
 }
 
 type PacketBody struct {
@@ -45,6 +59,14 @@ type PacketBody struct {
 	Body []byte
 }
 
+// PacketHello negotiates the protocol version and the capabilities, see README.md.
+// The request lists the versions supported by the client, the response has the version chosen by the server.
+type PacketHello struct {
+	PacketCmd
+	Versions     []byte
+	Capabilities Capabilities
+}
+
 type PacketConnect struct {
 	PacketCmd
 	Device string
@@ -56,6 +78,7 @@ func Decode(byteArray []byte) (p IPacketCmd, e error) {
 	gob.Register(&PacketCmd{})
 	gob.Register(&PacketBody{})
 	gob.Register(&PacketConnect{})
+	gob.Register(&PacketHello{})
 
 	// Decomprimi
 	gr, err := gzip.NewReader(bytes.NewReader(byteArray))
@@ -74,6 +97,7 @@ func Encode(p IPacketCmd) (byteArray []byte, err error) {
 	gob.Register(&PacketCmd{})
 	gob.Register(&PacketBody{})
 	gob.Register(&PacketConnect{})
+	gob.Register(&PacketHello{})
 
 	var buf bytes.Buffer
 
@@ -102,10 +126,29 @@ func (p PacketCmd) GetErr() string {
 	return p.Err
 }
 
+func (p PacketCmd) GetCode() ErrorCode {
+	if p.Err != "" && p.Code == ErrorNone {
+		return ErrorUnknown
+	}
+	return p.Code
+}
+
+func (p PacketCmd) GetSession() string {
+	return p.Session
+}
+
 func (p PacketBody) GetBody() []byte {
 	return p.Body
 }
 
+func (p PacketHello) GetVersions() []byte {
+	return p.Versions
+}
+
+func (p PacketHello) GetCapabilities() Capabilities {
+	return p.Capabilities
+}
+
 func (p PacketConnect) GetDevice() string {
 	return p.Device
 }
@@ -130,22 +173,54 @@ func (p PacketBody) String() string {
 	return fmt.Sprintf("%s, Body(size): %4d, Body(hex): %X", p.PacketCmd, len(p.GetBody()), p.GetBody())
 }
 
+func (p PacketHello) String() string {
+	return fmt.Sprintf("%s, Versions: %v, Capabilities: %08X", p.PacketCmd, p.GetVersions(), uint32(p.GetCapabilities()))
+}
+
 func (p PacketConnect) String() string {
 	return fmt.Sprintf("%s, Device: %s, Proto: %s, Slot: %d", p.PacketCmd, p.GetDevice(), p.GetProto(), p.GetSlot())
 }
 
 func NewPacketCmd(cmd Cmd) IPacketCmd {
-	return PacketCmd{cmd, ""}
+	return PacketCmd{Cmd: cmd}
 }
 
 func NewPacketCmdErr(cmd Cmd, err string) IPacketCmd {
-	return PacketCmd{cmd, err}
+	return PacketCmd{Cmd: cmd, Err: err}
+}
+
+// NewPacketCmdCode returns an error response of the given type.
+func NewPacketCmdCode(cmd Cmd, code ErrorCode, err string) IPacketCmd {
+	return PacketCmd{Cmd: cmd, Err: err, Code: code}
 }
 
 func NewPacketBody(cmd Cmd, body []byte) IPacketCmd {
-	return PacketBody{PacketCmd{cmd, ""}, body}
+	return PacketBody{PacketCmd{Cmd: cmd}, body}
 }
 
 func NewPacketConnect(device string, proto string, slot uint8) IPacketCmd {
-	return PacketConnect{PacketCmd{CmdConnect, ""}, device, proto, slot}
+	return PacketConnect{PacketCmd{Cmd: CmdConnect}, device, proto, slot}
+}
+
+func NewPacketHello(cmd Cmd, versions []byte, capabilities Capabilities) IPacketCmd {
+	return PacketHello{PacketCmd{Cmd: cmd}, versions, capabilities}
+}
+
+// WithSession returns a copy of the packet carrying the session token.
+func WithSession(p IPacketCmd, session string) IPacketCmd {
+	switch p := p.(type) {
+	case PacketCmd:
+		p.Session = session
+		return p
+	case PacketBody:
+		p.Session = session
+		return p
+	case PacketConnect:
+		p.Session = session
+		return p
+	case PacketHello:
+		p.Session = session
+		return p
+	}
+	return p
 }
@@ -0,0 +1,91 @@
+package localnet
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/euicc-go/driver"
+)
+
+// The localnet driver registers the "localnet" scheme, which reaches a device attached to a remote server, e.g.
+// "localnet://192.168.11.100:8080/dev/cdc-wdm0?proto=qrtr&slot=2&buffer=2048".
+// The path is the remote device and proto the driver used by the server. The buffer size defaults to 2048 bytes.
+//
+// "localnet+tcp" and "localnet+tls" use the TCP transport instead of UDP, e.g.
+// "localnet+tls://server.example.com:8080/dev/cdc-wdm0?proto=qmi&slot=1". The buffer size is not used.
+// With TLS, the server certificate is verified with the "ca" file, or the system roots,
+// and the "cert" and "key" files are the client certificate of mutual TLS.
+//
+// The packets are encoded with the binary protocol described in README.md,
+// "wire=gob" selects the original gob encoding for the servers which do not support it.
+func init() {
+	driver.Register("localnet", open)
+	driver.Register("localnet+tcp", open)
+	driver.Register("localnet+tls", open)
+}
+
+func open(u *url.URL) (apdu.SmartCardChannel, error) {
+	query := u.Query()
+	if !query.Has("proto") {
+		return nil, errors.New("missing proto")
+	}
+	slot, err := driver.QuerySlot(u)
+	if err != nil {
+		return nil, err
+	}
+	var protocol Protocol
+	switch query.Get("wire") {
+	case "", "v1":
+		protocol = ProtocolV1
+	case "gob":
+		protocol = ProtocolGob
+	default:
+		return nil, fmt.Errorf("unknown wire protocol %q (v1 or gob)", query.Get("wire"))
+	}
+	switch u.Scheme {
+	case "localnet+tcp":
+		return NewTCP(u.Host, u.Path, query.Get("proto"), slot, &TCPOptions{Protocol: protocol})
+	case "localnet+tls":
+		config, err := clientTLS(u)
+		if err != nil {
+			return nil, err
+		}
+		return NewTCP(u.Host, u.Path, query.Get("proto"), slot, &TCPOptions{TLS: config, Protocol: protocol})
+	}
+	var bufferSize uint64
+	if value := query.Get("buffer"); value != "" {
+		if bufferSize, err = strconv.ParseUint(value, 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid buffer size %q: %w", value, err)
+		}
+	}
+	return NewUDPWithOptions(u.Host, u.Path, query.Get("proto"), slot, &UDPOptions{BufferSize: uint16(bufferSize), Protocol: protocol})
+}
+
+func clientTLS(u *url.URL) (*tls.Config, error) {
+	query := u.Query()
+	config := &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	if ca := query.Get("ca"); ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", ca)
+		}
+	}
+	if query.Has("cert") {
+		certificate, err := tls.LoadX509KeyPair(query.Get("cert"), query.Get("key"))
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
@@ -1,53 +1,127 @@
 package localnet
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"time"
 
 	"github.com/damonto/euicc-go/apdu"
 )
 
 type NetContext struct {
 	serverAddr string
-	rAddr      *net.UDPAddr
-	conn       *net.UDPConn
-	device     string
-	proto      string
-	slot       uint8
-	bufferSize uint16
+	dial       func() (net.Conn, error)
+	conn       net.Conn
+	// stream is set for the TCP transports, which send length-prefixed frames instead of datagrams.
+	stream   bool
+	timeout  time.Duration
+	protocol Protocol
+	// id is the ID of the last request, the responses with another ID are late answers to previous requests.
+	id uint32
+	// capabilities are the capabilities of the server, given in the response to the hello request.
+	capabilities Capabilities
+	session      string
+	device       string
+	proto        string
+	slot         uint8
+	bufferSize   uint16
 }
 
 type NetConf struct {
 }
 
+// UDPOptions configures the UDP transport.
+type UDPOptions struct {
+	// BufferSize is the size of the buffer receiving a response. It defaults to 2048 bytes.
+	BufferSize uint16
+	// Timeout is the time to wait for each response, e.g. when a datagram is lost. It defaults to 60 seconds.
+	Timeout time.Duration
+	// Protocol is the encoding of the packets. It defaults to ProtocolV1,
+	// ProtocolGob reaches the servers which do not support it.
+	Protocol Protocol
+}
+
+func (opts *UDPOptions) setDefaults() {
+	if opts.BufferSize == 0 {
+		opts.BufferSize = 2048
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 60 * time.Second
+	}
+}
+
+// NewUDP reaches the server over UDP with ProtocolGob, which every server supports.
+// Use NewUDPWithOptions for ProtocolV1.
 func NewUDP(serverAddr string, device string, proto string, slot uint8, bufferSize uint16) (apdu.SmartCardChannel, error) {
+	return NewUDPWithOptions(serverAddr, device, proto, slot, &UDPOptions{BufferSize: bufferSize, Protocol: ProtocolGob})
+}
+
+// NewUDPWithOptions reaches the server over UDP, one message per datagram.
+func NewUDPWithOptions(serverAddr string, device string, proto string, slot uint8, opts *UDPOptions) (apdu.SmartCardChannel, error) {
+	if opts == nil {
+		opts = new(UDPOptions)
+	}
+	opts.setDefaults()
 	rAddr, err := net.ResolveUDPAddr("udp", serverAddr)
 	if err != nil {
 		return nil, fmt.Errorf("error resolving address: %s %w", serverAddr, err)
 	}
 
-	netctx := &NetContext{serverAddr: serverAddr, rAddr: rAddr, device: device, proto: proto, slot: slot, bufferSize: bufferSize}
+	netctx := &NetContext{serverAddr: serverAddr, timeout: opts.Timeout, protocol: opts.Protocol, device: device, proto: proto, slot: slot, bufferSize: opts.BufferSize}
+	netctx.dial = func() (net.Conn, error) {
+		return net.DialUDP("udp", nil, rAddr)
+	}
 	return netctx, nil
 }
 
 func (c *NetContext) Connect() error {
-	conn, err := net.DialUDP("udp", nil, c.rAddr)
+	conn, err := c.dial()
 	if err != nil {
-		return fmt.Errorf("error establishing connection with %s %w", c.rAddr, err)
+		return fmt.Errorf("error establishing connection with %s %w", c.serverAddr, err)
 	}
 	c.conn = conn
 
+	if c.protocol == ProtocolV1 {
+		if err = c.hello(); err != nil {
+			c.conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
 	_, err = remoteCall(c, NewPacketConnect(c.device, c.proto, c.slot))
 	return err
 }
 
+// hello negotiates the protocol version and the capabilities with the server.
+func (c *NetContext) hello() error {
+	pcRcv, err := call(c, NewPacketHello(CmdHello, []byte{ProtocolVersion}, CapabilitySessions|CapabilityRoles))
+	if err != nil {
+		return fmt.Errorf("hello: %w", err)
+	}
+	hello, ok := pcRcv.(IPacketHello)
+	if !ok || !bytes.Equal(hello.GetVersions(), []byte{ProtocolVersion}) {
+		return fmt.Errorf("hello: unexpected response %s", pcRcv)
+	}
+	c.capabilities = hello.GetCapabilities()
+	return nil
+}
+
+// Capabilities returns the capabilities of the server, known once connected with ProtocolV1.
+func (c *NetContext) Capabilities() Capabilities {
+	return c.capabilities
+}
+
 func (c *NetContext) Disconnect() error {
 	var err error
 	if c.conn != nil {
 		_, err = remoteCall(c, NewPacketCmd(CmdDisconnect))
 		c.conn.Close()
 		c.conn = nil
+		c.session = ""
+		c.capabilities = 0
 	}
 	return err
 }
@@ -72,37 +146,96 @@ func (c *NetContext) CloseLogicalChannel(channel byte) error {
 }
 
 func remoteCall(nc *NetContext, pcSnd IPacketCmd) (by []byte, er error) {
+	pcRcv, err := call(nc, pcSnd)
+	if err != nil {
+		return nil, err
+	}
+	if ext, ok := pcRcv.(IPacketBody); ok {
+		return ext.GetBody(), nil
+	}
+	return nil, nil
+}
+
+// call sends the request and returns the response, or the error reported by the server.
+func call(nc *NetContext, pcSnd IPacketCmd) (IPacketCmd, error) {
+	if nc.conn == nil {
+		return nil, errors.New("not connected")
+	}
+	if nc.timeout > 0 {
+		nc.conn.SetDeadline(time.Now().Add(nc.timeout))
+	}
+	if nc.session != "" {
+		pcSnd = WithSession(pcSnd, nc.session)
+	}
 
-	byteToTransmit, err1 := Encode(pcSnd)
+	nc.id++
+	request := &Message{Protocol: nc.protocol, ID: nc.id, Packet: pcSnd}
+	var response *Message
+	var err error
+	if nc.stream {
+		response, err = streamCall(nc.conn, request)
+	} else {
+		response, err = datagramCall(nc, request)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pcRcv := response.Packet
+	if err := serverError(pcRcv); err != nil {
+		return nil, err
+	}
+	if pcRcv.GetSession() != "" {
+		nc.session = pcRcv.GetSession()
+	}
+	return pcRcv, nil
+}
+
+func datagramCall(nc *NetContext, request *Message) (*Message, error) {
+	byteToTransmit, err1 := request.MarshalBinary()
 	if err1 != nil {
-		return nil, fmt.Errorf("error encoding message %s %w", pcSnd, err1)
+		return nil, fmt.Errorf("error encoding message %s %w", request.Packet, err1)
 	}
 
 	_, err2 := nc.conn.Write(byteToTransmit)
 	if err2 != nil {
-		return nil, fmt.Errorf("error sending message %s %w", pcSnd, err2)
+		return nil, fmt.Errorf("error sending message %s %w", request.Packet, err2)
 	}
 
 	if nc.bufferSize <= 0 {
 		nc.bufferSize = 2048
 	}
 	buffer := make([]byte, nc.bufferSize)
-	n, _, err3 := nc.conn.ReadFromUDP(buffer)
-	if err3 != nil {
-		return nil, fmt.Errorf("error receiving response %X %w", buffer, err3)
+	for {
+		n, err3 := nc.conn.Read(buffer)
+		if err3 != nil {
+			return nil, fmt.Errorf("error receiving response %X %w", buffer, err3)
+		}
+
+		var response Message
+		if err4 := response.UnmarshalBinary(buffer[:n]); err4 != nil {
+			return nil, fmt.Errorf("error decoding response %X %w", buffer[:n], err4)
+		}
+		// A gob response has no ID, it answers the request.
+		if response.Protocol == ProtocolGob || response.ID == request.ID {
+			return &response, nil
+		}
 	}
+}
 
-	pcRcv, err4 := Decode(buffer[:n])
-	if err4 != nil {
-		return nil, fmt.Errorf("error decoding response %X %w", buffer[:n], err4)
+func streamCall(conn net.Conn, request *Message) (*Message, error) {
+	if err := WriteMessage(conn, request); err != nil {
+		return nil, fmt.Errorf("error sending message %s %w", request.Packet, err)
 	}
-
-	if pcRcv.GetErr() != "" {
-		return nil, fmt.Errorf("error on server %s", pcRcv.GetErr())
+	response, err := ReadMessage(conn)
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("server closed the connection: %w", err)
 	}
-
-	if ext, ok := pcRcv.(IPacketBody); ok {
-		return ext.GetBody(), nil
+	if err != nil {
+		return nil, fmt.Errorf("error receiving response %w", err)
 	}
-	return nil, nil
+	if response.Protocol == ProtocolV1 && response.ID != request.ID {
+		return nil, fmt.Errorf("unexpected response %d to request %d", response.ID, request.ID)
+	}
+	return response, nil
 }
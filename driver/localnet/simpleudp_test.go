@@ -0,0 +1,72 @@
+package localnet
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveUDP answers the requests of the protocol, and ignores the transmit requests if silent is set.
+func serveUDP(t *testing.T, protocol Protocol, silent bool) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buffer := make([]byte, 2048)
+		for {
+			n, address, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			var request Message
+			if err := request.UnmarshalBinary(buffer[:n]); err != nil || request.Protocol != protocol {
+				continue
+			}
+			response := NewPacketCmd(CmdResponse)
+			switch request.Packet.GetCmd() {
+			case CmdHello:
+				response = NewPacketHello(CmdResponse, []byte{ProtocolVersion}, CapabilitySessions)
+			case CmdTransmit:
+				if silent {
+					continue
+				}
+				response = NewPacketBody(CmdResponse, request.Packet.(IPacketBody).GetBody())
+			}
+			data, _ := (&Message{Protocol: request.Protocol, ID: request.ID, Packet: response}).MarshalBinary()
+			conn.WriteTo(data, address)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestUDP(t *testing.T) {
+	// NewUDP keeps the gob encoding of the servers which do not support ProtocolV1.
+	channel, err := NewUDP(serveUDP(t, ProtocolGob, false), "/dev/cdc-wdm0", "qmi", 1, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, channel.Connect())
+	response, err := channel.Transmit([]byte{0xAB})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xAB}, response)
+	assert.NoError(t, channel.Disconnect())
+
+	for _, protocol := range []Protocol{ProtocolV1, ProtocolGob} {
+		channel, err := NewUDPWithOptions(serveUDP(t, protocol, false), "/dev/cdc-wdm0", "qmi", 1, &UDPOptions{Protocol: protocol})
+		assert.NoError(t, err)
+		assert.NoError(t, channel.Connect())
+		response, err := channel.Transmit([]byte{0xAB})
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{0xAB}, response)
+		assert.NoError(t, channel.Disconnect())
+	}
+}
+
+func TestUDP_Timeout(t *testing.T) {
+	channel, err := NewUDPWithOptions(serveUDP(t, ProtocolV1, true), "/dev/cdc-wdm0", "qmi", 1, &UDPOptions{Timeout: 100 * time.Millisecond})
+	assert.NoError(t, err)
+	assert.NoError(t, channel.Connect())
+	_, err = channel.Transmit([]byte{0xAB})
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,50 @@
+package localnet
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/damonto/euicc-go/apdu"
+)
+
+// TCPOptions configures the TCP transport.
+type TCPOptions struct {
+	// TLS secures the connection when it is set. It defaults to nil (plain TCP).
+	TLS *tls.Config
+	// KeepAlive is the period of the TCP keep-alive probes, which detect a server that went away.
+	// It defaults to 15 seconds.
+	KeepAlive time.Duration
+	// Timeout is the time to wait for the connection and for each response. It defaults to 60 seconds.
+	Timeout time.Duration
+	// Protocol is the encoding of the packets. It defaults to ProtocolV1,
+	// ProtocolGob reaches the servers which do not support it.
+	Protocol Protocol
+}
+
+func (opts *TCPOptions) setDefaults() {
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = 15 * time.Second
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 60 * time.Second
+	}
+}
+
+// NewTCP reaches the server over TCP, or TLS when TCPOptions.TLS is set.
+// Packets are sent as length-prefixed frames, so they are neither truncated nor lost like datagrams.
+func NewTCP(serverAddr string, device string, proto string, slot uint8, opts *TCPOptions) (apdu.SmartCardChannel, error) {
+	if opts == nil {
+		opts = new(TCPOptions)
+	}
+	opts.setDefaults()
+	dialer := &net.Dialer{Timeout: opts.Timeout, KeepAlive: opts.KeepAlive}
+	netctx := &NetContext{serverAddr: serverAddr, stream: true, timeout: opts.Timeout, protocol: opts.Protocol, device: device, proto: proto, slot: slot}
+	netctx.dial = func() (net.Conn, error) {
+		if opts.TLS != nil {
+			return tls.DialWithDialer(dialer, "tcp", serverAddr, opts.TLS)
+		}
+		return dialer.Dial("tcp", serverAddr)
+	}
+	return netctx, nil
+}
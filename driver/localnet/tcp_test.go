@@ -0,0 +1,58 @@
+package localnet
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTCP(t *testing.T) {
+	for _, protocol := range []Protocol{ProtocolV1, ProtocolGob} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		defer listener.Close()
+		disconnected := make(chan struct{})
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			defer close(disconnected)
+			for {
+				request, err := ReadMessage(conn)
+				if err != nil {
+					return
+				}
+				assert.Equal(t, protocol, request.Protocol)
+				response := NewPacketCmd(CmdResponse)
+				switch request.Packet.GetCmd() {
+				case CmdHello:
+					response = NewPacketHello(CmdResponse, []byte{ProtocolVersion}, CapabilitySessions)
+				case CmdConnect:
+					if request.Packet.(IPacketConnect).GetDevice() != "/dev/cdc-wdm0" {
+						response = NewPacketCmdErr(CmdResponse, "unknown device")
+					}
+				case CmdTransmit:
+					response = NewPacketBody(CmdResponse, request.Packet.(IPacketBody).GetBody())
+				}
+				if err := WriteMessage(conn, &Message{Protocol: request.Protocol, ID: request.ID, Packet: response}); err != nil {
+					return
+				}
+			}
+		}()
+
+		channel, err := NewTCP(listener.Addr().String(), "/dev/cdc-wdm0", "qmi", 1, &TCPOptions{Protocol: protocol})
+		assert.NoError(t, err)
+		assert.NoError(t, channel.Connect())
+		// Larger than any UDP datagram.
+		command := bytes.Repeat([]byte{0xAB}, 100000)
+		response, err := channel.Transmit(command)
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(command, response))
+		assert.NoError(t, channel.Disconnect())
+		<-disconnected
+	}
+}
@@ -0,0 +1,212 @@
+package localnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Protocol is the encoding of the packets.
+type Protocol byte
+
+const (
+	// ProtocolV1 is the binary TLV encoding described in README.md.
+	ProtocolV1 Protocol = iota
+	// ProtocolGob is the original gob+gzip encoding, kept for the peers which do not speak ProtocolV1.
+	ProtocolGob
+)
+
+// ProtocolVersion is the latest version of the binary protocol.
+const ProtocolVersion = 1
+
+// Capabilities are the optional features negotiated with the hello packets.
+type Capabilities uint32
+
+const (
+	// CapabilitySessions means the server answers the connect request with a session token.
+	CapabilitySessions Capabilities = 1 << iota
+	// CapabilityRoles means the server restricts read-only clients to the ES10 read requests.
+	CapabilityRoles
+)
+
+// ErrorCode is the type of an error reported by the server.
+type ErrorCode uint16
+
+const (
+	ErrorNone ErrorCode = iota
+	// ErrorUnknown is an error without type, e.g. reported by a gob server.
+	ErrorUnknown
+	// ErrorDriver is an error of the driver of the device.
+	ErrorDriver
+	ErrorUnknownCommand
+	ErrorUnsupportedVersion
+	ErrorNotConnected
+	ErrorAlreadyConnected
+	ErrorBusy
+	ErrorDeviceNotAllowed
+	ErrorPermissionDenied
+)
+
+// ServerError is an error reported by the server.
+type ServerError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return "error on server " + e.Message
+}
+
+// Is reports whether target is a ServerError with the same code, so errors.Is(err, &ServerError{Code: ErrorBusy}) works.
+func (e *ServerError) Is(target error) bool {
+	t, ok := target.(*ServerError)
+	return ok && t.Code == e.Code
+}
+
+// magic starts every ProtocolV1 message. A gob message starts with the gzip header instead.
+var magic = []byte{'L', 'N'}
+
+// Message types of ProtocolV1.
+var messageTypes = map[Cmd]byte{
+	CmdConnect:      0x01,
+	CmdDisconnect:   0x02,
+	CmdOpenLogical:  0x03,
+	CmdCloseLogical: 0x04,
+	CmdTransmit:     0x05,
+	CmdHello:        0x10,
+	CmdResponse:     0x80,
+}
+
+// Field tags of ProtocolV1.
+const (
+	fieldError        = 0x01
+	fieldCode         = 0x02
+	fieldSession      = 0x03
+	fieldBody         = 0x04
+	fieldDevice       = 0x05
+	fieldProto        = 0x06
+	fieldSlot         = 0x07
+	fieldVersions     = 0x08
+	fieldCapabilities = 0x09
+)
+
+// Message is a packet with its request ID and the protocol it is encoded with.
+// The response to a request has the ID and the protocol of the request.
+type Message struct {
+	Protocol Protocol
+	ID       uint32
+	Packet   IPacketCmd
+}
+
+func (m *Message) MarshalBinary() ([]byte, error) {
+	if m.Protocol == ProtocolGob {
+		return Encode(m.Packet)
+	}
+	messageType, ok := messageTypes[m.Packet.GetCmd()]
+	if !ok {
+		return nil, fmt.Errorf("unknown command %q", m.Packet.GetCmd())
+	}
+	buf := bytes.NewBuffer(append(bytes.Clone(magic), ProtocolVersion, messageType))
+	binary.Write(buf, binary.BigEndian, m.ID)
+	if m.Packet.GetErr() != "" {
+		writeField(buf, fieldError, []byte(m.Packet.GetErr()))
+		writeField(buf, fieldCode, binary.BigEndian.AppendUint16(nil, uint16(m.Packet.GetCode())))
+	}
+	if m.Packet.GetSession() != "" {
+		writeField(buf, fieldSession, []byte(m.Packet.GetSession()))
+	}
+	switch p := m.Packet.(type) {
+	case IPacketBody:
+		writeField(buf, fieldBody, p.GetBody())
+	case IPacketConnect:
+		writeField(buf, fieldDevice, []byte(p.GetDevice()))
+		writeField(buf, fieldProto, []byte(p.GetProto()))
+		writeField(buf, fieldSlot, []byte{p.GetSlot()})
+	case IPacketHello:
+		writeField(buf, fieldVersions, p.GetVersions())
+		writeField(buf, fieldCapabilities, binary.BigEndian.AppendUint32(nil, uint32(p.GetCapabilities())))
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a message of either protocol. Unknown fields are skipped.
+func (m *Message) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, magic) {
+		packet, err := Decode(data)
+		*m = Message{Protocol: ProtocolGob, Packet: packet}
+		return err
+	}
+	if len(data) < 8 {
+		return io.ErrUnexpectedEOF
+	}
+	if data[2] != ProtocolVersion {
+		return fmt.Errorf("unsupported protocol version %d", data[2])
+	}
+	var cmd Cmd
+	for c, messageType := range messageTypes {
+		if messageType == data[3] {
+			cmd = c
+		}
+	}
+	if cmd == "" {
+		return fmt.Errorf("unknown message type %02X", data[3])
+	}
+	fields := make(map[byte][]byte)
+	for rest := data[8:]; len(rest) > 0; {
+		if len(rest) < 5 {
+			return io.ErrUnexpectedEOF
+		}
+		length := binary.BigEndian.Uint32(rest[1:5])
+		if uint32(len(rest)-5) < length {
+			return io.ErrUnexpectedEOF
+		}
+		fields[rest[0]] = rest[5 : 5+length]
+		rest = rest[5+length:]
+	}
+	header := PacketCmd{Cmd: cmd, Err: string(fields[fieldError]), Session: string(fields[fieldSession])}
+	if code := fields[fieldCode]; len(code) == 2 {
+		header.Code = ErrorCode(binary.BigEndian.Uint16(code))
+	}
+	*m = Message{Protocol: ProtocolV1, ID: binary.BigEndian.Uint32(data[4:8]), Packet: header}
+	if _, ok := fields[fieldDevice]; ok || cmd == CmdConnect {
+		connect := PacketConnect{PacketCmd: header, Device: string(fields[fieldDevice]), Proto: string(fields[fieldProto])}
+		if slot := fields[fieldSlot]; len(slot) == 1 {
+			connect.Slot = slot[0]
+		}
+		m.Packet = connect
+	} else if versions, ok := fields[fieldVersions]; ok {
+		hello := PacketHello{PacketCmd: header, Versions: versions}
+		if capabilities := fields[fieldCapabilities]; len(capabilities) == 4 {
+			hello.Capabilities = Capabilities(binary.BigEndian.Uint32(capabilities))
+		}
+		m.Packet = hello
+	} else if body, ok := fields[fieldBody]; ok {
+		m.Packet = PacketBody{PacketCmd: header, Body: body}
+	}
+	switch cmd {
+	case CmdHello:
+		if _, ok := m.Packet.(PacketHello); !ok {
+			return fmt.Errorf("%s message without versions", cmd)
+		}
+	case CmdOpenLogical, CmdCloseLogical, CmdTransmit:
+		if _, ok := m.Packet.(PacketBody); !ok {
+			return fmt.Errorf("%s message without body", cmd)
+		}
+	}
+	return nil
+}
+
+func writeField(buf *bytes.Buffer, tag byte, value []byte) {
+	buf.WriteByte(tag)
+	binary.Write(buf, binary.BigEndian, uint32(len(value)))
+	buf.Write(value)
+}
+
+// serverError returns the error reported in a response packet, or nil.
+func serverError(p IPacketCmd) error {
+	if p.GetErr() == "" {
+		return nil
+	}
+	return &ServerError{Code: p.GetCode(), Message: p.GetErr()}
+}
@@ -0,0 +1,58 @@
+package localnet
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessage(t *testing.T) {
+	packets := []IPacketCmd{
+		NewPacketHello(CmdHello, []byte{1, 2}, CapabilitySessions|CapabilityRoles),
+		NewPacketConnect("/dev/cdc-wdm0", "qmi", 2),
+		WithSession(NewPacketBody(CmdTransmit, []byte{0x80, 0xE2, 0x91, 0x00}), "0123abcd"),
+		NewPacketCmdCode(CmdResponse, ErrorBusy, "error: device qmi:/dev/cdc-wdm0 is busy"),
+	}
+	for _, protocol := range []Protocol{ProtocolV1, ProtocolGob} {
+		for _, packet := range packets {
+			data, err := (&Message{Protocol: protocol, ID: 42, Packet: packet}).MarshalBinary()
+			assert.NoError(t, err)
+			var m Message
+			assert.NoError(t, m.UnmarshalBinary(data))
+			assert.Equal(t, protocol, m.Protocol)
+			assert.Equal(t, fmt.Sprint(packet), fmt.Sprint(m.Packet))
+			if protocol == ProtocolV1 {
+				assert.Equal(t, uint32(42), m.ID)
+			}
+		}
+	}
+}
+
+func TestMessage_V1(t *testing.T) {
+	data, err := (&Message{ID: 7, Packet: NewPacketBody(CmdTransmit, []byte{0xAB})}).MarshalBinary()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{'L', 'N', 0x01, 0x05, 0x00, 0x00, 0x00, 0x07, 0x04, 0x00, 0x00, 0x00, 0x01, 0xAB}, data)
+
+	// Unknown fields are skipped.
+	var m Message
+	assert.NoError(t, m.UnmarshalBinary(append(data, 0x7F, 0x00, 0x00, 0x00, 0x01, 0x00)))
+	assert.Equal(t, []byte{0xAB}, m.Packet.(IPacketBody).GetBody())
+
+	assert.Error(t, m.UnmarshalBinary(data[:len(data)-1]))
+
+	// The requests missing the fields the server reads are rejected.
+	assert.EqualError(t, m.UnmarshalBinary(data[:8]), "tran message without body")
+	data, err = (&Message{ID: 7, Packet: NewPacketCmd(CmdHello)}).MarshalBinary()
+	assert.NoError(t, err)
+	assert.EqualError(t, m.UnmarshalBinary(data), "helo message without versions")
+}
+
+func TestServerError(t *testing.T) {
+	err := serverError(NewPacketCmdCode(CmdResponse, ErrorBusy, "busy"))
+	assert.True(t, errors.Is(err, &ServerError{Code: ErrorBusy}))
+	assert.False(t, errors.Is(err, &ServerError{Code: ErrorDriver}))
+	assert.Equal(t, ErrorUnknown, serverError(NewPacketCmdErr(CmdResponse, "error")).(*ServerError).Code)
+	assert.NoError(t, serverError(NewPacketCmd(CmdResponse)))
+}
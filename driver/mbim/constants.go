@@ -59,10 +59,24 @@ const (
 const (
 	CIDProxyControlConfiguration = 0x00000001
 	CIDProxyControlVersion       = 0x00000002
+	CIDSysCaps                   = 0x00000005
 	CIDDeviceSlotMappings        = 0x00000007
 	CIDSlotInfoStatus            = 0x00000008
 )
 
+// MBIM UICC Slot States
+const (
+	MBIMUiccSlotStateUnknown              = 0x00000000
+	MBIMUiccSlotStateOffEmpty             = 0x00000001
+	MBIMUiccSlotStateOff                  = 0x00000002
+	MBIMUiccSlotStateEmpty                = 0x00000003
+	MBIMUiccSlotStateNotReady             = 0x00000004
+	MBIMUiccSlotStateActive               = 0x00000005
+	MBIMUiccSlotStateError                = 0x00000006
+	MBIMUiccSlotStateActiveEsim           = 0x00000007
+	MBIMUiccSlotStateActiveEsimNoProfiles = 0x00000008
+)
+
 // MBIM Subscriber Ready States
 const (
 	MBIMSubscriberReadyStateNotInitialized = 0x00000000
@@ -0,0 +1,20 @@
+package mbim
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// defaultMaxControlTransfer is used when the device does not report its maximum control message size.
+const defaultMaxControlTransfer = 4096
+
+// openDevice opens the cdc-wdm device at path for direct MBIM access and
+// returns it with the maximum control transfer size of the device.
+func openDevice(path string) (io.ReadWriteCloser, int, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, 0, fmt.Errorf("open %s: %w", path, err)
+	}
+	return f, maxControlTransfer(f), nil
+}
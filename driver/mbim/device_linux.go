@@ -0,0 +1,29 @@
+package mbim
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// iocWDMMaxCommand is IOCTL_WDM_MAX_COMMAND from linux/usb/cdc-wdm.h.
+const iocWDMMaxCommand = 0x800248A0
+
+// maxControlTransfer returns the wMaxControlMessage of the cdc-wdm device.
+// The ioctl goes through SyscallConn, so f stays in non-blocking mode and read deadlines keep working.
+func maxControlTransfer(f *os.File) int {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return defaultMaxControlTransfer
+	}
+	var size uint16
+	var errno unix.Errno
+	conn.Control(func(fd uintptr) {
+		_, _, errno = unix.Syscall(unix.SYS_IOCTL, fd, iocWDMMaxCommand, uintptr(unsafe.Pointer(&size)))
+	})
+	if errno != 0 || size == 0 {
+		return defaultMaxControlTransfer
+	}
+	return int(size)
+}
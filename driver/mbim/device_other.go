@@ -0,0 +1,9 @@
+//go:build !linux
+
+package mbim
+
+import "os"
+
+func maxControlTransfer(*os.File) int {
+	return defaultMaxControlTransfer
+}
@@ -174,3 +174,40 @@ func (e MBIMStatus) Error() string {
 		return fmt.Sprintf("Unknown MBIM Status Error: %d", e)
 	}
 }
+
+// ProtocolError is the error status code of an MBIM_FUNCTION_ERROR_MSG.
+type ProtocolError uint32
+
+const (
+	ProtocolErrorTimeoutFragment       ProtocolError = 0x00000001
+	ProtocolErrorFragmentOutOfSequence ProtocolError = 0x00000002
+	ProtocolErrorLengthMismatch        ProtocolError = 0x00000003
+	ProtocolErrorDuplicatedTID         ProtocolError = 0x00000004
+	ProtocolErrorNotOpened             ProtocolError = 0x00000005
+	ProtocolErrorUnknown               ProtocolError = 0x00000006
+	ProtocolErrorCancel                ProtocolError = 0x00000007
+	ProtocolErrorMaxTransfer           ProtocolError = 0x00000008
+)
+
+func (e ProtocolError) Error() string {
+	switch e {
+	case ProtocolErrorTimeoutFragment:
+		return "Timeout Fragment"
+	case ProtocolErrorFragmentOutOfSequence:
+		return "Fragment Out Of Sequence"
+	case ProtocolErrorLengthMismatch:
+		return "Length Mismatch"
+	case ProtocolErrorDuplicatedTID:
+		return "Duplicated TID"
+	case ProtocolErrorNotOpened:
+		return "Not Opened"
+	case ProtocolErrorUnknown:
+		return "Unknown"
+	case ProtocolErrorCancel:
+		return "Cancel"
+	case ProtocolErrorMaxTransfer:
+		return "Max Transfer"
+	default:
+		return fmt.Sprintf("Unknown MBIM Protocol Error: %d", uint32(e))
+	}
+}
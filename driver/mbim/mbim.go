@@ -12,32 +12,82 @@ import (
 	"time"
 
 	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/euicc-go/driver"
+	sgp22 "github.com/damonto/euicc-go/v2"
 )
 
+// Mode selects how the driver reaches the MBIM device.
+type Mode int
+
+const (
+	// ModeProxy shares the device with other clients through libmbim's mbim-proxy.
+	ModeProxy Mode = iota
+	// ModeDirect opens the cdc-wdm device itself, for hosts without libmbim.
+	// No other MBIM client may use the device at the same time.
+	ModeDirect
+	// ModeAuto uses mbim-proxy when it is running and opens the device directly otherwise.
+	ModeAuto
+)
+
+type Options struct {
+	// Mode selects the proxy or the direct transport. It defaults to ModeProxy.
+	Mode Mode
+}
+
 // MBIM implements the apdu.SmartCardChannel interface using MBIM protocol
 type MBIM struct {
-	device  string
-	slot    uint8
-	conn    net.Conn
-	txnID   uint32
-	channel uint32
+	device string
+	// slot is the physical slot numbered from 1, or 0 to use the active slot.
+	slot      uint8
+	transport *transport
+	direct    bool
+	txnID     uint32
+	channel   uint32
 }
 
-// New creates a new MBIM proxy connection to the specified device
+// New creates a new MBIM proxy connection to the specified device.
+// Connect activates the physical slot with SwitchSlot, unless slot is 0 which uses the active slot as is.
+// The returned channel implements driver.SlotManager.
 func New(device string, slot uint8) (apdu.SmartCardChannel, error) {
-	if slot == 0 {
-		return nil, fmt.Errorf("slot must be >= 1")
+	return NewWithOptions(device, slot, nil)
+}
+
+// NewWithOptions creates a new MBIM connection to the specified device using the transport selected by opts.
+func NewWithOptions(device string, slot uint8, opts *Options) (apdu.SmartCardChannel, error) {
+	if opts == nil {
+		opts = new(Options)
 	}
-	m := &MBIM{
-		device: device,
-		slot:   slot - 1, // Convert to 0-based
+	m := &MBIM{device: device, slot: slot}
+	var err error
+	switch opts.Mode {
+	case ModeProxy:
+		err = m.connectToProxy()
+	case ModeDirect:
+		err = m.openDirect()
+	case ModeAuto:
+		if err = m.connectToProxy(); err != nil {
+			err = m.openDirect()
+		}
+	default:
+		err = fmt.Errorf("unknown mode %d", opts.Mode)
 	}
-	if err := m.connectToProxy(); err != nil {
+	if err != nil {
 		return nil, err
 	}
 	return m, nil
 }
 
+// openDirect opens the cdc-wdm device without going through mbim-proxy
+func (m *MBIM) openDirect() error {
+	d, maxControlTransfer, err := openDevice(m.device)
+	if err != nil {
+		return err
+	}
+	m.direct = true
+	m.transport = newTransport(d, maxControlTransfer)
+	return nil
+}
+
 // connectToProxy establishes connection to mbim-proxy using abstract Unix socket
 func (m *MBIM) connectToProxy() error {
 	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
@@ -48,49 +98,96 @@ func (m *MBIM) connectToProxy() error {
 		syscall.Close(fd)
 		return fmt.Errorf("connect to mbim-proxy: %w", err)
 	}
-	m.conn, err = net.FileConn(os.NewFile(uintptr(fd), "euicc-go-mbim-proxy"))
+	conn, err := net.FileConn(os.NewFile(uintptr(fd), "euicc-go-mbim-proxy"))
 	if err != nil {
 		return fmt.Errorf("create net.Conn: %w", err)
 	}
+	m.transport = newTransport(conn, defaultMaxControlTransfer)
 	return nil
 }
 
 // Connect establishes MBIM session and opens device
 func (m *MBIM) Connect() error {
-	if err := m.configureProxy(); err != nil {
-		return fmt.Errorf("configure proxy: %w", err)
+	if !m.direct {
+		if err := m.configureProxy(); err != nil {
+			return fmt.Errorf("configure proxy: %w", err)
+		}
 	}
 	if err := m.openDevice(); err != nil {
 		return fmt.Errorf("open device: %w", err)
 	}
-	if err := m.ensureSlotActivated(); err != nil {
-		return fmt.Errorf("ensure slot is activated: %w", err)
+	if m.slot != 0 {
+		if err := m.SwitchSlot(m.slot); err != nil {
+			return fmt.Errorf("ensure slot is activated: %w", err)
+		}
 	}
 	return nil
 }
 
-// ensureSlotActivated checks if the desired slot is activated and activates it if necessary
-func (m *MBIM) ensureSlotActivated() error {
-	slot, err := m.currentActivatedSlot()
+// Slots implements driver.SlotManager.
+// MBIM only reports the ICCID of the active slot and no EID.
+func (m *MBIM) Slots() ([]driver.Slot, error) {
+	caps := SysCapsRequest{TransactionID: atomic.AddUint32(&m.txnID, 1)}
+	if err := m.transport.transmit(caps.Request()); err != nil {
+		return nil, err
+	}
+	active, err := m.currentActivatedSlot()
+	if err != nil {
+		return nil, err
+	}
+	slots := make([]driver.Slot, caps.Response.NumberOfSlots)
+	for i := range slots {
+		request := SlotInfoStatusRequest{
+			TransactionID: atomic.AddUint32(&m.txnID, 1),
+			SlotIndex:     uint32(i),
+		}
+		if err := m.transport.transmit(request.Request()); err != nil {
+			return nil, err
+		}
+		state := request.Response.State
+		slots[i] = driver.Slot{
+			Number: uint8(i + 1),
+			Active: uint8(i) == active,
+			Present: state != MBIMUiccSlotStateUnknown &&
+				state != MBIMUiccSlotStateOffEmpty &&
+				state != MBIMUiccSlotStateEmpty,
+			EUICC: state == MBIMUiccSlotStateActiveEsim || state == MBIMUiccSlotStateActiveEsimNoProfiles,
+		}
+	}
+	if int(active) < len(slots) {
+		status := SubscriberReadyStatusRequest{TransactionID: atomic.AddUint32(&m.txnID, 1)}
+		if err := m.transport.transmit(status.Request()); err == nil && status.Response.SimICCID != "" {
+			slots[active].ICCID, _ = sgp22.NewICCID(status.Response.SimICCID)
+		}
+	}
+	return slots, nil
+}
+
+// SwitchSlot implements driver.SlotManager.
+func (m *MBIM) SwitchSlot(slot uint8) error {
+	if slot == 0 {
+		return errors.New("slot must be >= 1")
+	}
+	active, err := m.currentActivatedSlot()
 	if err != nil {
 		return err
 	}
-	if slot == m.slot {
+	if active == slot-1 {
 		return nil
 	}
-	if err := m.activateSlot(m.slot); err != nil {
+	if err := m.activateSlot(slot - 1); err != nil {
 		return err
 	}
-	return m.waitForSlotActivation()
+	return m.waitForSlotActivation(slot)
 }
 
-// currentActivatedSlot queries the current active slot mapping
+// currentActivatedSlot queries the current active slot mapping, numbered from 0
 func (m *MBIM) currentActivatedSlot() (uint8, error) {
 	request := DeviceSlotMappingsRequest{
 		TransactionID: atomic.AddUint32(&m.txnID, 1),
 		MapCount:      0, // Query operation
 	}
-	if err := request.Request().Transmit(m.conn); err != nil {
+	if err := m.transport.transmit(request.Request()); err != nil {
 		return 0, err
 	}
 	if len(request.Response.SlotMappings) == 0 {
@@ -108,20 +205,20 @@ func (m *MBIM) activateSlot(slot uint8) error {
 			{Slot: uint32(slot)},
 		},
 	}
-	if err := request.Request().Transmit(m.conn); err != nil {
+	if err := m.transport.transmit(request.Request()); err != nil {
 		return err
 	}
 	return nil
 }
 
 // waitForSlotActivation waits for the slot to become active by checking subscriber ready status
-func (m *MBIM) waitForSlotActivation() error {
+func (m *MBIM) waitForSlotActivation(slot uint8) error {
 	var err error
 	for range 10 {
 		request := SubscriberReadyStatusRequest{
 			TransactionID: atomic.AddUint32(&m.txnID, 1),
 		}
-		err = request.Request().Transmit(m.conn)
+		err = m.transport.transmit(request.Request())
 		if err != nil {
 			continue // Ignore errors, retry
 		}
@@ -131,7 +228,34 @@ func (m *MBIM) waitForSlotActivation() error {
 		}
 		time.Sleep(500 * time.Millisecond)
 	}
-	return fmt.Errorf("sim did not become available after slot %d activation err: %w", m.slot, err)
+	return fmt.Errorf("sim did not become available after slot %d activation err: %w", slot, err)
+}
+
+// WaitReady implements driver.ReadyWaiter by polling the subscriber ready status until the SIM is initialized.
+// A modem reset closes the MBIM function, so the device is opened again when it reports it is not opened.
+func (m *MBIM) WaitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		request := SubscriberReadyStatusRequest{
+			TransactionID: atomic.AddUint32(&m.txnID, 1),
+		}
+		err := m.transport.transmit(request.Request())
+		if errors.Is(err, ProtocolErrorNotOpened) {
+			err = m.openDevice()
+		}
+		var status MBIMStatus
+		if err != nil && !errors.As(err, &status) {
+			return err
+		}
+		readyState := request.Response.ReadyState
+		if err == nil && (readyState == MBIMSubscriberReadyStateInitialized || readyState == MBIMSubscriberReadyStateNoEsimProfile) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("sim is not ready after %s: %w", timeout, err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
 }
 
 // configureProxy sends proxy configuration request with device path using the libmbim proxy protocol
@@ -141,8 +265,8 @@ func (m *MBIM) configureProxy() error {
 		DevicePath:    m.device,
 		Timeout:       30,
 	}
-	err := request.Request().Transmit(m.conn)
-	if err == io.EOF {
+	err := m.transport.transmit(request.Request())
+	if errors.Is(err, io.EOF) {
 		return fmt.Errorf("device %s is not connected", m.device)
 	}
 	return err
@@ -153,7 +277,16 @@ func (m *MBIM) openDevice() error {
 	request := OpenDeviceRequest{
 		TransactionID: atomic.AddUint32(&m.txnID, 1),
 	}
-	return request.Request().Transmit(m.conn)
+	request.MaxControlTransfer = uint32(m.transport.maxControlTransfer)
+	return m.transport.transmit(request.Request())
+}
+
+// closeDevice sends MBIM Close message so the function can release its state
+func (m *MBIM) closeDevice() error {
+	request := CloseDeviceRequest{
+		TransactionID: atomic.AddUint32(&m.txnID, 1),
+	}
+	return m.transport.transmit(request.Request())
 }
 
 // OpenLogicalChannel opens a logical channel for the specified Application ID
@@ -164,7 +297,7 @@ func (m *MBIM) OpenLogicalChannel(AID []byte) (byte, error) {
 		SelectP2Arg:   0,
 		Group:         1,
 	}
-	if err := request.Request().Transmit(m.conn); err != nil {
+	if err := m.transport.transmit(request.Request()); err != nil {
 		return 0, err
 	}
 	m.channel = request.Response.Channel
@@ -180,7 +313,7 @@ func (m *MBIM) Transmit(command []byte) ([]byte, error) {
 		ClassByteType:   0,
 		APDU:            command,
 	}
-	if err := request.Request().Transmit(m.conn); err != nil {
+	if err := m.transport.transmit(request.Request()); err != nil {
 		return nil, err
 	}
 	sw := make([]byte, 2)
@@ -196,10 +329,24 @@ func (m *MBIM) CloseLogicalChannel(channel byte) error {
 		Channel:       uint32(channel),
 		Group:         1,
 	}
-	return request.Request().Transmit(m.conn)
+	return m.transport.transmit(request.Request())
 }
 
-// Disconnect closes the MBIM connection and releases resources
+// Disconnect closes the MBIM connection and releases resources.
+// In direct mode, the device is closed with the MBIM Close handshake first.
 func (m *MBIM) Disconnect() error {
-	return m.conn.Close()
+	if m.direct {
+		if err := m.closeDevice(); err != nil {
+			m.transport.close()
+			return fmt.Errorf("close device: %w", err)
+		}
+	}
+	return m.transport.close()
+}
+
+// Subscribe returns a channel receiving the indications of the device, such as
+// subscriber ready status changes after a SIM refresh, until cancel is called or the driver is disconnected.
+// Indications are dropped while the channel is full.
+func (m *MBIM) Subscribe() (indications <-chan Indication, cancel func()) {
+	return m.transport.subscribe()
 }
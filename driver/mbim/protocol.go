@@ -56,8 +56,9 @@ func (r *ProxyConfigResponse) UnmarshalBinary(data []byte) error { return nil }
 // region Open Device Request
 
 type OpenDeviceRequest struct {
-	TransactionID uint32
-	Response      *OpenDeviceResponse
+	TransactionID      uint32
+	MaxControlTransfer uint32
+	Response           *OpenDeviceResponse
 }
 
 func (r *OpenDeviceRequest) Request() *Request {
@@ -71,8 +72,11 @@ func (r *OpenDeviceRequest) Request() *Request {
 }
 
 func (r *OpenDeviceRequest) MarshalBinary() ([]byte, error) {
+	if r.MaxControlTransfer == 0 {
+		r.MaxControlTransfer = defaultMaxControlTransfer
+	}
 	buf := make([]byte, 4)
-	binary.LittleEndian.PutUint32(buf, 4096)
+	binary.LittleEndian.PutUint32(buf, r.MaxControlTransfer)
 	return buf, nil
 }
 
@@ -82,6 +86,32 @@ func (p *OpenDeviceResponse) UnmarshalBinary(data []byte) error { return nil }
 
 // endregion
 
+// region Close Device Request
+
+type CloseDeviceRequest struct {
+	TransactionID uint32
+	Response      *CloseDeviceResponse
+}
+
+func (r *CloseDeviceRequest) Request() *Request {
+	r.Response = new(CloseDeviceResponse)
+	return &Request{
+		MessageType:   MessageTypeClose,
+		TransactionID: r.TransactionID,
+		ReadTimeout:   5 * time.Second,
+		Command:       r,
+		Response:      r.Response,
+	}
+}
+
+func (r *CloseDeviceRequest) MarshalBinary() ([]byte, error) { return nil, nil }
+
+type CloseDeviceResponse struct{}
+
+func (p *CloseDeviceResponse) UnmarshalBinary(data []byte) error { return nil }
+
+// endregion
+
 // region Device Slot Mappings
 
 type DeviceSlotMappingsRequest struct {
@@ -161,6 +191,93 @@ func (r *DeviceSlotMappingsResponse) UnmarshalBinary(data []byte) error {
 
 // endregion
 
+// region System Capabilities
+
+type SysCapsRequest struct {
+	TransactionID uint32
+	Response      *SysCapsResponse
+}
+
+func (r *SysCapsRequest) Request() *Request {
+	r.Response = new(SysCapsResponse)
+	return &Request{
+		MessageType:   MessageTypeCommand,
+		TransactionID: r.TransactionID,
+		Command: &Command{
+			FragmentTotal:   1,
+			FragmentCurrent: 0,
+			ServiceID:       ServiceMsBasicConnectExtensions,
+			CommandID:       CIDSysCaps,
+			CommandType:     CommandTypeQuery,
+			Data:            []byte{},
+		},
+		Response: r.Response,
+	}
+}
+
+type SysCapsResponse struct {
+	NumberOfExecutors uint32
+	NumberOfSlots     uint32
+	Concurrency       uint32
+	ModemID           uint64
+}
+
+func (r *SysCapsResponse) UnmarshalBinary(data []byte) error {
+	if len(data) < 20 {
+		return errors.New("system capabilities response data too short")
+	}
+	r.NumberOfExecutors = binary.LittleEndian.Uint32(data[0:4])
+	r.NumberOfSlots = binary.LittleEndian.Uint32(data[4:8])
+	r.Concurrency = binary.LittleEndian.Uint32(data[8:12])
+	r.ModemID = binary.LittleEndian.Uint64(data[12:20])
+	return nil
+}
+
+// endregion
+
+// region Slot Info Status
+
+type SlotInfoStatusRequest struct {
+	TransactionID uint32
+	SlotIndex     uint32
+	Response      *SlotInfoStatusResponse
+}
+
+func (r *SlotInfoStatusRequest) Request() *Request {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, r.SlotIndex)
+	r.Response = new(SlotInfoStatusResponse)
+	return &Request{
+		MessageType:   MessageTypeCommand,
+		TransactionID: r.TransactionID,
+		Command: &Command{
+			FragmentTotal:   1,
+			FragmentCurrent: 0,
+			ServiceID:       ServiceMsBasicConnectExtensions,
+			CommandID:       CIDSlotInfoStatus,
+			CommandType:     CommandTypeQuery,
+			Data:            buf.Bytes(),
+		},
+		Response: r.Response,
+	}
+}
+
+type SlotInfoStatusResponse struct {
+	SlotIndex uint32
+	State     uint32
+}
+
+func (r *SlotInfoStatusResponse) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("slot info status response data too short")
+	}
+	r.SlotIndex = binary.LittleEndian.Uint32(data[0:4])
+	r.State = binary.LittleEndian.Uint32(data[4:8])
+	return nil
+}
+
+// endregion
+
 // region Subscriber Ready Status
 
 type SubscriberReadyStatusRequest struct {
@@ -188,6 +305,7 @@ func (r *SubscriberReadyStatusRequest) Request() *Request {
 
 type SubscriberReadyStatusResponse struct {
 	ReadyState uint32
+	SimICCID   string
 }
 
 func (r *SubscriberReadyStatusResponse) UnmarshalBinary(data []byte) error {
@@ -195,9 +313,24 @@ func (r *SubscriberReadyStatusResponse) UnmarshalBinary(data []byte) error {
 		return errors.New("subscriber ready status response data too short")
 	}
 	r.ReadyState = binary.LittleEndian.Uint32(data[0:4])
+	if len(data) >= 20 {
+		r.SimICCID = readString(data, 12)
+	}
 	return nil
 }
 
+// readString reads the UTF-16 string referenced by the offset and size pair at index.
+func readString(data []byte, index int) string {
+	offset := int(binary.LittleEndian.Uint32(data[index : index+4]))
+	size := int(binary.LittleEndian.Uint32(data[index+4 : index+8]))
+	if size == 0 || offset+size > len(data) {
+		return ""
+	}
+	s := make([]uint16, size/2)
+	binary.Read(bytes.NewReader(data[offset:offset+size]), binary.LittleEndian, s)
+	return string(utf16.Decode(s))
+}
+
 // endregion
 
 // region Open Logical Channel
@@ -0,0 +1,23 @@
+package mbim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriberReadyStatusResponse(t *testing.T) {
+	iccid := utf16.Encode([]rune("8901410321111851236"))
+	data := new(bytes.Buffer)
+	// Ready state, subscriber ID, SIM ICCID, ready info and telephone number count, then the ICCID string.
+	binary.Write(data, binary.LittleEndian, []uint32{MBIMSubscriberReadyStateInitialized, 0, 0, 28, uint32(len(iccid) * 2), 0, 0})
+	binary.Write(data, binary.LittleEndian, iccid)
+
+	var response SubscriberReadyStatusResponse
+	assert.NoError(t, response.UnmarshalBinary(data.Bytes()))
+	assert.Equal(t, uint32(MBIMSubscriberReadyStateInitialized), response.ReadyState)
+	assert.Equal(t, "8901410321111851236", response.SimICCID)
+}
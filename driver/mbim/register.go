@@ -0,0 +1,40 @@
+package mbim
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/euicc-go/driver"
+)
+
+// The MBIM driver registers the "mbim" scheme, e.g. "mbim:///dev/cdc-wdm0?slot=1&mode=direct".
+// The mode is "proxy", "direct" or "auto" and defaults to "proxy". The slot defaults to 0, which uses the active
+// slot without switching; any other slot is activated on Connect with SwitchSlot.
+func init() {
+	driver.Register("mbim", open)
+}
+
+func open(u *url.URL) (apdu.SmartCardChannel, error) {
+	slot, err := driver.QuerySlot(u)
+	if err != nil {
+		return nil, err
+	}
+	opts := new(Options)
+	if opts.Mode, err = parseMode(u.Query().Get("mode")); err != nil {
+		return nil, err
+	}
+	return NewWithOptions(driver.Device(u), slot, opts)
+}
+
+func parseMode(value string) (Mode, error) {
+	switch value {
+	case "", "proxy":
+		return ModeProxy, nil
+	case "direct":
+		return ModeDirect, nil
+	case "auto":
+		return ModeAuto, nil
+	}
+	return 0, fmt.Errorf("unknown mode %q", value)
+}
@@ -0,0 +1,308 @@
+package mbim
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// messageTypeFunctionError is MBIM_FUNCTION_ERROR_MSG, sent by the function when it rejects a message.
+const messageTypeFunctionError MessageType = 0x80000004
+
+// indicationBuffer is the number of indications a subscriber may have pending before new ones are dropped.
+const indicationBuffer = 16
+
+var ErrTransportClosed = errors.New("mbim: transport closed")
+
+// Indication is an unsolicited status indication of the device (MBIM_INDICATE_STATUS_MSG).
+type Indication struct {
+	ServiceID [16]byte
+	CommandID uint32
+	Data      []byte
+}
+
+// transport multiplexes MBIM messages over a connection.
+// A single goroutine reads the connection, reassembles fragmented messages, routes each response to
+// the request with the same transaction ID and sends indications to the subscribers.
+type transport struct {
+	conn               io.ReadWriteCloser
+	maxControlTransfer int
+	write              sync.Mutex
+	mutex              sync.Mutex
+	pending            map[uint32]chan []byte
+	fragments          reassembler
+	subscribers        map[chan Indication]struct{}
+	closed             chan struct{}
+	err                error
+}
+
+func newTransport(conn io.ReadWriteCloser, maxControlTransfer int) *transport {
+	t := &transport{
+		conn:               conn,
+		maxControlTransfer: maxControlTransfer,
+		pending:            make(map[uint32]chan []byte),
+		fragments:          make(reassembler),
+		subscribers:        make(map[chan Indication]struct{}),
+		closed:             make(chan struct{}),
+	}
+	go t.read()
+	return t
+}
+
+func (t *transport) read() {
+	for {
+		message, err := readMessage(t.conn)
+		if err != nil {
+			t.mutex.Lock()
+			if t.err == nil {
+				t.err = err
+			}
+			for ch := range t.subscribers {
+				delete(t.subscribers, ch)
+				close(ch)
+			}
+			t.mutex.Unlock()
+			close(t.closed)
+			return
+		}
+		if message, err = t.fragments.add(message); err != nil {
+			t.fail(binary.LittleEndian.Uint32(message[8:12]), ProtocolErrorFragmentOutOfSequence)
+		} else if message != nil {
+			t.dispatch(message)
+		}
+	}
+}
+
+// readMessage reads the next message or fragment.
+func readMessage(r io.Reader) ([]byte, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := binary.LittleEndian.Uint32(header[4:8])
+	if length < 12 {
+		return nil, fmt.Errorf("invalid message length %d", length)
+	}
+	message := make([]byte, length)
+	copy(message, header)
+	if _, err := io.ReadFull(r, message[12:]); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// reassembler collects the fragments of the messages by transaction ID.
+type reassembler map[uint32][]byte
+
+// add returns the whole message once the last fragment arrives, or nil while fragments are missing.
+// Fragments of different transactions may interleave. A fragment out of sequence returns
+// ProtocolErrorFragmentOutOfSequence with the fragment.
+func (r reassembler) add(fragment []byte) ([]byte, error) {
+	if !fragmented(MessageType(binary.LittleEndian.Uint32(fragment[0:4]))) || len(fragment) < 20 {
+		return fragment, nil
+	}
+	transactionID := binary.LittleEndian.Uint32(fragment[8:12])
+	total := binary.LittleEndian.Uint32(fragment[12:16])
+	current := binary.LittleEndian.Uint32(fragment[16:20])
+	if total <= 1 {
+		return fragment, nil
+	}
+	message, ok := r[transactionID]
+	switch {
+	case current == 0:
+		message = fragment
+	case !ok || binary.LittleEndian.Uint32(message[16:20])+1 != current:
+		delete(r, transactionID)
+		return fragment, ProtocolErrorFragmentOutOfSequence
+	default:
+		message = append(message, fragment[20:]...)
+		binary.LittleEndian.PutUint32(message[16:20], current)
+	}
+	if current+1 < total {
+		r[transactionID] = message
+		return nil, nil
+	}
+	delete(r, transactionID)
+	binary.LittleEndian.PutUint32(message[4:8], uint32(len(message)))
+	binary.LittleEndian.PutUint32(message[12:16], 1)
+	binary.LittleEndian.PutUint32(message[16:20], 0)
+	return message, nil
+}
+
+func (t *transport) dispatch(message []byte) {
+	if MessageType(binary.LittleEndian.Uint32(message[0:4]))&^0x80000000 == MessageTypeIndicateStatus {
+		t.indicate(message)
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if ch, ok := t.pending[binary.LittleEndian.Uint32(message[8:12])]; ok {
+		select {
+		case ch <- message:
+		default:
+		}
+	}
+}
+
+// fail reports the protocol error of a malformed response to the request waiting for it.
+func (t *transport) fail(transactionID uint32, err ProtocolError) {
+	message := make([]byte, 16)
+	binary.LittleEndian.PutUint32(message[0:4], uint32(messageTypeFunctionError))
+	binary.LittleEndian.PutUint32(message[4:8], 16)
+	binary.LittleEndian.PutUint32(message[8:12], transactionID)
+	binary.LittleEndian.PutUint32(message[12:16], uint32(err))
+	t.dispatch(message)
+}
+
+func (t *transport) indicate(message []byte) {
+	if len(message) < 48 {
+		return
+	}
+	indication := Indication{CommandID: binary.LittleEndian.Uint32(message[36:40])}
+	copy(indication.ServiceID[:], message[20:36])
+	length := binary.LittleEndian.Uint32(message[40:44])
+	if int(length) > len(message)-44 {
+		return
+	}
+	indication.Data = message[44 : 44+length]
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for ch := range t.subscribers {
+		select {
+		case ch <- indication:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel receiving the indications until the returned function is called
+// or the transport is closed.
+func (t *transport) subscribe() (<-chan Indication, func()) {
+	ch := make(chan Indication, indicationBuffer)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.err != nil {
+		close(ch)
+		return ch, func() {}
+	}
+	t.subscribers[ch] = struct{}{}
+	return ch, func() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		if _, ok := t.subscribers[ch]; ok {
+			delete(t.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// transmit sends the request and waits for the response with the same transaction ID.
+func (t *transport) transmit(r *Request) error {
+	data, err := r.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	ch := make(chan []byte, 1)
+	t.mutex.Lock()
+	if t.err != nil {
+		defer t.mutex.Unlock()
+		return t.failure()
+	}
+	t.pending[r.TransactionID] = ch
+	t.mutex.Unlock()
+	defer func() {
+		t.mutex.Lock()
+		delete(t.pending, r.TransactionID)
+		t.mutex.Unlock()
+	}()
+	if err := t.send(data); err != nil {
+		return err
+	}
+	timeout := r.ReadTimeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case message := <-ch:
+		return r.response(message)
+	case <-timer.C:
+		return fmt.Errorf("timed out waiting for response for transaction ID %d", r.TransactionID)
+	case <-t.closed:
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		return t.failure()
+	}
+}
+
+// failure returns the error that stopped the transport. The caller must hold t.mutex.
+func (t *transport) failure() error {
+	if errors.Is(t.err, ErrTransportClosed) {
+		return t.err
+	}
+	return fmt.Errorf("%w: %w", ErrTransportClosed, t.err)
+}
+
+// response decodes the response message of the request.
+func (r *Request) response(message []byte) error {
+	messageType := MessageType(binary.LittleEndian.Uint32(message[0:4]))
+	if messageType == messageTypeFunctionError && len(message) >= 16 {
+		return ProtocolError(binary.LittleEndian.Uint32(message[12:16]))
+	}
+	if messageType != r.MessageType|0x80000000 {
+		return fmt.Errorf("unexpected message type 0x%08X for transaction ID %d", uint32(messageType), r.TransactionID)
+	}
+	response := CommandResponse{Response: r.Response}
+	return response.UnmarshalBinary(message)
+}
+
+func (t *transport) send(message []byte) error {
+	t.write.Lock()
+	defer t.write.Unlock()
+	return writeMessage(t.conn, message, t.maxControlTransfer)
+}
+
+// writeMessage writes a whole message, splitting it into fragments of at most maxControlTransfer bytes.
+func writeMessage(w io.Writer, message []byte, maxControlTransfer int) error {
+	if len(message) <= maxControlTransfer {
+		_, err := w.Write(message)
+		return err
+	}
+	if len(message) < 20 || !fragmented(MessageType(binary.LittleEndian.Uint32(message[0:4]))) {
+		return fmt.Errorf("message of %d bytes exceeds the maximum control transfer of %d bytes", len(message), maxControlTransfer)
+	}
+	payload := message[20:]
+	size := maxControlTransfer - 20
+	total := (len(payload) + size - 1) / size
+	for current := range total {
+		chunk := payload[current*size : min((current+1)*size, len(payload))]
+		fragment := make([]byte, 20, 20+len(chunk))
+		copy(fragment, message[:20])
+		binary.LittleEndian.PutUint32(fragment[4:8], uint32(20+len(chunk)))
+		binary.LittleEndian.PutUint32(fragment[12:16], uint32(total))
+		binary.LittleEndian.PutUint32(fragment[16:20], uint32(current))
+		if _, err := w.Write(append(fragment, chunk...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *transport) close() error {
+	t.mutex.Lock()
+	if t.err == nil {
+		t.err = ErrTransportClosed
+	}
+	t.mutex.Unlock()
+	return t.conn.Close()
+}
+
+// fragmented reports whether messages of type m carry a fragment header.
+func fragmented(m MessageType) bool {
+	m &^= 0x80000000
+	return m == MessageTypeCommand || m == MessageTypeIndicateStatus
+}
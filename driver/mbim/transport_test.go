@@ -0,0 +1,121 @@
+package mbim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fragment(messageType MessageType, transactionID, total, current uint32, payload []byte) []byte {
+	b := make([]byte, 20, 20+len(payload))
+	binary.LittleEndian.PutUint32(b[0:4], uint32(messageType))
+	binary.LittleEndian.PutUint32(b[4:8], uint32(20+len(payload)))
+	binary.LittleEndian.PutUint32(b[8:12], transactionID)
+	binary.LittleEndian.PutUint32(b[12:16], total)
+	binary.LittleEndian.PutUint32(b[16:20], current)
+	return append(b, payload...)
+}
+
+// readFragment reads one message written by the transport.
+func readFragment(t *testing.T, conn net.Conn) []byte {
+	header := make([]byte, 12)
+	_, err := io.ReadFull(conn, header)
+	assert.NoError(t, err)
+	message := make([]byte, binary.LittleEndian.Uint32(header[4:8]))
+	copy(message, header)
+	_, err = io.ReadFull(conn, message[12:])
+	assert.NoError(t, err)
+	return message
+}
+
+func newTestTransport(t *testing.T) (*transport, net.Conn) {
+	client, function := net.Pipe()
+	tr := newTransport(client, 64)
+	t.Cleanup(func() { tr.close() })
+	return tr, function
+}
+
+func TestTransport_Fragmentation(t *testing.T) {
+	tr, function := newTestTransport(t)
+	indications, cancel := tr.subscribe()
+	defer cancel()
+
+	apdu := bytes.Repeat([]byte{0xAB}, 100)
+	request := TransmitAPDURequest{TransactionID: 7, APDU: apdu}
+	r := request.Request()
+	message, err := r.MarshalBinary()
+	assert.NoError(t, err)
+
+	// Information buffer of the TransmitAPDU response: status, response length, offset and the response bytes.
+	body := new(bytes.Buffer)
+	binary.Write(body, binary.LittleEndian, ServiceMsUiccLowLevelAccess)
+	binary.Write(body, binary.LittleEndian, uint32(CIDUiccAPDU))
+	binary.Write(body, binary.LittleEndian, MBIMStatusNone)
+	binary.Write(body, binary.LittleEndian, uint32(12+len(apdu)))
+	binary.Write(body, binary.LittleEndian, []uint32{0x9000, uint32(len(apdu)), 12})
+	body.Write(apdu)
+	data := body.Bytes()
+
+	indication := new(bytes.Buffer)
+	binary.Write(indication, binary.LittleEndian, ServiceBasicConnect)
+	binary.Write(indication, binary.LittleEndian, []uint32{CIDSubscriberReadyStatus, 4, MBIMSubscriberReadyStateInitialized})
+
+	go func() {
+		var payload []byte
+		for i := range 4 {
+			f := readFragment(t, function)
+			assert.LessOrEqual(t, len(f), 64)
+			assert.Equal(t, uint32(4), binary.LittleEndian.Uint32(f[12:16]))
+			assert.Equal(t, uint32(i), binary.LittleEndian.Uint32(f[16:20]))
+			payload = append(payload, f[20:]...)
+		}
+		assert.Equal(t, message[20:], payload)
+		for _, f := range [][]byte{
+			fragment(MessageTypeCommandDone, 7, 4, 0, data[:40]),
+			fragment(MessageTypeIndicateStatusDone, 0, 2, 0, indication.Bytes()[:20]),
+			fragment(MessageTypeCommandDone, 6, 1, 0, data),
+			fragment(MessageTypeCommandDone, 7, 4, 1, data[40:80]),
+			fragment(MessageTypeIndicateStatusDone, 0, 2, 1, indication.Bytes()[20:]),
+			fragment(MessageTypeCommandDone, 7, 4, 2, data[80:120]),
+			fragment(MessageTypeCommandDone, 7, 4, 3, data[120:]),
+		} {
+			function.Write(f)
+		}
+	}()
+
+	assert.NoError(t, tr.transmit(r))
+	assert.Equal(t, uint32(0x9000), request.Response.Status)
+	assert.Equal(t, apdu, request.Response.Response)
+
+	select {
+	case i := <-indications:
+		assert.Equal(t, ServiceBasicConnect, i.ServiceID)
+		assert.Equal(t, uint32(CIDSubscriberReadyStatus), i.CommandID)
+		assert.Equal(t, []byte{0x01, 0x00, 0x00, 0x00}, i.Data)
+	case <-time.After(time.Second):
+		t.Fatal("indication not received")
+	}
+}
+
+func TestTransport_Errors(t *testing.T) {
+	tr, function := newTestTransport(t)
+	go func() {
+		readFragment(t, function)
+		function.Write([]byte{0x04, 0x00, 0x00, 0x80, 0x10, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00})
+		readFragment(t, function)
+		function.Write(fragment(MessageTypeCommandDone, 2, 2, 1, []byte{0x00}))
+	}()
+	request := SubscriberReadyStatusRequest{TransactionID: 1}
+	assert.ErrorIs(t, tr.transmit(request.Request()), ProtocolErrorNotOpened)
+	request = SubscriberReadyStatusRequest{TransactionID: 2}
+	assert.ErrorIs(t, tr.transmit(request.Request()), ProtocolErrorFragmentOutOfSequence)
+
+	tr.close()
+	request = SubscriberReadyStatusRequest{TransactionID: 3}
+	assert.ErrorIs(t, tr.transmit(request.Request()), ErrTransportClosed)
+}
@@ -4,8 +4,6 @@ import (
 	"bytes"
 	"encoding"
 	"encoding/binary"
-	"fmt"
-	"io"
 	"net"
 	"time"
 )
@@ -20,65 +18,60 @@ type Request struct {
 	Response      encoding.BinaryUnmarshaler
 }
 
+// WriteTo writes the request to a standalone connection, splitting it into fragments when needed.
+//
+// Deprecated: the driver shares one connection between requests and indications through its
+// transport; WriteTo is kept for callers that own the connection.
 func (r *Request) WriteTo(w net.Conn) (int, error) {
 	data, err := r.MarshalBinary()
 	if err != nil {
 		return 0, err
 	}
-	n, err := w.Write(data)
-	if err != nil {
-		return n, err
+	if err := writeMessage(w, data, defaultMaxControlTransfer); err != nil {
+		return 0, err
 	}
-	return n, nil
+	return len(data), nil
 }
 
+// ReadFrom reads the response of the request from a standalone connection, reassembling its
+// fragments and skipping the messages of other transactions. It returns the length of the response.
+//
+// Deprecated: see WriteTo.
 func (r *Request) ReadFrom(c net.Conn) (int, error) {
 	if r.ReadTimeout == 0 {
 		r.ReadTimeout = 30 * time.Second
 	}
-	deadline := time.Now().Add(r.ReadTimeout)
-	for time.Now().Before(deadline) {
-		c.SetReadDeadline(time.Now().Add(1 * time.Second))
-
-		header := make([]byte, 12)
-		if _, err := io.ReadAtLeast(c, header, 12); err != nil {
-			if ne, ok := err.(net.Error); ok && ne.Timeout() {
-				continue
-			}
-			return 0, err
-		}
-
-		length := binary.LittleEndian.Uint32(header[4:8])
-		buf := make([]byte, length)
-		copy(buf[:12], header)
-		if _, err := io.ReadFull(c, buf[12:]); err != nil {
+	if err := c.SetReadDeadline(time.Now().Add(r.ReadTimeout)); err != nil {
+		return 0, err
+	}
+	defer c.SetReadDeadline(time.Time{})
+	fragments := make(reassembler)
+	for {
+		message, err := readMessage(c)
+		if err != nil {
 			return 0, err
 		}
-
-		messageType := binary.LittleEndian.Uint32(header[0:4])
-		transactionID := binary.LittleEndian.Uint32(header[8:12])
-		if messageType&^0x80000000 != uint32(r.MessageType) || transactionID != r.TransactionID {
+		if binary.LittleEndian.Uint32(message[8:12]) != r.TransactionID {
 			continue
 		}
-
-		response := CommandResponse{Response: r.Response}
-		if err := response.UnmarshalBinary(buf); err != nil {
+		if message, err = fragments.add(message); err != nil {
 			return 0, err
 		}
-		return len(buf), nil
+		if message != nil {
+			return len(message), r.response(message)
+		}
 	}
-	return 0, fmt.Errorf("transaction ID %d not found in response", r.TransactionID)
 }
 
-// Transmit sends the MBIM message and waits for a response
+// Transmit writes the request to a standalone connection and reads its response.
+//
+// Deprecated: see WriteTo.
 func (r *Request) Transmit(conn net.Conn) error {
 	if _, err := r.WriteTo(conn); err != nil {
 		return err
 	}
-	if _, err := r.ReadFrom(conn); err != nil {
-		return err
-	}
-	return nil
+	_, err := r.ReadFrom(conn)
+	return err
 }
 
 // MarshalBinary creates binary representation of the MBIM message
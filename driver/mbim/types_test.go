@@ -0,0 +1,61 @@
+package mbim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequest_Transmit(t *testing.T) {
+	conn, function := net.Pipe()
+	defer conn.Close()
+	defer function.Close()
+
+	apdu := bytes.Repeat([]byte{0xAB}, 100)
+	request := TransmitAPDURequest{TransactionID: 7, APDU: apdu}
+	r := request.Request()
+	message, err := r.MarshalBinary()
+	assert.NoError(t, err)
+
+	// Information buffer of the TransmitAPDU response: status, response length, offset and the response bytes.
+	body := new(bytes.Buffer)
+	binary.Write(body, binary.LittleEndian, ServiceMsUiccLowLevelAccess)
+	binary.Write(body, binary.LittleEndian, uint32(CIDUiccAPDU))
+	binary.Write(body, binary.LittleEndian, MBIMStatusNone)
+	binary.Write(body, binary.LittleEndian, uint32(12+len(apdu)))
+	binary.Write(body, binary.LittleEndian, []uint32{0x9000, uint32(len(apdu)), 12})
+	body.Write(apdu)
+	data := body.Bytes()
+
+	go func() {
+		assert.Equal(t, message, readFragment(t, function))
+		for _, f := range [][]byte{
+			fragment(MessageTypeCommandDone, 7, 4, 0, data[:40]),
+			fragment(MessageTypeCommandDone, 6, 1, 0, data),
+			fragment(MessageTypeCommandDone, 7, 4, 1, data[40:80]),
+			fragment(MessageTypeCommandDone, 7, 4, 2, data[80:120]),
+			fragment(MessageTypeCommandDone, 7, 4, 3, data[120:]),
+		} {
+			function.Write(f)
+		}
+	}()
+
+	assert.NoError(t, r.Transmit(conn))
+	assert.Equal(t, uint32(0x9000), request.Response.Status)
+	assert.Equal(t, apdu, request.Response.Response)
+}
+
+func TestRequest_UnexpectedFragment(t *testing.T) {
+	conn, function := net.Pipe()
+	defer conn.Close()
+	defer function.Close()
+
+	go function.Write(fragment(MessageTypeCommandDone, 7, 2, 1, []byte{0x00}))
+	request := TransmitAPDURequest{TransactionID: 7}
+	r := request.Request()
+	_, err := r.ReadFrom(conn)
+	assert.ErrorIs(t, err, ProtocolErrorFragmentOutOfSequence)
+}
@@ -0,0 +1,208 @@
+// Package pcsc is a PC/SC driver speaking the pcsc-lite client protocol to pcscd over its socket.
+// Unlike driver/ccid it does not load libpcsclite, so it works in static binaries and minimal containers.
+package pcsc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/euicc-go/driver/ccid"
+)
+
+// DefaultSocket is the socket pcscd listens on, unless PCSCLITE_CSOCK_NAME is set.
+const DefaultSocket = "/run/pcscd/pcscd.comm"
+
+// Share modes.
+const (
+	ShareExclusive uint32 = 1
+	ShareShared    uint32 = 2
+)
+
+// Protocols.
+const (
+	ProtocolT0 uint32 = 0x0001
+	ProtocolT1 uint32 = 0x0002
+)
+
+type Options struct {
+	// Socket is the pcscd socket. It defaults to $PCSCLITE_CSOCK_NAME or DefaultSocket.
+	Socket string
+	// ShareMode is the PC/SC share mode. It defaults to ShareExclusive.
+	ShareMode uint32
+	// Protocols are the acceptable protocols. It defaults to T=0 and T=1.
+	Protocols uint32
+	// TerminalCapability is sent to the card on Connect. It defaults to apdu.DefaultTerminalCapability;
+	// an empty TerminalCapability sends nothing.
+	TerminalCapability *apdu.TerminalCapability
+}
+
+func (o *Options) setDefaults() {
+	if o.Socket == "" {
+		o.Socket = os.Getenv("PCSCLITE_CSOCK_NAME")
+	}
+	if o.Socket == "" {
+		o.Socket = DefaultSocket
+	}
+	if o.ShareMode == 0 {
+		o.ShareMode = ShareExclusive
+	}
+	if o.Protocols == 0 {
+		o.Protocols = ProtocolT0 | ProtocolT1
+	}
+	if o.TerminalCapability == nil {
+		capability := apdu.DefaultTerminalCapability
+		o.TerminalCapability = &capability
+	}
+}
+
+// PCSC implements the ccid.CCID interface.
+type PCSC struct {
+	client   *client
+	context  uint32
+	card     int32
+	protocol uint32
+	channel  byte
+	reader   string
+	atr      []byte
+	options  Options
+}
+
+func New() (ccid.CCID, error) {
+	return NewWithOptions(nil)
+}
+
+// NewWithOptions connects to pcscd and establishes a PC/SC context.
+func NewWithOptions(opts *Options) (ccid.CCID, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	opts.setDefaults()
+	c, err := dial(opts.Socket)
+	if err != nil {
+		return nil, err
+	}
+	request := establishMessage{Scope: scopeSystem}
+	if err := c.call(commandEstablishContext, &request, &request); err != nil {
+		c.close()
+		return nil, err
+	}
+	if err := result(request.Result); err != nil {
+		c.close()
+		return nil, fmt.Errorf("establish context: %w", err)
+	}
+	return &PCSC{client: c, context: request.Context, options: *opts}, nil
+}
+
+func (p *PCSC) ListReaders() ([]string, error) {
+	states, err := p.client.readerStates()
+	if err != nil {
+		return nil, err
+	}
+	if len(states) == 0 {
+		return nil, errors.New("no readers found")
+	}
+	readers := make([]string, len(states))
+	for i := range states {
+		readers[i] = states[i].name()
+	}
+	return readers, nil
+}
+
+func (p *PCSC) Readers() ([]ccid.Reader, error) {
+	states, err := p.client.readerStates()
+	if err != nil {
+		return nil, err
+	}
+	if len(states) == 0 {
+		return nil, errors.New("no readers found")
+	}
+	readers := make([]ccid.Reader, len(states))
+	for i := range states {
+		readers[i] = ccid.Reader{Name: states[i].name(), Present: states[i].present(), ATR: states[i].atr()}
+	}
+	return readers, nil
+}
+
+func (p *PCSC) SetReader(reader string) {
+	p.reader = reader
+}
+
+func (p *PCSC) Connect() error {
+	if len(p.reader) >= maxReaderName {
+		return fmt.Errorf("reader name too long: %s", p.reader)
+	}
+	request := connectMessage{
+		Context:            p.context,
+		ShareMode:          p.options.ShareMode,
+		PreferredProtocols: p.options.Protocols,
+	}
+	copy(request.Reader[:], p.reader)
+	if err := p.client.call(commandConnect, &request, &request); err != nil {
+		return err
+	}
+	if err := result(request.Result); err != nil {
+		return fmt.Errorf("connect %s: %w", p.reader, err)
+	}
+	p.card, p.protocol = request.Card, request.ActiveProtocol
+	states, err := p.client.readerStates()
+	if err != nil {
+		return err
+	}
+	for i := range states {
+		if states[i].name() == p.reader {
+			p.atr = states[i].atr()
+		}
+	}
+	return p.options.TerminalCapability.Send(p.Transmit)
+}
+
+func (p *PCSC) ATR() []byte {
+	return p.atr
+}
+
+func (p *PCSC) Disconnect() error {
+	defer p.client.close()
+	disconnect := disconnectMessage{Card: p.card, Disposition: dispositionLeaveCard}
+	if err := p.client.call(commandDisconnect, &disconnect, &disconnect); err != nil {
+		return err
+	}
+	if err := result(disconnect.Result); err != nil {
+		return fmt.Errorf("disconnect: %w", err)
+	}
+	release := releaseMessage{Context: p.context}
+	if err := p.client.call(commandReleaseContext, &release, &release); err != nil {
+		return err
+	}
+	return result(release.Result)
+}
+
+func (p *PCSC) Transmit(command []byte) ([]byte, error) {
+	return p.client.transmit(p.card, p.protocol, command)
+}
+
+func (p *PCSC) OpenLogicalChannel(AID []byte) (byte, error) {
+	channel, err := p.Transmit([]byte{0x00, 0x70, 0x00, 0x00, 0x01})
+	if err != nil {
+		return 0, err
+	}
+	if r := apdu.Response(channel); len(r) != 3 || !r.OK() {
+		return 0, fmt.Errorf("open logical channel: %X", channel)
+	}
+	p.channel = channel[0]
+	sw, err := p.Transmit(append([]byte{p.channel, 0xA4, 0x04, 0x00, byte(len(AID))}, AID...))
+	if err != nil {
+		return 0, err
+	}
+	if r := apdu.Response(sw); len(r) < 2 || (!r.OK() && !r.HasMore()) {
+		p.CloseLogicalChannel(p.channel)
+		return 0, fmt.Errorf("select AID: %X", sw)
+	}
+	return p.channel, nil
+}
+
+func (p *PCSC) CloseLogicalChannel(channel byte) error {
+	_, err := p.Transmit([]byte{0x00, 0x70, 0x80, channel, 0x00})
+	return err
+}
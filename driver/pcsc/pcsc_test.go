@@ -0,0 +1,224 @@
+package pcsc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/euicc-go/driver/ccid"
+	"github.com/damonto/euicc-go/driver/simulator"
+	"github.com/damonto/euicc-go/lpa"
+	"github.com/stretchr/testify/assert"
+)
+
+var testATR = []byte{0x3B, 0x9F, 0x96, 0x80, 0x1F, 0x87, 0x80, 0x31, 0xE0, 0x73, 0xFE, 0x21, 0x1B, 0x67, 0x4A, 0x4C, 0x75, 0x30, 0x34, 0x05, 0x4B, 0xA9}
+
+// pcscd is a fake pcscd with a single reader, forwarding the APDUs to a card.
+type pcscd struct {
+	socket  string
+	card    apdu.SmartCardChannel
+	mutex   sync.Mutex
+	states  [maxReaders]readerState
+	changed chan struct{}
+	done    chan struct{}
+	// stopped receives the stops of the clients waiting for reader changes.
+	stopped chan struct{}
+}
+
+func newPCSCD(t *testing.T, card apdu.SmartCardChannel) *pcscd {
+	d := &pcscd{
+		socket:  filepath.Join(t.TempDir(), "pcscd.comm"),
+		card:    card,
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}, 1),
+	}
+	copy(d.states[0].Name[:], "Fake Reader 00 00")
+	listener, err := net.Listen("unix", d.socket)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		close(d.done)
+		listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+// insert inserts the card, or removes it if atr is nil.
+func (d *pcscd) insert(atr []byte) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	state := &d.states[0]
+	state.State, state.ATRLength, state.ATR = 0, 0, [maxATRSize]byte{}
+	if atr != nil {
+		state.State = statePresent
+		state.ATRLength = uint32(copy(state.ATR[:], atr))
+	}
+	state.EventCounter++
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+func (d *pcscd) serve(conn net.Conn) {
+	defer conn.Close()
+	var writeMutex sync.Mutex
+	write := func(v any) {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		binary.Write(conn, binary.NativeEndian, v)
+	}
+	// changed is closed when the states read by the client change.
+	var changed chan struct{}
+	// stop is closed when the client stops waiting for reader changes.
+	var stop chan struct{}
+	for {
+		var h header
+		if err := binary.Read(conn, binary.NativeEndian, &h); err != nil {
+			return
+		}
+		switch h.Command {
+		case commandVersion:
+			var m versionMessage
+			binary.Read(conn, binary.NativeEndian, &m)
+			write(&m)
+		case commandEstablishContext:
+			var m establishMessage
+			binary.Read(conn, binary.NativeEndian, &m)
+			m.Context = 0x1234
+			write(&m)
+		case commandReleaseContext:
+			var m releaseMessage
+			binary.Read(conn, binary.NativeEndian, &m)
+			write(&m)
+		case commandGetReadersState:
+			d.mutex.Lock()
+			var states [maxReaders]readerState
+			states, changed = d.states, d.changed
+			d.mutex.Unlock()
+			write(&states)
+		case commandWaitReaderChanges:
+			// Like pcscd, answer with the reader states, then with a single wait message
+			// once they change or the client stops waiting.
+			d.mutex.Lock()
+			var states [maxReaders]readerState
+			states, changed = d.states, d.changed
+			d.mutex.Unlock()
+			write(&states)
+			stop = make(chan struct{})
+			go func(changed, stop chan struct{}) {
+				select {
+				case <-changed:
+					write(&waitMessage{})
+				case <-stop:
+					write(&waitMessage{})
+				case <-d.done:
+				}
+			}(changed, stop)
+		case commandStopWaitChanges:
+			if stop != nil {
+				close(stop)
+				stop = nil
+			}
+			d.stopped <- struct{}{}
+		case commandConnect:
+			var m connectMessage
+			binary.Read(conn, binary.NativeEndian, &m)
+			m.Card, m.ActiveProtocol = 1, ProtocolT1
+			if d.states[0].State&statePresent == 0 {
+				m.Result = codeNoSmartcard
+			}
+			write(&m)
+		case commandDisconnect:
+			var m disconnectMessage
+			binary.Read(conn, binary.NativeEndian, &m)
+			write(&m)
+		case commandTransmit:
+			var m transmitMessage
+			binary.Read(conn, binary.NativeEndian, &m)
+			command := make([]byte, m.SendLength)
+			io.ReadFull(conn, command)
+			response, err := d.card.Transmit(command)
+			if err != nil {
+				m.Result = codeUnresponsiveCard
+			}
+			m.ReceiveLength = uint32(len(response))
+			write(&m)
+			conn.Write(response)
+		default:
+			return
+		}
+	}
+}
+
+func TestPCSC(t *testing.T) {
+	card, err := simulator.New(nil)
+	assert.NoError(t, err)
+	assert.NoError(t, card.Connect())
+	d := newPCSCD(t, card)
+	d.insert(testATR)
+
+	c, err := NewWithOptions(&Options{Socket: d.socket})
+	assert.NoError(t, err)
+	readers, err := c.Readers()
+	assert.NoError(t, err)
+	assert.Equal(t, []ccid.Reader{{Name: "Fake Reader 00 00", Present: true, ATR: testATR}}, readers)
+	reader, err := ccid.SelectReader(c, "(?i)fake")
+	assert.NoError(t, err)
+	assert.Equal(t, "Fake Reader 00 00", reader)
+
+	client, err := lpa.New(&lpa.Options{
+		Channel: c,
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, testATR, c.ATR())
+	eid, err := client.EID()
+	assert.NoError(t, err)
+	assert.Len(t, eid, 16)
+	assert.NoError(t, client.Close())
+}
+
+func TestPCSC_Connect(t *testing.T) {
+	d := newPCSCD(t, nil)
+	c, err := NewWithOptions(&Options{Socket: d.socket})
+	assert.NoError(t, err)
+	c.SetReader("Fake Reader 00 00")
+	err = c.Connect()
+	var code Error
+	assert.True(t, errors.As(err, &code))
+	assert.Equal(t, Error(codeNoSmartcard), code)
+}
+
+func TestPCSC_Watch(t *testing.T) {
+	d := newPCSCD(t, nil)
+	c, err := NewWithOptions(&Options{Socket: d.socket})
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := c.Watch(ctx)
+	assert.NoError(t, err)
+
+	d.insert(testATR)
+	assert.Equal(t, ccid.Event{Type: ccid.EventInserted, Reader: "Fake Reader 00 00", ATR: testATR}, <-events)
+	d.insert(nil)
+	assert.Equal(t, ccid.Event{Type: ccid.EventRemoved, Reader: "Fake Reader 00 00", ATR: testATR}, <-events)
+
+	cancel()
+	for range events {
+	}
+	<-d.stopped
+}
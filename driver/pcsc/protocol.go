@@ -0,0 +1,329 @@
+package pcsc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Version of the pcsc-lite client/server protocol (winscard_msg.h).
+const (
+	protocolVersionMajor = 4
+	protocolVersionMinor = 4
+)
+
+// Commands of the pcsc-lite protocol.
+const (
+	commandEstablishContext  = 0x01
+	commandReleaseContext    = 0x02
+	commandConnect           = 0x04
+	commandDisconnect        = 0x06
+	commandTransmit          = 0x09
+	commandVersion           = 0x11
+	commandGetReadersState   = 0x12
+	commandWaitReaderChanges = 0x13
+	commandStopWaitChanges   = 0x14
+)
+
+const (
+	scopeSystem = 2
+
+	dispositionLeaveCard = 0
+
+	// maxReaderName is the size of the reader name fields, MAX_READERNAME.
+	maxReaderName = 128
+	// maxATRSize is the size of the ATR field, MAX_ATR_SIZE.
+	maxATRSize = 33
+	// maxReaders is the number of reader states sent by pcscd, PCSCLITE_MAX_READERS_CONTEXTS.
+	maxReaders = 16
+	// maxBufferSize is the size of the largest extended APDU, MAX_BUFFER_SIZE_EXTENDED.
+	maxBufferSize = 4 + 3 + 1<<16 + 3 + 2
+
+	// statePresent is set in the reader state while a card is inserted, SCARD_PRESENT.
+	statePresent = 0x0004
+)
+
+// PC/SC return codes.
+const (
+	codeSuccess            = 0x00000000
+	codeCancelled          = 0x80100002
+	codeInvalidHandle      = 0x80100003
+	codeInvalidParameter   = 0x80100004
+	codeNoMemory           = 0x80100006
+	codeTimeout            = 0x8010000A
+	codeSharingViolation   = 0x8010000B
+	codeNoSmartcard        = 0x8010000C
+	codeUnknownReader      = 0x80100009
+	codeProtocolMismatch   = 0x8010000F
+	codeNotTransacted      = 0x80100016
+	codeReaderUnavailable  = 0x80100017
+	codeNoService          = 0x8010001D
+	codeServiceStopped     = 0x8010001E
+	codeNoReadersAvailable = 0x8010002E
+	codeUnsupportedCard    = 0x80100065
+	codeUnresponsiveCard   = 0x80100066
+	codeUnpoweredCard      = 0x80100067
+	codeResetCard          = 0x80100068
+	codeRemovedCard        = 0x80100069
+)
+
+// Error is a PC/SC return code reported by pcscd.
+type Error uint32
+
+func (e Error) Error() string {
+	messages := map[Error]string{
+		codeCancelled:          "the action was cancelled",
+		codeInvalidHandle:      "the supplied handle was invalid",
+		codeInvalidParameter:   "one or more of the supplied parameters could not be properly interpreted",
+		codeNoMemory:           "not enough memory available to complete this command",
+		codeTimeout:            "the user-specified timeout value has expired",
+		codeSharingViolation:   "the smart card cannot be accessed because of other connections outstanding",
+		codeNoSmartcard:        "the operation requires a smart card, but no smart card is currently in the device",
+		codeUnknownReader:      "the specified reader name is not recognized",
+		codeProtocolMismatch:   "the requested protocols are incompatible with the protocol currently in use with the card",
+		codeNotTransacted:      "an attempt was made to end a non-existent transaction",
+		codeReaderUnavailable:  "the specified reader is not currently available for use",
+		codeNoService:          "the smart card resource manager is not running",
+		codeServiceStopped:     "the smart card resource manager has shut down",
+		codeNoReadersAvailable: "cannot find a smart card reader",
+		codeUnsupportedCard:    "the reader cannot communicate with the card, due to ATR string configuration conflicts",
+		codeUnresponsiveCard:   "the smart card is not responding to a reset",
+		codeUnpoweredCard:      "power has been removed from the smart card",
+		codeResetCard:          "the smart card has been reset, so any shared state information is invalid",
+		codeRemovedCard:        "the smart card has been removed",
+	}
+	if message, ok := messages[e]; ok {
+		return "pcsc: " + message
+	}
+	return fmt.Sprintf("pcsc: error 0x%08X", uint32(e))
+}
+
+// result converts a return code into an error.
+func result(code uint32) error {
+	if code == codeSuccess {
+		return nil
+	}
+	return Error(code)
+}
+
+// The messages below mirror the structures of winscard_msg.h, sent in the host byte order.
+
+type header struct {
+	Size    uint32
+	Command uint32
+}
+
+type versionMessage struct {
+	Major  int32
+	Minor  int32
+	Result uint32
+}
+
+type establishMessage struct {
+	Scope   uint32
+	Context uint32
+	Result  uint32
+}
+
+type releaseMessage struct {
+	Context uint32
+	Result  uint32
+}
+
+type connectMessage struct {
+	Context            uint32
+	Reader             [maxReaderName]byte
+	ShareMode          uint32
+	PreferredProtocols uint32
+	Card               int32
+	ActiveProtocol     uint32
+	Result             uint32
+}
+
+type disconnectMessage struct {
+	Card        int32
+	Disposition uint32
+	Result      uint32
+}
+
+type transmitMessage struct {
+	Card               int32
+	SendPCIProtocol    uint32
+	SendPCILength      uint32
+	SendLength         uint32
+	ReceivePCIProtocol uint32
+	ReceivePCILength   uint32
+	ReceiveLength      uint32
+	Result             uint32
+}
+
+type waitMessage struct {
+	Timeout uint32
+	Result  uint32
+}
+
+// readerState is the public state of a reader, READER_STATE.
+type readerState struct {
+	Name         [maxReaderName]byte
+	EventCounter uint32
+	State        uint32
+	Sharing      int32
+	ATR          [maxATRSize]byte
+	_            [3]byte
+	ATRLength    uint32
+	Protocol     uint32
+}
+
+func (s *readerState) name() string {
+	name, _, _ := bytes.Cut(s.Name[:], []byte{0})
+	return string(name)
+}
+
+func (s *readerState) present() bool {
+	return s.State&statePresent != 0
+}
+
+func (s *readerState) atr() []byte {
+	if !s.present() || s.ATRLength > maxATRSize {
+		return nil
+	}
+	return bytes.Clone(s.ATR[:s.ATRLength])
+}
+
+// client is a connection to pcscd. Every PC/SC context uses its own connection.
+type client struct {
+	mutex sync.Mutex
+	conn  net.Conn
+}
+
+// dial connects to pcscd and negotiates the protocol version.
+// pcscd rejects other minor versions, but the messages used here are the same in every 4.x version,
+// so the version reported by pcscd is tried once.
+func dial(socket string) (*client, error) {
+	version := versionMessage{Major: protocolVersionMajor, Minor: protocolVersionMinor}
+	for attempt := 0; ; attempt++ {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("connect pcscd %s: %w", socket, err)
+		}
+		c := &client{conn: conn}
+		proposed := version
+		if err := c.call(commandVersion, &version, &version); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if version.Result == codeSuccess {
+			return c, nil
+		}
+		conn.Close()
+		if attempt > 0 || version.Major != protocolVersionMajor || version.Minor == proposed.Minor {
+			return nil, fmt.Errorf("pcscd protocol %d.%d is not supported: %w", version.Major, version.Minor, Error(version.Result))
+		}
+		version.Result = codeSuccess
+	}
+}
+
+// call sends the command with the request and reads the reply into response.
+func (c *client) call(command uint32, request, response any) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.send(command, request, nil); err != nil {
+		return err
+	}
+	return c.receive(response)
+}
+
+func (c *client) send(command uint32, request any, payload []byte) error {
+	var buf bytes.Buffer
+	size := 0
+	if request != nil {
+		size = binary.Size(request)
+	}
+	binary.Write(&buf, binary.NativeEndian, header{Size: uint32(size), Command: command})
+	if request != nil {
+		binary.Write(&buf, binary.NativeEndian, request)
+	}
+	buf.Write(payload)
+	_, err := buf.WriteTo(c.conn)
+	return err
+}
+
+func (c *client) receive(response any) error {
+	return binary.Read(c.conn, binary.NativeEndian, response)
+}
+
+// readerStates returns the state of every reader known to pcscd.
+func (c *client) readerStates() ([]readerState, error) {
+	var states [maxReaders]readerState
+	if err := c.call(commandGetReadersState, nil, &states); err != nil {
+		return nil, err
+	}
+	return attached(states), nil
+}
+
+// waitReaderChanges returns the state of every reader and registers for their next change.
+// pcscd then sends a waitMessage once the state of any reader changes, or when the client stops waiting.
+func (c *client) waitReaderChanges() ([]readerState, error) {
+	var states [maxReaders]readerState
+	if err := c.call(commandWaitReaderChanges, nil, &states); err != nil {
+		return nil, err
+	}
+	return attached(states), nil
+}
+
+// stopWaiting cancels waitReaderChanges. pcscd answers with a waitMessage, unless it has already sent one.
+func (c *client) stopWaiting() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.send(commandStopWaitChanges, nil, nil)
+}
+
+// attached returns the states of the attached readers.
+func attached(states [maxReaders]readerState) []readerState {
+	var attached []readerState
+	for _, state := range states {
+		if state.Name[0] != 0 {
+			attached = append(attached, state)
+		}
+	}
+	return attached
+}
+
+// transmit sends the command to the card and returns its response.
+func (c *client) transmit(card int32, protocol uint32, command []byte) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	request := transmitMessage{
+		Card:               card,
+		SendPCIProtocol:    protocol,
+		SendPCILength:      8,
+		SendLength:         uint32(len(command)),
+		ReceivePCIProtocol: protocol,
+		ReceivePCILength:   8,
+		ReceiveLength:      maxBufferSize,
+	}
+	if err := c.send(commandTransmit, &request, command); err != nil {
+		return nil, err
+	}
+	if err := c.receive(&request); err != nil {
+		return nil, err
+	}
+	if err := result(request.Result); err != nil {
+		return nil, err
+	}
+	if request.ReceiveLength > maxBufferSize {
+		return nil, fmt.Errorf("response too long: %d bytes", request.ReceiveLength)
+	}
+	response := make([]byte, request.ReceiveLength)
+	if _, err := io.ReadFull(c.conn, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *client) close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,38 @@
+package pcsc
+
+import (
+	"net/url"
+
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/euicc-go/driver"
+	"github.com/damonto/euicc-go/driver/ccid"
+)
+
+// The PC/SC driver registers the "pcsc" scheme. The path is the pcscd socket, which defaults to DefaultSocket.
+// The reader and the share mode are selected as in the "ccid" scheme:
+//
+//	pcsc:?reader=(?i)omnikey&share=shared
+//	pcsc:///var/run/pcscd/pcscd.comm?atr=3B9F96801F878031E073FE211B674A4C753034054BA9
+func init() {
+	driver.Register("pcsc", open)
+}
+
+func open(u *url.URL) (apdu.SmartCardChannel, error) {
+	query, err := ccid.ParseReaderQuery(u.Query())
+	if err != nil {
+		return nil, err
+	}
+	opts := &Options{Socket: u.Path, ShareMode: ShareExclusive}
+	if query.Shared {
+		opts.ShareMode = ShareShared
+	}
+	c, err := NewWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = query.Select(c); err != nil {
+		c.(*PCSC).client.close()
+		return nil, err
+	}
+	return c, nil
+}
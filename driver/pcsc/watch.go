@@ -0,0 +1,91 @@
+package pcsc
+
+import (
+	"context"
+
+	"github.com/damonto/euicc-go/driver/ccid"
+)
+
+// Watch reports card insertions and removals in every reader until ctx is done, then closes the channel.
+// Cards already inserted when Watch is called are reported as inserted.
+// The watcher uses its own connection to pcscd, so it does not interfere with the connected card.
+func (p *PCSC) Watch(ctx context.Context) (<-chan ccid.Event, error) {
+	c, err := dial(p.options.Socket)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan ccid.Event)
+	go watch(ctx, c, events)
+	return events, nil
+}
+
+func watch(ctx context.Context, c *client, events chan<- ccid.Event) {
+	defer close(events)
+	defer c.close()
+	readers := make(map[string]readerState)
+	for ctx.Err() == nil {
+		states, err := c.waitReaderChanges()
+		if err != nil {
+			return
+		}
+		current := make(map[string]readerState, len(states))
+		for _, state := range states {
+			current[state.name()] = state
+		}
+		for name, previous := range readers {
+			if _, ok := current[name]; !ok && !emit(ctx, events, changes(previous, readerState{Name: previous.Name})...) {
+				return
+			}
+		}
+		for name, state := range current {
+			if !emit(ctx, events, changes(readers[name], state)...) {
+				return
+			}
+		}
+		readers = current
+		// pcscd sends the wait message once the state of any reader changes.
+		waited := make(chan error, 1)
+		go func() {
+			var wait waitMessage
+			waited <- c.receive(&wait)
+		}()
+		select {
+		case err := <-waited:
+			if err != nil {
+				return
+			}
+		case <-ctx.Done():
+			// A single wait message follows, answering either the change or the stop.
+			if c.stopWaiting() == nil {
+				<-waited
+			}
+			return
+		}
+	}
+}
+
+func emit(ctx context.Context, events chan<- ccid.Event, changes ...ccid.Event) bool {
+	for _, event := range changes {
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// changes returns the events between the previous and the current state of a reader.
+// The event counter reveals a card swapped between two reader states.
+func changes(previous, current readerState) []ccid.Event {
+	was, is := previous.present(), current.present()
+	swapped := was && is && previous.EventCounter != current.EventCounter
+	var events []ccid.Event
+	if was && (!is || swapped) {
+		events = append(events, ccid.Event{Type: ccid.EventRemoved, Reader: previous.name(), ATR: previous.atr()})
+	}
+	if is && (!was || swapped) {
+		events = append(events, ccid.Event{Type: ccid.EventInserted, Reader: current.name(), ATR: current.atr()})
+	}
+	return events
+}
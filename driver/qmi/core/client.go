@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"sync/atomic"
 	"time"
+
+	"github.com/damonto/euicc-go/driver"
 )
 
 // QMIClient implements the apdu.SmartCardChannel interface using QMI protocol
@@ -16,10 +18,14 @@ type QMIClient struct {
 	channel   byte
 }
 
-// Connect establishes QMI session and allocates UIM client ID
+// Connect establishes QMI session and allocates UIM client ID.
+// Slot 0 uses the active slot as is, any other slot is activated with SwitchSlot.
 func (q *QMIClient) Connect() error {
-	if err := q.ensureSlotActivated(); err != nil {
-		return err
+	if q.Slot != 0 {
+		// Some older devices do not support the GetSlotStatusRequest QMI command
+		if err := q.SwitchSlot(q.Slot); err != nil && !errors.Is(err, QMIErrorNotSupported) {
+			return err
+		}
 	}
 	// In QMI mode, we need to keep the SIM slot set to 1, because once the
 	// configured slot becomes active, it will be assigned as slot 1.
@@ -27,27 +33,8 @@ func (q *QMIClient) Connect() error {
 	return nil
 }
 
-// ensureSlotActivated checks if the desired slot is activated and activates it if necessary
-func (q *QMIClient) ensureSlotActivated() error {
-	slot, err := q.currentActivatedSlot()
-	if err != nil {
-		// Some older devices do not support the GetSlotStatusRequest QMI command
-		if errors.Is(err, QMIErrorNotSupported) {
-			return nil
-		}
-		return err
-	}
-	if slot == q.Slot {
-		return nil
-	}
-	if err := q.switchSlot(); err != nil {
-		return err
-	}
-	return q.waitForSlotActivation()
-}
-
 // waitForSlotActivation waits for the specified slot to be activated
-func (q *QMIClient) waitForSlotActivation() error {
+func (q *QMIClient) waitForSlotActivation(slot uint8) error {
 	var err error
 	for range 10 {
 		request := GetCardStatusRequest{
@@ -63,10 +50,35 @@ func (q *QMIClient) waitForSlotActivation() error {
 		}
 		time.Sleep(500 * time.Millisecond)
 	}
-	return fmt.Errorf("sim did not become available after slot %d activation err: %w", q.Slot, err)
+	return fmt.Errorf("sim did not become available after slot %d activation err: %w", slot, err)
+}
+
+// WaitReady implements driver.ReadyWaiter by polling the card status until the USIM application is ready.
+// QMI errors are expected while the card restarts; other errors, such as an invalid client ID
+// after a modem reset, are returned at once.
+func (q *QMIClient) WaitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		request := GetCardStatusRequest{
+			ClientID:      q.ClientID,
+			TransactionID: uint16(atomic.AddUint32(&q.TxnID, 1)),
+		}
+		err := q.Transport.Transmit(request.Request())
+		var qmiErr QMIError
+		if err != nil && (!errors.As(err, &qmiErr) || qmiErr == QMIErrorInvalidClientId) {
+			return err
+		}
+		if err == nil && request.Response.Ready() {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("card is not ready after %s: %w", timeout, err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
 }
 
-// currentActivatedSlot returns the currently active logical slot
+// currentActivatedSlot returns the currently active physical slot
 func (q *QMIClient) currentActivatedSlot() (uint8, error) {
 	request := GetSlotStatusRequest{
 		ClientID:      q.ClientID,
@@ -78,17 +90,76 @@ func (q *QMIClient) currentActivatedSlot() (uint8, error) {
 	return request.Response.ActivatedSlot, nil
 }
 
-// switchSlot switches to the specified logical and physical slot
-func (q *QMIClient) switchSlot() error {
+// switchSlot maps the physical slot to logical slot 1
+func (q *QMIClient) switchSlot(slot uint8) error {
 	request := SwitchSlotRequest{
 		ClientID:      q.ClientID,
 		TransactionID: uint16(atomic.AddUint32(&q.TxnID, 1)),
 		LogicalSlot:   1,
-		PhysicalSlot:  uint32(q.Slot),
+		PhysicalSlot:  uint32(slot),
 	}
 	return q.Transport.Transmit(request.Request())
 }
 
+// Slots implements driver.SlotManager.
+func (q *QMIClient) Slots() ([]driver.Slot, error) {
+	request := GetSlotStatusRequest{
+		ClientID:      q.ClientID,
+		TransactionID: uint16(atomic.AddUint32(&q.TxnID, 1)),
+	}
+	if err := q.Transport.Transmit(request.Request()); err != nil {
+		return nil, err
+	}
+	slots := make([]driver.Slot, len(request.Response.Slots))
+	for i, slot := range request.Response.Slots {
+		slots[i] = driver.Slot{
+			Number:  uint8(i + 1),
+			Active:  slot.SlotState == UIMSlotStateActive,
+			Present: slot.CardState == UIMPhysicalCardStatePresent,
+			ICCID:   slot.ICCID,
+			EID:     slot.EID,
+			EUICC:   slot.EUICC,
+		}
+	}
+	return slots, nil
+}
+
+// SwitchSlot implements driver.SlotManager.
+func (q *QMIClient) SwitchSlot(slot uint8) error {
+	current, err := q.currentActivatedSlot()
+	if err != nil {
+		return err
+	}
+	if current == slot {
+		return nil
+	}
+	if err := q.switchSlot(slot); err != nil {
+		return err
+	}
+	return q.waitForSlotActivation(slot)
+}
+
+// Subscribe registers the client for UIM card status and physical slot status events and returns a channel
+// receiving the UIM indications of the client, until cancel is called or the transport is closed.
+// The Value of a QMIUIMCardStatusIndication decodes with GetCardStatusResponse, so callers can wait for
+// the card to be ready after a profile switch instead of polling GetCardStatus.
+func (q *QMIClient) Subscribe() (indications <-chan Indication, cancel func(), err error) {
+	indications, cancel = q.Transport.Subscribe(func(indication Indication) bool {
+		return indication.ServiceType == QMIServiceUIM &&
+			(indication.ClientID == q.ClientID || indication.ClientID == 0xFF)
+	})
+	request := RegisterEventsRequest{
+		ClientID:      q.ClientID,
+		TransactionID: uint16(atomic.AddUint32(&q.TxnID, 1)),
+		Events:        UIMEventRegistrationCardStatus | UIMEventRegistrationPhysicalSlotStatus,
+	}
+	if err := q.Transport.Transmit(request.Request()); err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return indications, cancel, nil
+}
+
 // OpenLogicalChannel opens a logical channel with the specified AID
 func (q *QMIClient) OpenLogicalChannel(AID []byte) (byte, error) {
 	request := OpenLogicalChannelRequest{
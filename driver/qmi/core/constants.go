@@ -5,6 +5,8 @@ type ServiceType uint8
 
 const (
 	QMIServiceControl ServiceType = 0x00 // Control service
+	QMIServiceDMS     ServiceType = 0x02 // Device management service
+	QMIServiceNAS     ServiceType = 0x03 // Network access service
 	QMIServiceUIM     ServiceType = 0x0B // UIM service
 )
 
@@ -15,6 +17,10 @@ const (
 	QMIMessageTypeRequest    MessageType = 0x00
 	QMIMessageTypeResponse   MessageType = 0x02
 	QMIMessageTypeIndication MessageType = 0x04
+
+	// CTL service messages use their own control flags.
+	QMICtlMessageTypeResponse   MessageType = 0x01
+	QMICtlMessageTypeIndication MessageType = 0x02
 )
 
 // MessageID represents QMI command message IDs
@@ -24,6 +30,7 @@ const (
 	// CTL service commands
 	QMICtlCmdAllocateClientID MessageID = 0x0022
 	QMICtlCmdReleaseClientID  MessageID = 0x0023
+	QMICtlCmdSync             MessageID = 0x0027
 	QMICtlInternalProxyOpen   MessageID = 0xFF00
 
 	// UIM service commands
@@ -33,6 +40,12 @@ const (
 	QMIUIMSwitchSlot          MessageID = 0x0046
 	QMIUIMGetSlotStatus       MessageID = 0x0047
 	QMIUIMGetCardStatus       MessageID = 0x002F
+	QMIUIMRegisterEvents      MessageID = 0x002E
+
+	// UIM service indications
+	QMIUIMCardStatusIndication MessageID = 0x0032
+	QMIUIMRefreshIndication    MessageID = 0x0033
+	QMIUIMSlotStatusIndication MessageID = 0x0048
 )
 
 // QMUX header constants
@@ -101,3 +114,13 @@ const (
 	UIMCardApplicationStateIllegal                   UIMCardApplicationState = 0x06
 	UIMCardApplicationStateReady                     UIMCardApplicationState = 0x07
 )
+
+// UIM Event Registration
+type UIMEventRegistration uint32
+
+const (
+	UIMEventRegistrationCardStatus         UIMEventRegistration = 1 << 0
+	UIMEventRegistrationSAPConnection      UIMEventRegistration = 1 << 1
+	UIMEventRegistrationExtendedCardStatus UIMEventRegistration = 1 << 2
+	UIMEventRegistrationPhysicalSlotStatus UIMEventRegistration = 1 << 4
+)
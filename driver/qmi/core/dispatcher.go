@@ -0,0 +1,185 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// indicationBuffer is the number of indications a subscriber may have pending before new ones are dropped.
+const indicationBuffer = 16
+
+var ErrTransportClosed = errors.New("qmi: transport closed")
+
+// Message is a QMI response or indication read by a transport.
+type Message struct {
+	ServiceType   ServiceType
+	ClientID      uint8
+	TransactionID uint16
+	MessageID     MessageID
+	Indication    bool
+	Value         TLVs
+	// Err is the result of a response, or the error that occurred while parsing its TLVs.
+	Err error
+}
+
+// Indication is an unsolicited QMI message, such as a UIM card status change.
+type Indication struct {
+	ServiceType ServiceType
+	ClientID    uint8
+	MessageID   MessageID
+	Value       TLVs
+}
+
+type key struct {
+	serviceType   ServiceType
+	clientID      uint8
+	transactionID uint16
+}
+
+func newKey(serviceType ServiceType, clientID uint8, transactionID uint16) key {
+	if serviceType == QMIServiceControl {
+		// CTL messages carry an 8-bit transaction ID.
+		transactionID = uint16(uint8(transactionID))
+	}
+	return key{serviceType: serviceType, clientID: clientID, transactionID: transactionID}
+}
+
+type subscriber struct {
+	ch    chan Indication
+	match func(Indication) bool
+}
+
+// Dispatcher routes the messages read by a transport: a response goes to the request waiting for
+// the same service, client and transaction ID, and an indication goes to the matching subscribers.
+// Messages nobody waits for are dropped.
+type Dispatcher struct {
+	mutex       sync.Mutex
+	pending     map[key]chan *Message
+	subscribers map[*subscriber]struct{}
+	closed      chan struct{}
+	err         error
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		pending:     make(map[key]chan *Message),
+		subscribers: make(map[*subscriber]struct{}),
+		closed:      make(chan struct{}),
+	}
+}
+
+// Transmit sends the request with write and waits for its response.
+func (d *Dispatcher) Transmit(request *Request, write func() error) error {
+	k := newKey(request.ServiceType, request.ClientID, request.TransactionID)
+	ch := make(chan *Message, 1)
+	d.mutex.Lock()
+	if d.err != nil {
+		d.mutex.Unlock()
+		return d.failure()
+	}
+	d.pending[k] = ch
+	d.mutex.Unlock()
+	defer func() {
+		d.mutex.Lock()
+		delete(d.pending, k)
+		d.mutex.Unlock()
+	}()
+	if err := write(); err != nil {
+		return err
+	}
+	timeout := request.ReadTimeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case message := <-ch:
+		if message.Err != nil {
+			return message.Err
+		}
+		return request.Response.UnmarshalResponse(&message.Value)
+	case <-timer.C:
+		return fmt.Errorf("timed out waiting for response for transaction ID %d", request.TransactionID)
+	case <-d.closed:
+		return d.failure()
+	}
+}
+
+// Dispatch routes a message read by the transport.
+func (d *Dispatcher) Dispatch(message *Message) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if message.Indication {
+		indication := Indication{
+			ServiceType: message.ServiceType,
+			ClientID:    message.ClientID,
+			MessageID:   message.MessageID,
+			Value:       message.Value,
+		}
+		for s := range d.subscribers {
+			if s.match != nil && !s.match(indication) {
+				continue
+			}
+			select {
+			case s.ch <- indication:
+			default:
+			}
+		}
+		return
+	}
+	if ch, ok := d.pending[newKey(message.ServiceType, message.ClientID, message.TransactionID)]; ok {
+		select {
+		case ch <- message:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving the indications accepted by match until the returned function
+// is called or the dispatcher is closed. A nil match accepts every indication.
+// Indications are dropped while the channel is full.
+func (d *Dispatcher) Subscribe(match func(Indication) bool) (<-chan Indication, func()) {
+	s := &subscriber{ch: make(chan Indication, indicationBuffer), match: match}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.err != nil {
+		close(s.ch)
+		return s.ch, func() {}
+	}
+	d.subscribers[s] = struct{}{}
+	return s.ch, func() {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		if _, ok := d.subscribers[s]; ok {
+			delete(d.subscribers, s)
+			close(s.ch)
+		}
+	}
+}
+
+// Close fails the pending and future requests with err and closes the subscriptions.
+func (d *Dispatcher) Close(err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.err != nil {
+		return
+	}
+	d.err = err
+	for s := range d.subscribers {
+		delete(d.subscribers, s)
+		close(s.ch)
+	}
+	close(d.closed)
+}
+
+func (d *Dispatcher) failure() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if errors.Is(d.err, ErrTransportClosed) {
+		return d.err
+	}
+	return fmt.Errorf("%w: %w", ErrTransportClosed, d.err)
+}
@@ -104,6 +104,31 @@ func (r *ReleaseClientIDResponse) UnmarshalResponse(TLVs *TLVs) error {
 
 // endregion
 
+// region Sync Request
+
+// SyncRequest releases every client ID allocated on the device by earlier users.
+type SyncRequest struct {
+	TransactionID uint16
+	Response      *SyncResponse
+}
+
+func (r *SyncRequest) Request() *Request {
+	r.Response = new(SyncResponse)
+	return &Request{
+		TransactionID: r.TransactionID,
+		MessageID:     QMICtlCmdSync,
+		ServiceType:   QMIServiceControl,
+		ReadTimeout:   5 * time.Second,
+		Response:      r.Response,
+	}
+}
+
+type SyncResponse struct{}
+
+func (r *SyncResponse) UnmarshalResponse(TLVs *TLVs) error { return nil }
+
+// endregion
+
 // region Switch Slot Request
 
 type SwitchSlotRequest struct {
@@ -166,7 +191,10 @@ type Slot struct {
 	CardState   UIMPhysicalCardState
 	SlotState   UIMSlotState
 	LogicalSlot uint8
-	ICCID       [10]byte
+	ICCID       []byte
+	// EUICC and EID are only reported by modems supporting the physical slot information and EID TLVs.
+	EUICC bool
+	EID   []byte
 }
 
 func (r *GetSlotStatusResponse) UnmarshalResponse(TLVs *TLVs) error {
@@ -183,19 +211,43 @@ func (r *GetSlotStatusResponse) UnmarshalResponse(TLVs *TLVs) error {
 		binary.Read(buf, binary.LittleEndian, &slot.CardState)
 		binary.Read(buf, binary.LittleEndian, &slot.SlotState)
 		binary.Read(buf, binary.LittleEndian, &slot.LogicalSlot)
-		var iccidLen uint8
-		binary.Read(buf, binary.LittleEndian, &iccidLen)
-		if iccidLen > 0 {
-			binary.Read(buf, binary.LittleEndian, &slot.ICCID)
-		}
+		slot.ICCID = readBytes(buf)
 		if slot.SlotState == UIMSlotStateActive {
 			r.ActivatedSlot = uint8(i + 1)
 		}
 		r.Slots = append(r.Slots, slot)
 	}
+	// Physical slot information: card protocol, valid applications, ATR and eUICC flag of every slot.
+	if value, ok := TLVs.Find(0x11); ok {
+		buf := bytes.NewBuffer(value.Value)
+		binary.Read(buf, binary.LittleEndian, &slotCount)
+		for i := 0; i < int(slotCount) && i < len(r.Slots); i++ {
+			buf.Next(5)
+			readBytes(buf)
+			euicc, _ := buf.ReadByte()
+			r.Slots[i].EUICC = euicc == 1
+		}
+	}
+	// Slot EID information.
+	if value, ok := TLVs.Find(0x12); ok {
+		buf := bytes.NewBuffer(value.Value)
+		binary.Read(buf, binary.LittleEndian, &slotCount)
+		for i := 0; i < int(slotCount) && i < len(r.Slots); i++ {
+			r.Slots[i].EID = readBytes(buf)
+		}
+	}
 	return nil
 }
 
+// readBytes reads an array prefixed with its 8-bit length.
+func readBytes(buf *bytes.Buffer) []byte {
+	n, err := buf.ReadByte()
+	if err != nil || n == 0 {
+		return nil
+	}
+	return bytes.Clone(buf.Next(int(n)))
+}
+
 // endregion
 
 // region Get Card Status Request
@@ -287,6 +339,42 @@ func (r *GetCardStatusResponse) Ready() bool {
 
 // endregion
 
+// region Register Events Request
+
+type RegisterEventsRequest struct {
+	ClientID      uint8
+	TransactionID uint16
+	Events        UIMEventRegistration
+	Response      *RegisterEventsResponse
+}
+
+func (r *RegisterEventsRequest) Request() *Request {
+	r.Response = new(RegisterEventsResponse)
+	return &Request{
+		ClientID:      r.ClientID,
+		TransactionID: r.TransactionID,
+		MessageID:     QMIUIMRegisterEvents,
+		ServiceType:   QMIServiceUIM,
+		Value: TLVs{
+			{Type: 0x01, Len: 4, Value: binary.LittleEndian.AppendUint32(nil, uint32(r.Events))},
+		},
+		Response: r.Response,
+	}
+}
+
+type RegisterEventsResponse struct {
+	Events UIMEventRegistration
+}
+
+func (r *RegisterEventsResponse) UnmarshalResponse(TLVs *TLVs) error {
+	if value, ok := TLVs.Find(0x10); ok && len(value.Value) >= 4 {
+		r.Events = UIMEventRegistration(binary.LittleEndian.Uint32(value.Value))
+	}
+	return nil
+}
+
+// endregion
+
 // region Open Logical Channel Request
 
 type OpenLogicalChannelRequest struct {
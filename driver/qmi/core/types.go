@@ -18,4 +18,6 @@ type ResponseUnmarshaler interface {
 
 type Transport interface {
 	Transmit(request *Request) error
+	// Subscribe returns a channel receiving the indications accepted by match until cancel is called.
+	Subscribe(match func(Indication) bool) (indications <-chan Indication, cancel func())
 }
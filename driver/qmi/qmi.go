@@ -1,18 +1,38 @@
 package qmi
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/damonto/euicc-go/apdu"
 	"github.com/damonto/euicc-go/driver/qmi/core"
 	transport "github.com/damonto/euicc-go/driver/qmi/transport/qmi"
 )
 
+// Mode selects how the driver reaches the QMI device.
+type Mode int
+
+const (
+	// ModeProxy shares the device with other clients through libqmi's qmi-proxy.
+	ModeProxy Mode = iota
+	// ModeDirect talks QMUX to the cdc-wdm device itself, for images without libqmi.
+	// No other QMI client may use the device at the same time.
+	ModeDirect
+	// ModeAuto uses qmi-proxy when it is running and opens the device directly otherwise.
+	ModeAuto
+)
+
+type Options struct {
+	// Mode selects the proxy or the direct transport. It defaults to ModeProxy.
+	Mode Mode
+}
+
 // QMI implements the apdu.SmartCardChannel interface using QMI protocol
 type QMI struct {
 	core.QMIClient
@@ -20,20 +40,45 @@ type QMI struct {
 	device string
 }
 
-// New creates a new QMI connection to the specified device
+// New creates a new QMI connection to the specified device.
+// Connect activates the physical slot with SwitchSlot, unless slot is 0 which uses the active slot as is.
+// The returned channel implements driver.SlotManager.
 func New(device string, slot uint8) (apdu.SmartCardChannel, error) {
-	conn, err := newQMIConn()
+	return NewWithOptions(device, slot, nil)
+}
+
+// NewWithOptions creates a new QMI connection to the specified device using the transport selected by opts.
+func NewWithOptions(device string, slot uint8, opts *Options) (apdu.SmartCardChannel, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	var q *QMI
+	var err error
+	switch opts.Mode {
+	case ModeProxy:
+		q, err = newProxy(device, slot)
+	case ModeDirect:
+		q, err = newDirect(device, slot)
+	case ModeAuto:
+		if q, err = newProxy(device, slot); err != nil {
+			q, err = newDirect(device, slot)
+		}
+	default:
+		err = fmt.Errorf("unknown mode %d", opts.Mode)
+	}
 	if err != nil {
 		return nil, err
 	}
-	q := &QMI{
-		conn:   conn,
-		device: device,
-		QMIClient: core.QMIClient{
-			Transport: transport.New(conn),
-			Slot:      slot,
-		},
+	return q, nil
+}
+
+// newProxy connects to the device through qmi-proxy
+func newProxy(device string, slot uint8) (*QMI, error) {
+	conn, err := newQMIConn()
+	if err != nil {
+		return nil, err
 	}
+	q := newQMI(conn, device, slot)
 	if err := q.openProxyConnection(); err != nil {
 		q.conn.Close()
 		return nil, err
@@ -45,6 +90,35 @@ func New(device string, slot uint8) (apdu.SmartCardChannel, error) {
 	return q, nil
 }
 
+// newDirect opens the device and synchronizes its CTL service before allocating a client ID
+func newDirect(device string, slot uint8) (*QMI, error) {
+	conn, err := transport.OpenDevice(device)
+	if err != nil {
+		return nil, err
+	}
+	q := newQMI(conn, device, slot)
+	if err := q.sync(); err != nil {
+		q.conn.Close()
+		return nil, err
+	}
+	if err := q.allocateClientID(); err != nil {
+		q.conn.Close()
+		return nil, err
+	}
+	return q, nil
+}
+
+func newQMI(conn net.Conn, device string, slot uint8) *QMI {
+	return &QMI{
+		conn:   conn,
+		device: device,
+		QMIClient: core.QMIClient{
+			Transport: transport.New(conn),
+			Slot:      slot,
+		},
+	}
+}
+
 // newQMIConn establishes connection to qmi-proxy
 func newQMIConn() (net.Conn, error) {
 	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
@@ -75,6 +149,18 @@ func (q *QMI) openProxyConnection() error {
 	return err
 }
 
+// sync releases the client IDs left allocated on the device, e.g. by a process that did not exit cleanly
+func (q *QMI) sync() error {
+	request := core.SyncRequest{
+		TransactionID: uint16(atomic.AddUint32(&q.TxnID, 1)),
+	}
+	err := q.Transport.Transmit(request.Request())
+	if err == io.EOF {
+		return fmt.Errorf("device %s doesn't support QMI protocol", q.device)
+	}
+	return err
+}
+
 // allocateClientID sends a request to allocate a client ID for UIM service
 func (q *QMI) allocateClientID() error {
 	request := core.AllocateClientIDRequest{
@@ -91,6 +177,18 @@ func (q *QMI) allocateClientID() error {
 	return nil
 }
 
+// WaitReady implements driver.ReadyWaiter.
+// A modem reset releases the UIM client, so a new client ID is allocated when the device no longer knows it.
+func (q *QMI) WaitReady(timeout time.Duration) error {
+	err := q.QMIClient.WaitReady(timeout)
+	if errors.Is(err, core.QMIErrorInvalidClientId) {
+		if err = q.allocateClientID(); err == nil {
+			err = q.QMIClient.WaitReady(timeout)
+		}
+	}
+	return err
+}
+
 // releaseClientID sends a request to release the allocated client ID
 func (q *QMI) releaseClientID() error {
 	request := core.ReleaseClientIDRequest{
@@ -100,9 +198,11 @@ func (q *QMI) releaseClientID() error {
 	return q.Transport.Transmit(request.Request())
 }
 
-// Disconnect releases the client ID and closes the connection
+// Disconnect releases the client ID and closes the connection.
+// The connection is closed even if the release fails, so the device is not left open.
 func (q *QMI) Disconnect() error {
 	if err := q.releaseClientID(); err != nil {
+		q.conn.Close()
 		return err
 	}
 	return q.conn.Close()
@@ -0,0 +1,185 @@
+package qmi
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/damonto/euicc-go/driver"
+	"github.com/damonto/euicc-go/driver/qmi/core"
+	sgp22 "github.com/damonto/euicc-go/v2"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+var success = []byte{0x02, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+// modem answers CTL and UIM requests like a device opened without qmi-proxy and records the requested message IDs.
+type modem struct {
+	conn     net.Conn
+	requests chan core.MessageID
+	messages chan []byte
+}
+
+func newModem(t *testing.T) (*modem, net.Conn) {
+	client, conn := net.Pipe()
+	m := &modem{conn: conn, requests: make(chan core.MessageID, 8), messages: make(chan []byte, 8)}
+	go m.serve()
+	// net.Pipe is unbuffered, so messages the client does not read yet must not block serve.
+	go func() {
+		for message := range m.messages {
+			m.conn.Write(message)
+		}
+	}()
+	t.Cleanup(func() { conn.Close() })
+	return m, client
+}
+
+func (m *modem) serve() {
+	for {
+		header := make([]byte, 6)
+		if _, err := io.ReadFull(m.conn, header); err != nil {
+			return
+		}
+		body := make([]byte, int(binary.LittleEndian.Uint16(header[1:3]))-5)
+		if _, err := io.ReadFull(m.conn, body); err != nil {
+			return
+		}
+		service, clientID := core.ServiceType(header[4]), header[5]
+		var transactionID uint16
+		var messageID core.MessageID
+		if service == core.QMIServiceControl {
+			transactionID = uint16(body[1])
+			messageID = core.MessageID(binary.LittleEndian.Uint16(body[2:4]))
+		} else {
+			transactionID = binary.LittleEndian.Uint16(body[1:3])
+			messageID = core.MessageID(binary.LittleEndian.Uint16(body[3:5]))
+		}
+		m.requests <- messageID
+		switch messageID {
+		case core.QMICtlCmdSync:
+			m.write(service, 0, core.QMICtlMessageTypeResponse, transactionID, messageID, success)
+			m.write(service, 0, core.QMICtlMessageTypeIndication, 0, messageID, nil)
+		case core.QMICtlCmdAllocateClientID, core.QMICtlCmdReleaseClientID:
+			m.write(service, 0, core.QMICtlMessageTypeResponse, transactionID, messageID,
+				append(success, 0x01, 0x02, 0x00, byte(core.QMIServiceUIM), 0x05))
+		case core.QMIUIMRegisterEvents:
+			// A card status indication and the response of another transaction arrive first.
+			status := []byte{0x10, 0x0F, 0x00, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x01, 0, 0, 0, 0, 0x00}
+			m.write(service, clientID, core.QMIMessageTypeIndication, 0, core.QMIUIMCardStatusIndication, status)
+			m.write(service, clientID, core.QMIMessageTypeResponse, transactionID+1, messageID, []byte{0x02, 0x04, 0x00, 0x01, 0x00, 0x01, 0x00})
+			m.write(service, clientID, core.QMIMessageTypeResponse, transactionID, messageID, success)
+		case core.QMIUIMGetSlotStatus:
+			m.write(service, clientID, core.QMIMessageTypeResponse, transactionID, messageID, append(success, slotStatus...))
+		}
+	}
+}
+
+// slotStatus holds an active eUICC in slot 1 and an empty slot 2.
+var slotStatus = []byte{
+	// Physical slot status
+	0x10, 0x1F, 0x00, 0x02,
+	0x02, 0, 0, 0, 0x01, 0, 0, 0, 0x01, 0x0A, 0x98, 0x10, 0x14, 0x30, 0x12, 0x11, 0x81, 0x15, 0x32, 0xF6,
+	0x01, 0, 0, 0, 0x00, 0, 0, 0, 0x00, 0x00,
+	// Physical slot information
+	0x11, 0x11, 0x00, 0x02,
+	0x02, 0, 0, 0, 0x01, 0x02, 0x3B, 0x00, 0x01,
+	0x00, 0, 0, 0, 0x00, 0x00, 0x00,
+	// Slot EID information
+	0x12, 0x13, 0x00, 0x02,
+	0x10, 0x89, 0x04, 0x90, 0x32, 0x12, 0x34, 0x51, 0x23, 0x45, 0x12, 0x34, 0x56, 0x78, 0x90, 0x12, 0x24,
+	0x00,
+}
+
+func (m *modem) write(service core.ServiceType, clientID uint8, messageType core.MessageType, transactionID uint16, messageID core.MessageID, value []byte) {
+	b := []byte{core.QMUXHeaderIfType, 0, 0, 0x80, byte(service), clientID, byte(messageType)}
+	if service == core.QMIServiceControl {
+		b = append(b, byte(transactionID))
+	} else {
+		b = binary.LittleEndian.AppendUint16(b, transactionID)
+	}
+	b = binary.LittleEndian.AppendUint16(b, uint16(messageID))
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	binary.LittleEndian.PutUint16(b[1:3], uint16(len(b)-1))
+	m.messages <- b
+}
+
+func TestQMI_Direct(t *testing.T) {
+	m, conn := newModem(t)
+	q := newQMI(conn, "/dev/cdc-wdm0", 1)
+	assert.NoError(t, q.sync())
+	assert.NoError(t, q.allocateClientID())
+	assert.Equal(t, uint8(5), q.ClientID)
+	assert.NoError(t, q.Disconnect())
+	assert.Equal(t, core.QMICtlCmdSync, <-m.requests)
+	assert.Equal(t, core.QMICtlCmdAllocateClientID, <-m.requests)
+	assert.Equal(t, core.QMICtlCmdReleaseClientID, <-m.requests)
+}
+
+func TestQMI_Subscribe(t *testing.T) {
+	_, conn := newModem(t)
+	q := newQMI(conn, "/dev/cdc-wdm0", 1)
+	q.ClientID = 5
+	indications, cancel, err := q.Subscribe()
+	assert.NoError(t, err)
+	defer cancel()
+	select {
+	case indication := <-indications:
+		assert.Equal(t, core.QMIUIMCardStatusIndication, indication.MessageID)
+		var status core.GetCardStatusResponse
+		assert.NoError(t, status.UnmarshalResponse(&indication.Value))
+		assert.Equal(t, core.UIMCardStatusPresent, status.Cards[0].State)
+	case <-time.After(time.Second):
+		t.Fatal("indication not received")
+	}
+	conn.Close()
+	_, ok := <-indications
+	assert.False(t, ok)
+}
+
+func TestQMI_Slots(t *testing.T) {
+	_, conn := newModem(t)
+	q := newQMI(conn, "/dev/cdc-wdm0", 0)
+	q.ClientID = 5
+	slots, err := q.Slots()
+	assert.NoError(t, err)
+	assert.Len(t, slots, 2)
+	assert.Equal(t, driver.Slot{
+		Number:  1,
+		Active:  true,
+		Present: true,
+		ICCID:   sgp22.ICCID{0x98, 0x10, 0x14, 0x30, 0x12, 0x11, 0x81, 0x15, 0x32, 0xF6},
+		EID:     []byte{0x89, 0x04, 0x90, 0x32, 0x12, 0x34, 0x51, 0x23, 0x45, 0x12, 0x34, 0x56, 0x78, 0x90, 0x12, 0x24},
+		EUICC:   true,
+	}, slots[0])
+	assert.Equal(t, "8901410321111851236", slots[0].ICCID.String())
+	assert.Equal(t, driver.Slot{Number: 2}, slots[1])
+	assert.NoError(t, q.SwitchSlot(1))
+}
+
+func TestQRTRConn_Close(t *testing.T) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_DGRAM, 0)
+	assert.NoError(t, err)
+	defer unix.Close(fds[1])
+	conn := &QRTRConn{fd: fds[0], Service: &Service{}, readTimeout: 30 * time.Second}
+
+	done := make(chan error)
+	go func() {
+		_, err := conn.Read(make([]byte, 16))
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, conn.Close())
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(3 * time.Second):
+		t.Fatal("read did not return")
+	}
+	assert.ErrorIs(t, conn.Close(), net.ErrClosed)
+	_, err = conn.Write([]byte{0x00})
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -71,11 +73,24 @@ type Service struct {
 	Port     uint32
 }
 
+// Version returns the interface version encoded in the low byte of Instance.
+func (s Service) Version() uint8 {
+	return uint8(s.Instance)
+}
+
+// InstanceID returns the service instance encoded in the upper bytes of Instance.
+func (s Service) InstanceID() uint32 {
+	return s.Instance >> 8
+}
+
 // QRTRConn represents a QRTR connection
 type QRTRConn struct {
 	fd          int
 	Service     *Service
 	readTimeout time.Duration
+	// mutex is read locked by the system calls on fd, so that Close does not release it under a pending recvfrom.
+	mutex  sync.RWMutex
+	closed atomic.Bool
 }
 
 // QRTR implements the apdu.SmartCardChannel interface using QRTR protocol
@@ -84,51 +99,104 @@ type QRTR struct {
 	core.QMIClient
 }
 
-// NewQRTR creates a new QRTR connection to the UIM service
+// NewQRTR creates a new QRTR connection to the first UIM service found.
+// As with New, slot 0 uses the active slot as is and the returned channel implements driver.SlotManager.
 func NewQRTR(slot uint8) (apdu.SmartCardChannel, error) {
+	return newQRTR(slot, func(Service) bool { return true })
+}
+
+// NewQRTRInstance creates a new QRTR connection to the UIM service published by node with the given instance,
+// for SoCs with several modems or UIM instances. Node and instance are those reported by ListQRTRServices.
+func NewQRTRInstance(node, instance uint32, slot uint8) (apdu.SmartCardChannel, error) {
+	return newQRTR(slot, func(service Service) bool {
+		return service.Node == node && service.Instance == instance
+	})
+}
+
+func newQRTR(slot uint8, match func(Service) bool) (apdu.SmartCardChannel, error) {
 	conn, err := newQRTRConn()
 	if err != nil {
 		return nil, err
 	}
-	q := &QRTR{
+	services, err := lookup(conn, core.QMIServiceUIM)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for _, service := range services {
+		if match(service) {
+			conn.Service = &service
+			break
+		}
+	}
+	if conn.Service == nil {
+		conn.Close()
+		return nil, fmt.Errorf("service %d not found", core.QMIServiceUIM)
+	}
+	// The transport reads the connection from now on, so it is created once the service is found.
+	return &QRTR{
 		conn: conn,
 		QMIClient: core.QMIClient{
-			Transport: transport.New(conn),
+			Transport: transport.New(conn, core.QMIServiceUIM),
 			Slot:      slot,
 		},
+	}, nil
+}
+
+// ListQRTRServices returns every service published on the QRTR bus, e.g. UIM, DMS and NAS of each modem.
+func ListQRTRServices() ([]Service, error) {
+	conn, err := newQRTRConn()
+	if err != nil {
+		return nil, err
 	}
-	q.conn.Service, err = q.findService(core.QMIServiceUIM)
+	defer conn.Close()
+	return lookup(conn, 0)
+}
+
+// DialQRTR connects to a service returned by ListQRTRServices.
+// Use transport.New from driver/qmi/transport/qrtr to send QMI requests to it, e.g. to read the IMEI from DMS.
+func DialQRTR(service Service) (*QRTRConn, error) {
+	conn, err := newQRTRConn()
 	if err != nil {
-		conn.Close()
 		return nil, err
 	}
-	return q, nil
+	conn.Service = &service
+	return conn, nil
 }
 
-func (c *QRTR) findService(serviceType core.ServiceType) (*Service, error) {
-	if err := c.sendControlPacket(serviceType); err != nil {
+// lookup returns the servers of the service type, or of every service type when it is 0.
+// The name service answers with one NEW_SERVER packet per server and an empty one at the end of the listing.
+func lookup(conn *QRTRConn, serviceType core.ServiceType) ([]Service, error) {
+	if err := sendLookup(conn, serviceType); err != nil {
 		return nil, err
 	}
-	timeout := time.Now().Add(5 * time.Second)
-	for time.Now().Before(timeout) {
-		buf := make([]byte, 1024)
-		n, _, err := c.conn.Recv(buf)
+	conn.readTimeout = 5 * time.Second
+	defer func() { conn.readTimeout = 30 * time.Second }()
+	var services []Service
+	buf := make([]byte, 1024)
+	for {
+		n, from, err := conn.Recv(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			// The name service did not end the listing.
+			return services, nil
+		}
 		if err != nil {
 			return nil, err
 		}
-		if QRTRPacketType(binary.LittleEndian.Uint32(buf[:4])) != QRTRPacketTypeNewServer {
+		if from.Port != QRTRPortControl || n < 20 ||
+			QRTRPacketType(binary.LittleEndian.Uint32(buf[:4])) != QRTRPacketTypeNewServer {
 			continue
 		}
 		var service Service
 		binary.Read(bytes.NewReader(buf[4:n]), binary.LittleEndian, &service)
-		if core.ServiceType(service.Service) == serviceType {
-			return &service, nil
+		if service == (Service{}) {
+			return services, nil
 		}
+		services = append(services, service)
 	}
-	return nil, fmt.Errorf("service %d not found", serviceType)
 }
 
-func (c *QRTR) sendControlPacket(serviceType core.ServiceType) error {
+func sendLookup(conn *QRTRConn, serviceType core.ServiceType) error {
 	pkt := &ControlPacket{
 		Command: QRTRPacketTypeNewLookup,
 		Service: Service{
@@ -140,7 +208,7 @@ func (c *QRTR) sendControlPacket(serviceType core.ServiceType) error {
 	}
 	buf := new(bytes.Buffer)
 	binary.Write(buf, binary.LittleEndian, pkt)
-	_, err := c.conn.Sendto(&SockAddr{
+	_, err := conn.Sendto(&SockAddr{
 		Family: AF_QIPCRTR,
 		Node:   QRTRNodeBroadcast,
 		Port:   QRTRPortControl,
@@ -160,10 +228,25 @@ func newQRTRConn() (*QRTRConn, error) {
 	return &QRTRConn{fd: fd, readTimeout: 30 * time.Second}, nil
 }
 
+// use runs f with the file descriptor of an open connection.
+func (c *QRTRConn) use(f func(fd int) error) error {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.closed.Load() {
+		return net.ErrClosed
+	}
+	return f(c.fd)
+}
+
 func (c *QRTRConn) Sendto(dest *SockAddr, data []byte) (int, error) {
 	if len(data) == 0 {
 		return 0, errors.New("data is empty")
 	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.closed.Load() {
+		return 0, net.ErrClosed
+	}
 	n, _, errno := unix.Syscall6(unix.SYS_SENDTO,
 		uintptr(c.fd),
 		uintptr(unsafe.Pointer(&data[0])),
@@ -180,6 +263,11 @@ func (c *QRTRConn) Sendto(dest *SockAddr, data []byte) (int, error) {
 func (c *QRTRConn) Recvfrom(buf []byte) (int, *SockAddr, error) {
 	var addr SockAddr
 	addrLen := uintptr(unsafe.Sizeof(addr))
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.closed.Load() {
+		return 0, nil, net.ErrClosed
+	}
 	n, _, errno := unix.Syscall6(unix.SYS_RECVFROM,
 		uintptr(c.fd),
 		uintptr(unsafe.Pointer(&buf[0])),
@@ -187,6 +275,10 @@ func (c *QRTRConn) Recvfrom(buf []byte) (int, *SockAddr, error) {
 		0,
 		uintptr(unsafe.Pointer(&addr)),
 		uintptr(unsafe.Pointer(&addrLen)))
+	if c.closed.Load() {
+		// Woken up by the shutdown of Close.
+		return 0, nil, net.ErrClosed
+	}
 	if errno != 0 {
 		return 0, nil, fmt.Errorf("receive data: %w", errno)
 	}
@@ -194,8 +286,11 @@ func (c *QRTRConn) Recvfrom(buf []byte) (int, *SockAddr, error) {
 }
 
 func (c *QRTRConn) Recv(b []byte) (int, *SockAddr, error) {
+	// The receive timeout also bounds the wait of Close for a pending recvfrom.
 	tv := unix.NsecToTimeval((1 * time.Second).Nanoseconds())
-	if err := unix.SetsockoptTimeval(c.fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+	if err := c.use(func(fd int) error {
+		return unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+	}); err != nil {
 		return 0, nil, err
 	}
 
@@ -238,7 +333,14 @@ func (c *QRTRConn) Write(b []byte) (int, error) {
 	}, b)
 }
 
+// Close shuts the socket down to wake up a pending read, then closes it once the read has returned.
 func (c *QRTRConn) Close() error {
+	if c.closed.Swap(true) {
+		return net.ErrClosed
+	}
+	unix.Shutdown(c.fd, unix.SHUT_RDWR)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	return unix.Close(c.fd)
 }
 
@@ -272,7 +374,9 @@ func (c *QRTRConn) SetReadDeadline(t time.Time) error {
 
 func (c *QRTRConn) SetWriteDeadline(t time.Time) error {
 	tv := unix.NsecToTimeval(c.toTimeDuration(t).Nanoseconds())
-	return unix.SetsockoptTimeval(c.fd, unix.SOL_SOCKET, unix.SO_SNDTIMEO, &tv)
+	return c.use(func(fd int) error {
+		return unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_SNDTIMEO, &tv)
+	})
 }
 
 func (c *QRTRConn) toTimeDuration(t time.Time) time.Duration {
@@ -0,0 +1,67 @@
+package qmi
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/euicc-go/driver"
+)
+
+// The QMI driver registers the following schemes:
+//
+//	qmi:///dev/cdc-wdm0?slot=1&mode=direct   mode is "proxy", "direct" or "auto" and defaults to "proxy"
+//	qrtr:?slot=1                             the first UIM service found
+//	qrtr:?slot=1&node=0&instance=1           the UIM service of a node and instance reported by ListQRTRServices
+//
+// The slot defaults to 0, which uses the active slot without switching; any other slot is activated on Connect
+// with SwitchSlot.
+func init() {
+	driver.Register("qmi", openQMI)
+	driver.Register("qrtr", openQRTR)
+}
+
+func openQMI(u *url.URL) (apdu.SmartCardChannel, error) {
+	slot, err := driver.QuerySlot(u)
+	if err != nil {
+		return nil, err
+	}
+	opts := new(Options)
+	if opts.Mode, err = parseMode(u.Query().Get("mode")); err != nil {
+		return nil, err
+	}
+	return NewWithOptions(driver.Device(u), slot, opts)
+}
+
+func openQRTR(u *url.URL) (apdu.SmartCardChannel, error) {
+	slot, err := driver.QuerySlot(u)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	if !query.Has("node") && !query.Has("instance") {
+		return NewQRTR(slot)
+	}
+	node, err := strconv.ParseUint(query.Get("node"), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node %q: %w", query.Get("node"), err)
+	}
+	instance, err := strconv.ParseUint(query.Get("instance"), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid instance %q: %w", query.Get("instance"), err)
+	}
+	return NewQRTRInstance(uint32(node), uint32(instance), slot)
+}
+
+func parseMode(value string) (Mode, error) {
+	switch value {
+	case "", "proxy":
+		return ModeProxy, nil
+	case "direct":
+		return ModeDirect, nil
+	case "auto":
+		return ModeAuto, nil
+	}
+	return 0, fmt.Errorf("unknown mode %q", value)
+}
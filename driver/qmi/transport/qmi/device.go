@@ -0,0 +1,30 @@
+package qmi
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// device is a cdc-wdm character device carrying QMUX messages.
+type device struct {
+	*os.File
+}
+
+type deviceAddr string
+
+func (a deviceAddr) Network() string { return "qmi" }
+func (a deviceAddr) String() string  { return string(a) }
+
+// OpenDevice opens the cdc-wdm device at path for direct QMUX access, without qmi-proxy.
+// The device is not shared: no other QMI client may use it at the same time.
+func OpenDevice(path string) (net.Conn, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	return &device{File: f}, nil
+}
+
+func (d *device) LocalAddr() net.Addr  { return deviceAddr(d.Name()) }
+func (d *device) RemoteAddr() net.Addr { return deviceAddr(d.Name()) }
@@ -49,4 +49,12 @@ func (r *Response) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
+// Indication reports whether the message is an indication rather than a response.
+func (r *Response) Indication() bool {
+	if r.ServiceType == core.QMIServiceControl {
+		return r.MessageType == core.QMICtlMessageTypeIndication
+	}
+	return r.MessageType == core.QMIMessageTypeIndication
+}
+
 // endregion
@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net"
-	"time"
+	"sync"
 
 	"github.com/damonto/euicc-go/driver/qmi/core"
 )
@@ -26,12 +26,18 @@ type Header[T uint8 | uint16] struct {
 	MessageLength uint16
 }
 
+// Transport sends QMUX messages over a connection to qmi-proxy or to a cdc-wdm device.
+// A single goroutine reads the connection and routes responses and indications, until the connection is closed.
 type Transport struct {
-	conn net.Conn
+	conn       net.Conn
+	mutex      sync.Mutex
+	dispatcher *core.Dispatcher
 }
 
 func New(conn net.Conn) core.Transport {
-	return &Transport{conn: conn}
+	t := &Transport{conn: conn, dispatcher: core.NewDispatcher()}
+	go t.read()
+	return t
 }
 
 func (t *Transport) bytes(r *core.Request) ([]byte, error) {
@@ -70,43 +76,40 @@ func (t *Transport) bytes(r *core.Request) ([]byte, error) {
 	return requestBuf.Bytes(), nil
 }
 
-// Read reads a response from the connection and unmarshals it into the Request's Response field
-func (t *Transport) Read(c net.Conn, r *core.Request) (int, error) {
-	if r.ReadTimeout == 0 {
-		r.ReadTimeout = 30 * time.Second
-	}
-	deadline := time.Now().Add(r.ReadTimeout)
-	for time.Now().Before(deadline) {
-		c.SetReadDeadline(time.Now().Add(1 * time.Second))
-
+// read routes every message of the connection until it fails, e.g. because it was closed.
+func (t *Transport) read() {
+	for {
 		header := make([]byte, 3)
-		if _, err := io.ReadAtLeast(c, header, 3); err != nil {
-			if ne, ok := err.(net.Error); ok && ne.Timeout() {
-				continue
-			}
-			return 0, err
+		if _, err := io.ReadFull(t.conn, header); err != nil {
+			t.dispatcher.Close(err)
+			return
 		}
-
 		length := int(binary.LittleEndian.Uint16(header[1:3])) + 1
+		if length < 3 {
+			t.dispatcher.Close(fmt.Errorf("invalid QMUX length %d", length))
+			return
+		}
 		buf := make([]byte, length)
 		copy(buf[:3], header)
-		if _, err := io.ReadFull(c, buf[3:]); err != nil {
-			return 0, err
-		}
-
-		var response Response
-		if err := response.UnmarshalBinary(buf[:length]); err != nil {
-			return 0, err
+		if _, err := io.ReadFull(t.conn, buf[3:]); err != nil {
+			t.dispatcher.Close(err)
+			return
 		}
-		if r.ClientID != response.ClientID && response.TransactionID != r.TransactionID {
+		if len(buf) < 11 {
 			continue
 		}
-		if err := r.Response.UnmarshalResponse(&response.Value); err != nil {
-			return 0, err
-		}
-		return length, nil
+		var response Response
+		err := response.UnmarshalBinary(buf)
+		t.dispatcher.Dispatch(&core.Message{
+			ServiceType:   response.ServiceType,
+			ClientID:      response.ClientID,
+			TransactionID: response.TransactionID,
+			MessageID:     response.MessageID,
+			Indication:    response.Indication(),
+			Value:         response.Value,
+			Err:           err,
+		})
 	}
-	return 0, fmt.Errorf("timed out waiting for response for transaction ID %d", r.TransactionID)
 }
 
 func (t *Transport) Transmit(request *core.Request) error {
@@ -114,9 +117,14 @@ func (t *Transport) Transmit(request *core.Request) error {
 	if err != nil {
 		return err
 	}
-	if _, err = t.conn.Write(bs); err != nil {
+	return t.dispatcher.Transmit(request, func() error {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		_, err := t.conn.Write(bs)
 		return err
-	}
-	_, err = t.Read(t.conn, request)
-	return err
+	})
+}
+
+func (t *Transport) Subscribe(match func(core.Indication) bool) (<-chan core.Indication, func()) {
+	return t.dispatcher.Subscribe(match)
 }
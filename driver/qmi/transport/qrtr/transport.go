@@ -3,19 +3,29 @@ package qrtr
 import (
 	"bytes"
 	"encoding/binary"
-	"fmt"
+	"errors"
 	"net"
-	"time"
+	"os"
+	"sync"
 
 	"github.com/damonto/euicc-go/driver/qmi/core"
 )
 
+// Transport sends QMI messages to a QRTR service.
+// A single goroutine reads the connection and routes responses and indications, until the connection is closed.
 type Transport struct {
-	conn net.Conn
+	conn       net.Conn
+	service    core.ServiceType
+	mutex      sync.Mutex
+	dispatcher *core.Dispatcher
 }
 
-func New(conn net.Conn) core.Transport {
-	return &Transport{conn: conn}
+// New returns a transport for the QMI service reached through conn.
+// QRTR messages carry no service type, so it is taken from the connection.
+func New(conn net.Conn, service core.ServiceType) core.Transport {
+	t := &Transport{conn: conn, service: service, dispatcher: core.NewDispatcher()}
+	go t.read()
+	return t
 }
 
 func (t *Transport) bytes(r *core.Request) ([]byte, error) {
@@ -32,32 +42,32 @@ func (t *Transport) bytes(r *core.Request) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// Read reads a response from the connection and unmarshals it into the Request's Response field
-func (t *Transport) Read(c net.Conn, r *core.Request) (int, error) {
-	if r.ReadTimeout == 0 {
-		r.ReadTimeout = 30 * time.Second
-	}
-	deadline := time.Now().Add(r.ReadTimeout)
-	for time.Now().Before(deadline) {
-		buf := make([]byte, 512)
-		n, err := c.Read(buf)
-		if err != nil {
-			return 0, err
+// read routes every message of the connection until it fails, e.g. because it was closed.
+func (t *Transport) read() {
+	buf := make([]byte, 65536)
+	for {
+		n, err := t.conn.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
 		}
-
-		var response Response
-		if err := response.UnmarshalBinary(buf[:n]); err != nil {
-			return 0, err
+		if err != nil {
+			t.dispatcher.Close(err)
+			return
 		}
-		if response.TransactionID != r.TransactionID {
+		if n < 7 {
 			continue
 		}
-		if err := r.Response.UnmarshalResponse(&response.Value); err != nil {
-			return 0, err
-		}
-		return n, nil
+		var response Response
+		err = response.UnmarshalBinary(bytes.Clone(buf[:n]))
+		t.dispatcher.Dispatch(&core.Message{
+			ServiceType:   t.service,
+			TransactionID: response.TransactionID,
+			MessageID:     response.MessageID,
+			Indication:    response.MessageType == core.QMIMessageTypeIndication,
+			Value:         response.Value,
+			Err:           err,
+		})
 	}
-	return 0, fmt.Errorf("timed out waiting for response for transaction ID %d", r.TransactionID)
 }
 
 func (t *Transport) Transmit(request *core.Request) error {
@@ -65,9 +75,14 @@ func (t *Transport) Transmit(request *core.Request) error {
 	if err != nil {
 		return err
 	}
-	if _, err = t.conn.Write(bs); err != nil {
+	return t.dispatcher.Transmit(request, func() error {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		_, err := t.conn.Write(bs)
 		return err
-	}
-	_, err = t.Read(t.conn, request)
-	return err
+	})
+}
+
+func (t *Transport) Subscribe(match func(core.Indication) bool) (<-chan core.Indication, func()) {
+	return t.dispatcher.Subscribe(match)
 }
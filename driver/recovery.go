@@ -0,0 +1,79 @@
+package driver
+
+import (
+	"errors"
+	"time"
+
+	"github.com/damonto/euicc-go/apdu"
+)
+
+// ReadyWaiter is implemented by the channels able to tell when the card is ready, such as QMI and MBIM.
+// A Transmitter waits for it before reopening a lost logical channel.
+type ReadyWaiter interface {
+	// WaitReady waits until the card is ready, restoring the session with the modem if the modem was reset.
+	WaitReady(timeout time.Duration) error
+}
+
+// Recovery configures how a Transmitter restores its logical channel when it is lost,
+// e.g. after the refresh triggered by enabling a profile or after a modem reset.
+// Once the ISD-R is selected on a new logical channel, the command is sent again only when the card
+// answered that it does not know the channel, so the command never ran. When the driver fails,
+// the command may have run and its error is returned after restoring the channel.
+type Recovery struct {
+	// Retries is the number of times the channel is restored for a command.
+	// Zero or less disables the recovery, so it is opt-in.
+	Retries int
+	// Timeout is the time to wait for the card to be ready again. It defaults to 30 seconds.
+	Timeout time.Duration
+}
+
+func (r *Recovery) setDefaults() {
+	if r.Timeout == 0 {
+		r.Timeout = 30 * time.Second
+	}
+}
+
+// channelLost reports whether the card answered that it no longer knows the logical channel,
+// so the command was not run.
+func channelLost(err error) bool {
+	var status apdu.StatusError
+	return errors.As(err, &status) && status.ChannelLost()
+}
+
+// driverFailed reports whether err is a failure of the driver rather than an answer of the card.
+func driverFailed(err error) bool {
+	var status apdu.StatusError
+	return !errors.As(err, &status) && !errors.Is(err, ErrSessionClosed)
+}
+
+// readyWaiter returns the ReadyWaiter of channel, looking through wrapping channels such as gsmtap.Channel.
+func readyWaiter(channel apdu.SmartCardChannel) (ReadyWaiter, bool) {
+	for {
+		if waiter, ok := channel.(ReadyWaiter); ok {
+			return waiter, true
+		}
+		wrapper, ok := channel.(interface{ Unwrap() apdu.SmartCardChannel })
+		if !ok {
+			return nil, false
+		}
+		channel = wrapper.Unwrap()
+	}
+}
+
+// recover waits for the card to be ready and reopens the logical channel until the timeout expires.
+func (t *transmitter) recover(cause error) error {
+	t.logger.Debug("[APDU] logical channel lost, reopening", "error", cause)
+	deadline := time.Now().Add(t.recovery.Timeout)
+	if waiter, ok := readyWaiter(t.channel); ok {
+		if err := waiter.WaitReady(t.recovery.Timeout); err != nil {
+			return err
+		}
+	}
+	for {
+		err := t.card.Reopen()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
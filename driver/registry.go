@@ -0,0 +1,115 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/damonto/euicc-go/apdu"
+)
+
+var ErrUnknownScheme = errors.New("unknown driver scheme")
+
+// Opener creates a SmartCardChannel from a parsed driver URI. The channel is not connected yet.
+type Opener func(u *url.URL) (apdu.SmartCardChannel, error)
+
+var (
+	openersMutex sync.RWMutex
+	openers      = make(map[string]Opener)
+)
+
+// Register makes a driver available to Open under the URI scheme.
+// Driver packages register their schemes in init, so importing a driver package is enough to use it:
+//
+//	import _ "github.com/damonto/euicc-go/driver/qmi"
+//
+// Register panics if the scheme is already registered or opener is nil.
+func Register(scheme string, opener Opener) {
+	openersMutex.Lock()
+	defer openersMutex.Unlock()
+	if opener == nil {
+		panic("driver: Register opener is nil")
+	}
+	if _, ok := openers[scheme]; ok {
+		panic("driver: Register called twice for scheme " + scheme)
+	}
+	openers[scheme] = opener
+}
+
+// Open creates a SmartCardChannel from a driver URI, e.g. "qmi:///dev/cdc-wdm0?slot=2".
+// The scheme selects the registered driver, which parses the rest of the URI.
+func Open(uri string) (apdu.SmartCardChannel, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	openersMutex.RLock()
+	opener, ok := openers[u.Scheme]
+	openersMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q (forgotten import?)", ErrUnknownScheme, u.Scheme)
+	}
+	return opener(u)
+}
+
+// Close disconnects a channel returned by Open and closes it when it is an io.Closer,
+// e.g. a reader listening for cards, also when it is wrapped such as by gsmtap.NewChannel.
+func Close(channel apdu.SmartCardChannel) error {
+	if closer, ok := closer(channel); ok {
+		return errors.Join(channel.Disconnect(), closer.Close())
+	}
+	return channel.Disconnect()
+}
+
+// closer returns the io.Closer of channel, looking through wrapping channels as readyWaiter does.
+func closer(channel apdu.SmartCardChannel) (io.Closer, bool) {
+	for {
+		if closer, ok := channel.(io.Closer); ok {
+			return closer, true
+		}
+		wrapper, ok := channel.(interface{ Unwrap() apdu.SmartCardChannel })
+		if !ok {
+			return nil, false
+		}
+		channel = wrapper.Unwrap()
+	}
+}
+
+// Schemes returns the sorted list of the registered schemes.
+func Schemes() []string {
+	openersMutex.RLock()
+	defer openersMutex.RUnlock()
+	schemes := make([]string, 0, len(openers))
+	for scheme := range openers {
+		schemes = append(schemes, scheme)
+	}
+	slices.Sort(schemes)
+	return schemes
+}
+
+// Device returns the device of a driver URI, the opaque part of "at:COM3" or else the host and the path,
+// e.g. "COM3" for "at://COM3" and "/dev/cdc-wdm0" for "qmi:///dev/cdc-wdm0".
+func Device(u *url.URL) string {
+	if u.Opaque != "" {
+		return u.Opaque
+	}
+	return u.Host + u.Path
+}
+
+// QuerySlot returns the "slot" query parameter of a driver URI.
+// It defaults to 0, which uses the active slot without switching.
+func QuerySlot(u *url.URL) (uint8, error) {
+	value := u.Query().Get("slot")
+	if value == "" {
+		return 0, nil
+	}
+	slot, err := strconv.ParseUint(value, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid slot %q: %w", value, err)
+	}
+	return uint8(slot), nil
+}
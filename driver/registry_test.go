@@ -0,0 +1,83 @@
+package driver
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpen(t *testing.T) {
+	var opened *url.URL
+	Register("test", func(u *url.URL) (apdu.SmartCardChannel, error) {
+		opened = u
+		return nil, nil
+	})
+	assert.Contains(t, Schemes(), "test")
+	assert.Panics(t, func() { Register("test", func(*url.URL) (apdu.SmartCardChannel, error) { return nil, nil }) })
+
+	_, err := Open("test:///dev/cdc-wdm0?slot=2")
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/cdc-wdm0", opened.Path)
+	slot, err := QuerySlot(opened)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(2), slot)
+
+	_, err = Open("unknown:///dev/cdc-wdm0")
+	assert.ErrorIs(t, err, ErrUnknownScheme)
+}
+
+func TestQuerySlot(t *testing.T) {
+	slot, err := QuerySlot(&url.URL{})
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(0), slot)
+	_, err = QuerySlot(&url.URL{RawQuery: "slot=256"})
+	assert.Error(t, err)
+}
+
+func TestDevice(t *testing.T) {
+	for uri, device := range map[string]string{
+		"at:COM3":                   "COM3",
+		"at://COM3":                 "COM3",
+		"qmi:///dev/cdc-wdm0":       "/dev/cdc-wdm0",
+		"mbim:/dev/cdc-wdm0?slot=1": "/dev/cdc-wdm0",
+		"ccid:?reader=omnikey":      "",
+	} {
+		u, err := url.Parse(uri)
+		assert.NoError(t, err)
+		assert.Equal(t, device, Device(u), uri)
+	}
+}
+
+// listener is a channel that keeps listening for cards until it is closed.
+type listener struct {
+	apdu.SmartCardChannel
+	disconnected, closed bool
+}
+
+func (l *listener) Disconnect() error {
+	l.disconnected = true
+	return nil
+}
+
+func (l *listener) Close() error {
+	l.closed = true
+	return nil
+}
+
+// wrapper wraps a channel as gsmtap.Channel does.
+type wrapper struct {
+	apdu.SmartCardChannel
+}
+
+func (w *wrapper) Unwrap() apdu.SmartCardChannel {
+	return w.SmartCardChannel
+}
+
+func TestClose(t *testing.T) {
+	l := new(listener)
+	assert.NoError(t, Close(&wrapper{SmartCardChannel: l}))
+	assert.True(t, l.disconnected)
+	assert.True(t, l.closed)
+}
@@ -0,0 +1,191 @@
+package simulator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Certificates is the certificate chain used by the simulator to sign its responses.
+type Certificates struct {
+	// CI is the DER encoded GSMA CI certificate.
+	CI []byte
+	// CIKey is the CI private key. It is only known for generated test chains
+	// and can be used to issue SM-DP+ certificates for tests.
+	CIKey *ecdsa.PrivateKey
+	// EUM is the DER encoded EUM certificate.
+	EUM []byte
+	// EUICC is the DER encoded eUICC certificate.
+	EUICC []byte
+	// EUICCKey is the private key of the eUICC certificate.
+	EUICCKey *ecdsa.PrivateKey
+}
+
+// CIPKID returns the subject key identifier of the CI certificate.
+func (c *Certificates) CIPKID() ([]byte, error) {
+	certificate, err := x509.ParseCertificate(c.CI)
+	if err != nil {
+		return nil, err
+	}
+	return certificate.SubjectKeyId, nil
+}
+
+// NewTestCertificates generates a CI, EUM and eUICC certificate chain on the NIST P-256 curve.
+// The chain is only meant for tests and is not trusted by any production SM-DP+.
+func NewTestCertificates(eid []byte) (*Certificates, error) {
+	var c Certificates
+	var err error
+	if c.CIKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return nil, err
+	}
+	ci := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "euicc-go Test CI", Organization: []string{"euicc-go"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          keyID(&c.CIKey.PublicKey),
+	}
+	if c.CI, err = x509.CreateCertificate(rand.Reader, ci, ci, &c.CIKey.PublicKey, c.CIKey); err != nil {
+		return nil, err
+	}
+	eumKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	eum := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "euicc-go Test EUM", Organization: []string{"euicc-go"}},
+		NotBefore:             ci.NotBefore,
+		NotAfter:              ci.NotAfter,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+		SubjectKeyId:          keyID(&eumKey.PublicKey),
+	}
+	if c.EUM, err = x509.CreateCertificate(rand.Reader, eum, ci, &eumKey.PublicKey, c.CIKey); err != nil {
+		return nil, err
+	}
+	if c.EUICCKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return nil, err
+	}
+	euicc := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject: pkix.Name{
+			CommonName:   "euicc-go Test eUICC",
+			Organization: []string{"euicc-go"},
+			SerialNumber: strings.ToUpper(hex.EncodeToString(eid)),
+		},
+		NotBefore:    ci.NotBefore,
+		NotAfter:     ci.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		SubjectKeyId: keyID(&c.EUICCKey.PublicKey),
+	}
+	if c.EUICC, err = x509.CreateCertificate(rand.Reader, euicc, eum, &c.EUICCKey.PublicKey, eumKey); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func keyID(key *ecdsa.PublicKey) []byte {
+	der, _ := x509.MarshalPKIXPublicKey(key)
+	sum := sha256.Sum256(der)
+	return sum[:20]
+}
+
+// sign signs data with the eUICC key and returns the signature as r||s, as required by SGP.22.
+func (c *Certificates) sign(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	der, err := ecdsa.SignASN1(rand.Reader, c.EUICCKey, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	return rawSignature(der, c.EUICCKey.Curve.Params().BitSize)
+}
+
+// verify checks an r||s signature made with the key of the DER encoded certificate.
+func verify(certificate []byte, data []byte, signature []byte) error {
+	parsed, err := x509.ParseCertificate(certificate)
+	if err != nil {
+		return err
+	}
+	key, ok := parsed.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("certificate does not carry an ECDSA key")
+	}
+	size := len(signature) / 2
+	if size == 0 || len(signature)%2 != 0 {
+		return errors.New("invalid signature length")
+	}
+	digest := sha256.Sum256(data)
+	r := new(big.Int).SetBytes(signature[:size])
+	s := new(big.Int).SetBytes(signature[size:])
+	if !ecdsa.Verify(key, digest[:], r, s) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+func rawSignature(der []byte, bitSize int) ([]byte, error) {
+	var signature struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &signature); err != nil {
+		return nil, err
+	}
+	size := (bitSize + 7) / 8
+	raw := make([]byte, 2*size)
+	signature.R.FillBytes(raw[:size])
+	signature.S.FillBytes(raw[size:])
+	return raw, nil
+}
+
+// oid returns the registered ID from the subject alternative name of an SM-DP+ certificate.
+func oid(certificate []byte) asn1.ObjectIdentifier {
+	parsed, err := x509.ParseCertificate(certificate)
+	if err != nil {
+		return nil
+	}
+	for _, extension := range parsed.Extensions {
+		if !extension.Id.Equal(asn1.ObjectIdentifier{2, 5, 29, 17}) {
+			continue
+		}
+		var names []asn1.RawValue
+		if _, err := asn1.Unmarshal(extension.Value, &names); err != nil {
+			return nil
+		}
+		for _, name := range names {
+			// registeredID [8] OBJECT IDENTIFIER
+			if name.Class == asn1.ClassContextSpecific && name.Tag == 8 {
+				var id asn1.ObjectIdentifier
+				if _, err := asn1.Unmarshal(append([]byte{0x06, byte(len(name.Bytes))}, name.Bytes...), &id); err == nil {
+					return id
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// verifyCertificate checks that the DER encoded certificate is issued by the CI.
+func (c *Certificates) verifyCertificate(certificate []byte) error {
+	ci, err := x509.ParseCertificate(c.CI)
+	if err != nil {
+		return err
+	}
+	parsed, err := x509.ParseCertificate(certificate)
+	if err != nil {
+		return err
+	}
+	return parsed.CheckSignatureFrom(ci)
+}
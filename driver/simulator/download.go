@@ -0,0 +1,459 @@
+package simulator
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/asn1"
+	"errors"
+	"slices"
+
+	"github.com/damonto/euicc-go/bertlv"
+	sgp22 "github.com/damonto/euicc-go/v2"
+)
+
+// session is the RSP session opened by AuthenticateServer.
+type session struct {
+	transactionID []byte
+	serverAddress string
+	certificate   []byte
+	signature1    *bertlv.TLV
+	signature2    *bertlv.TLV
+	dpCertificate []byte
+	privateKey    *ecdh.PrivateKey
+}
+
+// boundProfilePackage is the state of a bound profile package being loaded segment by segment.
+type boundProfilePackage struct {
+	remaining int
+	channel   *scp03t
+	command   byte
+	aid       sgp22.ISDPAID
+	metadata  bytes.Buffer
+	profile   *Profile
+}
+
+// BPP command identifiers and error reasons.
+//
+// See https://aka.pw/sgp22/v2.5#page=35 (Section 2.5.6, ProfileInstallationResult)
+const (
+	bppInitialiseSecureChannel = 0
+	bppConfigureISDP           = 1
+	bppStoreMetadata           = 2
+	bppReplaceSessionKeys      = 4
+	bppLoadProfileElements     = 5
+
+	reasonIncorrectInputValues       = 1
+	reasonInvalidSignature           = 2
+	reasonInvalidTransactionID       = 3
+	reasonUnsupportedCRTValues       = 4
+	reasonUnsupportedRemoteOperation = 5
+	reasonSCP03tStructureError       = 7
+	reasonSCP03tSecurityError        = 8
+	reasonICCIDAlreadyExists         = 9
+)
+
+// region ES10b
+
+func (s *Simulator) authenticateServer(request *bertlv.TLV) (*bertlv.TLV, error) {
+	if len(request.Children) < 5 {
+		return nil, errors.New("malformed AuthenticateServerRequest")
+	}
+	signed1 := request.First(bertlv.Universal.Constructed(16))
+	signature1 := request.First(bertlv.Application.Primitive(55))
+	serverCertificate := request.At(3)
+	ctxParams1 := request.First(bertlv.ContextSpecific.Constructed(0))
+	if signed1 == nil || signature1 == nil || serverCertificate == nil || ctxParams1 == nil {
+		return nil, errors.New("malformed AuthenticateServerRequest")
+	}
+	transactionID := signed1.First(bertlv.ContextSpecific.Primitive(0))
+	if transactionID == nil {
+		return nil, errors.New("malformed serverSigned1")
+	}
+	fail := func(code int64) (*bertlv.TLV, error) {
+		return bertlv.NewChildren(request.Tag, bertlv.NewChildren(
+			bertlv.ContextSpecific.Constructed(1),
+			bertlv.NewValue(bertlv.ContextSpecific.Primitive(0), transactionID.Value),
+			integer(bertlv.Universal.Primitive(2), code),
+		)), nil
+	}
+	if err := s.certificates.verifyCertificate(serverCertificate.Bytes()); err != nil {
+		return fail(1)
+	}
+	if err := verify(serverCertificate.Bytes(), signed1.Bytes(), signature1.Value); err != nil {
+		return fail(2)
+	}
+	if challenge := signed1.First(bertlv.ContextSpecific.Primitive(1)); challenge == nil || len(s.challenge) == 0 || !bytes.Equal(challenge.Value, s.challenge) {
+		return fail(6)
+	}
+	s.challenge = nil
+	serverAddress := signed1.First(bertlv.ContextSpecific.Primitive(3))
+	serverChallenge := signed1.First(bertlv.ContextSpecific.Primitive(4))
+	if serverAddress == nil || serverChallenge == nil {
+		return fail(127)
+	}
+	info2, err := s.euiccInfo2()
+	if err != nil {
+		return nil, err
+	}
+	euiccSigned1 := bertlv.NewChildren(
+		bertlv.Universal.Constructed(16),
+		transactionID,
+		serverAddress,
+		serverChallenge,
+		info2,
+		ctxParams1,
+	)
+	signature, err := s.certificates.sign(euiccSigned1.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	s.session = &session{
+		transactionID: transactionID.Value,
+		serverAddress: string(serverAddress.Value),
+		certificate:   serverCertificate.Bytes(),
+		signature1:    bertlv.NewValue(bertlv.Application.Primitive(55), signature),
+	}
+	s.bpp = nil
+	return bertlv.NewChildren(request.Tag, bertlv.NewChildren(
+		bertlv.ContextSpecific.Constructed(0),
+		euiccSigned1,
+		s.session.signature1,
+		certificate(s.certificates.EUICC),
+		certificate(s.certificates.EUM),
+	)), nil
+}
+
+func (s *Simulator) prepareDownload(request *bertlv.TLV) (*bertlv.TLV, error) {
+	if len(request.Children) < 3 {
+		return nil, errors.New("malformed PrepareDownloadRequest")
+	}
+	signed2 := request.First(bertlv.Universal.Constructed(16))
+	signature2 := request.First(bertlv.Application.Primitive(55))
+	dpCertificate := request.At(len(request.Children) - 1)
+	if signed2 == nil || signature2 == nil || dpCertificate == nil {
+		return nil, errors.New("malformed PrepareDownloadRequest")
+	}
+	var transactionID []byte
+	if tlv := signed2.First(bertlv.ContextSpecific.Primitive(0)); tlv != nil {
+		transactionID = tlv.Value
+	}
+	fail := func(code int64) (*bertlv.TLV, error) {
+		return bertlv.NewChildren(request.Tag, bertlv.NewChildren(
+			bertlv.ContextSpecific.Constructed(1),
+			bertlv.NewValue(bertlv.ContextSpecific.Primitive(0), transactionID),
+			integer(bertlv.Universal.Primitive(2), code),
+		)), nil
+	}
+	if s.session == nil {
+		return fail(4)
+	}
+	if !bytes.Equal(transactionID, s.session.transactionID) {
+		return fail(5)
+	}
+	if err := s.certificates.verifyCertificate(dpCertificate.Bytes()); err != nil {
+		return fail(1)
+	}
+	if err := verify(dpCertificate.Bytes(), slices.Concat(signed2.Bytes(), s.session.signature1.Bytes()), signature2.Value); err != nil {
+		return fail(2)
+	}
+	var err error
+	if s.session.privateKey, err = ecdh.P256().GenerateKey(rand.Reader); err != nil {
+		return nil, err
+	}
+	s.session.dpCertificate = dpCertificate.Bytes()
+	euiccSigned2 := bertlv.NewChildren(
+		bertlv.Universal.Constructed(16),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(0), transactionID),
+		bertlv.NewValue(bertlv.Application.Primitive(73), s.session.privateKey.PublicKey().Bytes()),
+	)
+	if hashCc := request.First(bertlv.Universal.Primitive(4)); hashCc != nil {
+		euiccSigned2.Children = append(euiccSigned2.Children, hashCc)
+	}
+	signature, err := s.certificates.sign(slices.Concat(euiccSigned2.Bytes(), signature2.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	s.session.signature2 = bertlv.NewValue(bertlv.Application.Primitive(55), signature)
+	return bertlv.NewChildren(request.Tag, bertlv.NewChildren(
+		bertlv.ContextSpecific.Constructed(0),
+		euiccSigned2,
+		s.session.signature2,
+	)), nil
+}
+
+func (s *Simulator) cancelSession(request *bertlv.TLV) (*bertlv.TLV, error) {
+	transactionID := request.First(bertlv.ContextSpecific.Primitive(0))
+	reason := request.First(bertlv.ContextSpecific.Primitive(1))
+	if s.session == nil || transactionID == nil || !bytes.Equal(transactionID.Value, s.session.transactionID) {
+		return bertlv.NewChildren(request.Tag, integer(bertlv.ContextSpecific.Primitive(1), 5)), nil
+	}
+	if reason == nil {
+		return bertlv.NewChildren(request.Tag, integer(bertlv.ContextSpecific.Primitive(1), 127)), nil
+	}
+	signed := bertlv.NewChildren(
+		bertlv.Universal.Constructed(16),
+		transactionID,
+		s.session.oid(),
+		reason,
+	)
+	signature, err := s.certificates.sign(signed.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	s.session, s.bpp = nil, nil
+	return bertlv.NewChildren(request.Tag, bertlv.NewChildren(
+		bertlv.ContextSpecific.Constructed(0),
+		signed,
+		bertlv.NewValue(bertlv.Application.Primitive(55), signature),
+	)), nil
+}
+
+// endregion
+
+// region Section 5.7.6, ES10b.LoadBoundProfilePackage
+
+// loadBoundProfilePackage processes one segment of a bound profile package.
+// It returns an empty response until the last segment or the first error,
+// then the ProfileInstallationResult.
+//
+// See https://aka.pw/sgp22/v2.5#page=186 (Section 5.7.6, ES10b.LoadBoundProfilePackage)
+func (s *Simulator) loadBoundProfilePackage(segment []byte) ([]byte, error) {
+	if bytes.HasPrefix(segment, []byte{0xBF, 0x36}) {
+		_, length, n, err := readHeader(segment)
+		if err != nil {
+			return nil, err
+		}
+		s.bpp = &boundProfilePackage{remaining: length, command: bppInitialiseSecureChannel}
+		segment = segment[n:]
+	}
+	b := s.bpp
+	b.remaining -= len(segment)
+	if reason := s.loadSegment(b, segment); reason != 0 {
+		return s.installationResult(bertlv.NewChildren(
+			bertlv.ContextSpecific.Constructed(1),
+			integer(bertlv.ContextSpecific.Primitive(0), int64(b.command)),
+			integer(bertlv.ContextSpecific.Primitive(1), int64(reason)),
+		))
+	}
+	if b.remaining > 0 {
+		return nil, nil
+	}
+	if b.profile == nil {
+		return s.installationResult(bertlv.NewChildren(
+			bertlv.ContextSpecific.Constructed(1),
+			integer(bertlv.ContextSpecific.Primitive(0), int64(b.command)),
+			integer(bertlv.ContextSpecific.Primitive(1), reasonSCP03tStructureError),
+		))
+	}
+	b.profile.ISDPAID = b.aid
+	b.profile.State = sgp22.ProfileDisabled
+	s.profiles = append(s.profiles, b.profile)
+	return s.installationResult(bertlv.NewChildren(
+		bertlv.ContextSpecific.Constructed(0),
+		bertlv.NewValue(bertlv.Application.Primitive(15), b.aid),
+		bertlv.NewValue(bertlv.Universal.Primitive(4), nil),
+	))
+}
+
+// loadSegment processes a segment and returns the error reason, or 0 on success.
+func (s *Simulator) loadSegment(b *boundProfilePackage, segment []byte) int {
+	if len(segment) == 0 {
+		return reasonSCP03tStructureError
+	}
+	if b.channel == nil {
+		var tlv bertlv.TLV
+		if err := tlv.UnmarshalBinary(segment); err != nil {
+			return reasonSCP03tStructureError
+		}
+		return s.initialiseSecureChannel(b, &tlv)
+	}
+	switch segment[0] {
+	case 0xA0, 0xA2:
+		b.command = bppConfigureISDP
+		if segment[0] == 0xA2 {
+			if reason := s.storeMetadata(b); reason != 0 {
+				return reason
+			}
+			b.command = bppReplaceSessionKeys
+		}
+		var tlv bertlv.TLV
+		if err := tlv.UnmarshalBinary(segment); err != nil || len(tlv.Children) == 0 {
+			return reasonSCP03tStructureError
+		}
+		for _, child := range tlv.Children {
+			value, err := b.channel.unwrap(child.Bytes(), true)
+			if err != nil {
+				return reasonSCP03tSecurityError
+			}
+			if reason := s.secured(b, segment[0], value); reason != 0 {
+				return reason
+			}
+		}
+	case 0xA1:
+		b.command = bppStoreMetadata
+	case 0x88:
+		value, err := b.channel.unwrap(segment, false)
+		if err != nil {
+			return reasonSCP03tSecurityError
+		}
+		b.metadata.Write(value)
+	case 0xA3:
+		if reason := s.storeMetadata(b); reason != 0 {
+			return reason
+		}
+		b.command = bppLoadProfileElements
+	case 0x86:
+		if b.profile == nil {
+			return reasonSCP03tStructureError
+		}
+		// The profile elements are decrypted to check their integrity but not interpreted.
+		if _, err := b.channel.unwrap(segment, true); err != nil {
+			return reasonSCP03tSecurityError
+		}
+	default:
+		return reasonSCP03tStructureError
+	}
+	return 0
+}
+
+func (s *Simulator) initialiseSecureChannel(b *boundProfilePackage, request *bertlv.TLV) int {
+	if !request.Tag.If(bertlv.ContextSpecific, bertlv.Constructed, 35) {
+		return reasonSCP03tStructureError
+	}
+	remoteOpID := request.First(bertlv.ContextSpecific.Primitive(2))
+	transactionID := request.First(bertlv.ContextSpecific.Primitive(0))
+	template := request.First(bertlv.ContextSpecific.Constructed(6))
+	otpk := request.First(bertlv.Application.Primitive(73))
+	signature := request.First(bertlv.Application.Primitive(55))
+	if remoteOpID == nil || transactionID == nil || template == nil || otpk == nil || signature == nil {
+		return reasonIncorrectInputValues
+	}
+	if !bytes.Equal(remoteOpID.Value, []byte{0x01}) {
+		return reasonUnsupportedRemoteOperation
+	}
+	if s.session == nil || s.session.privateKey == nil || !bytes.Equal(transactionID.Value, s.session.transactionID) {
+		return reasonInvalidTransactionID
+	}
+	signed := slices.Concat(remoteOpID.Bytes(), transactionID.Bytes(), template.Bytes(), otpk.Bytes(), s.session.signature2.Bytes())
+	if err := verify(s.session.dpCertificate, signed, signature.Value); err != nil {
+		return reasonInvalidSignature
+	}
+	keyType := template.First(bertlv.ContextSpecific.Primitive(0))
+	keyLength := template.First(bertlv.ContextSpecific.Primitive(1))
+	hostID := template.First(bertlv.ContextSpecific.Primitive(4))
+	if keyType == nil || keyLength == nil || hostID == nil ||
+		!bytes.Equal(keyType.Value, []byte{0x88}) || !bytes.Equal(keyLength.Value, []byte{0x10}) {
+		return reasonUnsupportedCRTValues
+	}
+	publicKey, err := ecdh.P256().NewPublicKey(otpk.Value)
+	if err != nil {
+		return reasonIncorrectInputValues
+	}
+	secret, err := s.session.privateKey.ECDH(publicKey)
+	if err != nil {
+		return reasonIncorrectInputValues
+	}
+	b.channel = newSCP03t(secret, 0x88, 0x10, hostID.Value, s.eid)
+	return 0
+}
+
+// secured handles the plain value of an '87' TLV.
+func (s *Simulator) secured(b *boundProfilePackage, sequence byte, value []byte) int {
+	var tlv bertlv.TLV
+	if err := tlv.UnmarshalBinary(value); err != nil {
+		return reasonIncorrectInputValues
+	}
+	switch {
+	case sequence == 0xA0 && tlv.Tag.If(bertlv.ContextSpecific, bertlv.Constructed, 36):
+		b.aid = s.nextISDPAID()
+	case sequence == 0xA2 && tlv.Tag.If(bertlv.ContextSpecific, bertlv.Constructed, 38):
+		chain := tlv.First(bertlv.ContextSpecific.Primitive(0))
+		enc := tlv.First(bertlv.ContextSpecific.Primitive(1))
+		mac := tlv.First(bertlv.ContextSpecific.Primitive(2))
+		if chain == nil || enc == nil || mac == nil {
+			return reasonIncorrectInputValues
+		}
+		b.channel.replaceKeys(chain.Value, enc.Value, mac.Value)
+	default:
+		return reasonIncorrectInputValues
+	}
+	return 0
+}
+
+// storeMetadata parses the StoreMetadataRequest collected from the '88' TLVs.
+func (s *Simulator) storeMetadata(b *boundProfilePackage) int {
+	if b.profile != nil {
+		return 0
+	}
+	b.command = bppStoreMetadata
+	if b.aid == nil {
+		return reasonSCP03tStructureError
+	}
+	var tlv bertlv.TLV
+	if err := tlv.UnmarshalBinary(b.metadata.Bytes()); err != nil {
+		return reasonIncorrectInputValues
+	}
+	b.profile = new(Profile)
+	if err := b.profile.unmarshalMetadata(&tlv); err != nil || len(b.profile.ICCID) == 0 {
+		b.profile = nil
+		return reasonIncorrectInputValues
+	}
+	if s.profile(b.profile.ICCID) != nil {
+		return reasonICCIDAlreadyExists
+	}
+	return 0
+}
+
+// installationResult ends the download, records the install notification
+// and returns the signed ProfileInstallationResult.
+func (s *Simulator) installationResult(final *bertlv.TLV) ([]byte, error) {
+	var profile Profile
+	if s.bpp.profile != nil {
+		profile = *s.bpp.profile
+	}
+	var transactionID []byte
+	var address string
+	if s.session != nil {
+		transactionID = s.session.transactionID
+		address = s.session.serverAddress
+	}
+	n, err := s.addNotification(&profile, sgp22.NotificationEventInstall, address)
+	if err != nil {
+		return nil, err
+	}
+	data := bertlv.NewChildren(
+		bertlv.ContextSpecific.Constructed(39),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(0), transactionID),
+		marshalNotificationMetadata(&n.metadata),
+		s.session.oid(),
+		bertlv.NewChildren(bertlv.ContextSpecific.Constructed(2), final),
+	)
+	signature, err := s.certificates.sign(data.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	n.pending = bertlv.NewChildren(
+		bertlv.ContextSpecific.Constructed(55),
+		data,
+		bertlv.NewValue(bertlv.Application.Primitive(55), signature),
+	)
+	s.session, s.bpp = nil, nil
+	return n.pending.MarshalBinary()
+}
+
+// endregion
+
+// oid returns the SM-DP+ OID from its certificate, or 2.999 if it is not known.
+func (s *session) oid() *bertlv.TLV {
+	id := asn1.ObjectIdentifier{2, 999}
+	if s != nil {
+		if registered := oid(s.certificate); registered != nil {
+			id = registered
+		}
+	}
+	der, _ := asn1.Marshal(id)
+	var tlv bertlv.TLV
+	_ = tlv.UnmarshalBinary(der)
+	return &tlv
+}
@@ -0,0 +1,394 @@
+package simulator
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"slices"
+
+	"github.com/damonto/euicc-go/bertlv"
+	"github.com/damonto/euicc-go/bertlv/primitive"
+	sgp22 "github.com/damonto/euicc-go/v2"
+)
+
+// dispatch routes an ES10 request to its handler by tag.
+func (s *Simulator) dispatch(request *bertlv.TLV) (*bertlv.TLV, error) {
+	if !request.Tag.ContextSpecific() || !request.Tag.Constructed() {
+		return nil, sgp22.ErrUnexpectedTag
+	}
+	switch request.Tag.Value() {
+	case 32:
+		return s.euiccInfo1()
+	case 33:
+		return s.prepareDownload(request)
+	case 34:
+		return s.euiccInfo2()
+	case 40:
+		return s.listNotification(request)
+	case 41:
+		return s.setNickname(request)
+	case 43:
+		return s.retrieveNotificationsList(request)
+	case 45:
+		return s.profilesInfo(request)
+	case 46:
+		return s.euiccChallenge()
+	case 48:
+		return s.removeNotification(request)
+	case 49:
+		return s.enableProfile(request)
+	case 50:
+		return s.disableProfile(request)
+	case 51:
+		return s.deleteProfile(request)
+	case 52:
+		return s.memoryReset(request)
+	case 56:
+		return s.authenticateServer(request)
+	case 60:
+		return s.configuredAddresses()
+	case 62:
+		return s.euiccData()
+	case 63:
+		return s.setDefaultDPAddress(request)
+	case 65:
+		return s.cancelSession(request)
+	}
+	return nil, fmt.Errorf("unsupported request %s", request.Tag.String())
+}
+
+// region ES10a
+
+func (s *Simulator) configuredAddresses() (*bertlv.TLV, error) {
+	response := bertlv.NewChildren(bertlv.ContextSpecific.Constructed(60))
+	if s.defaultSMDPAddress != "" {
+		response.Children = append(response.Children, bertlv.NewValue(bertlv.ContextSpecific.Primitive(0), []byte(s.defaultSMDPAddress)))
+	}
+	response.Children = append(response.Children, bertlv.NewValue(bertlv.ContextSpecific.Primitive(1), []byte(s.rootSMDSAddress)))
+	return response, nil
+}
+
+func (s *Simulator) setDefaultDPAddress(request *bertlv.TLV) (*bertlv.TLV, error) {
+	address := request.First(bertlv.ContextSpecific.Primitive(0))
+	if address == nil {
+		return result(request.Tag, 127), nil
+	}
+	s.defaultSMDPAddress = string(address.Value)
+	return result(request.Tag, 0), nil
+}
+
+// endregion
+
+// region ES10b
+
+func (s *Simulator) euiccChallenge() (*bertlv.TLV, error) {
+	s.challenge = make([]byte, 16)
+	if _, err := rand.Read(s.challenge); err != nil {
+		return nil, err
+	}
+	return bertlv.NewChildren(
+		bertlv.ContextSpecific.Constructed(46),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(0), s.challenge),
+	), nil
+}
+
+func (s *Simulator) ciPKIDList(tag bertlv.Tag) (*bertlv.TLV, error) {
+	id, err := s.certificates.CIPKID()
+	if err != nil {
+		return nil, err
+	}
+	return bertlv.NewChildren(tag, bertlv.NewValue(bertlv.Universal.Primitive(4), id)), nil
+}
+
+func (s *Simulator) euiccInfo1() (*bertlv.TLV, error) {
+	verification, err := s.ciPKIDList(bertlv.ContextSpecific.Constructed(9))
+	if err != nil {
+		return nil, err
+	}
+	signing, _ := s.ciPKIDList(bertlv.ContextSpecific.Constructed(10))
+	return bertlv.NewChildren(
+		bertlv.ContextSpecific.Constructed(32),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(2), []byte{0x02, 0x02, 0x00}),
+		verification,
+		signing,
+	), nil
+}
+
+func (s *Simulator) euiccInfo2() (*bertlv.TLV, error) {
+	verification, err := s.ciPKIDList(bertlv.ContextSpecific.Constructed(9))
+	if err != nil {
+		return nil, err
+	}
+	signing, _ := s.ciPKIDList(bertlv.ContextSpecific.Constructed(10))
+	bits := func(tag bertlv.Tag, flags ...bool) *bertlv.TLV {
+		tlv, _ := bertlv.MarshalValue(tag, primitive.MarshalBitString(flags))
+		return tlv
+	}
+	return bertlv.NewChildren(
+		bertlv.ContextSpecific.Constructed(34),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(1), []byte{0x02, 0x03, 0x00}),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(2), []byte{0x02, 0x02, 0x00}),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(3), []byte{0x01, 0x00, 0x00}),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(4), []byte{0x81, 0x01, 0x00, 0x82, 0x04, 0x00, 0x01, 0x00, 0x00, 0x83, 0x02, 0x22, 0x00}),
+		bits(bertlv.ContextSpecific.Primitive(5), true, true, true, true, true, true, true, true, true, true),
+		bits(bertlv.ContextSpecific.Primitive(8), true, false, false, true),
+		verification,
+		signing,
+		bits(bertlv.Universal.Primitive(4), false, false, false),
+		bertlv.NewValue(bertlv.Universal.Primitive(12), []byte("euicc-go simulator")),
+	), nil
+}
+
+func (s *Simulator) listNotification(request *bertlv.TLV) (*bertlv.TLV, error) {
+	var filter []bool
+	if tlv := request.First(bertlv.ContextSpecific.Primitive(1)); tlv != nil {
+		if err := tlv.UnmarshalValue(primitive.UnmarshalBitString(&filter)); err != nil {
+			return nil, err
+		}
+	}
+	list := bertlv.NewChildren(bertlv.ContextSpecific.Constructed(0))
+	for _, n := range s.notifications {
+		event := int(n.metadata.ProfileManagementOperation)
+		if filter != nil && (event >= len(filter) || !filter[event]) {
+			continue
+		}
+		list.Children = append(list.Children, marshalNotificationMetadata(&n.metadata))
+	}
+	return bertlv.NewChildren(bertlv.ContextSpecific.Constructed(40), list), nil
+}
+
+func (s *Simulator) retrieveNotificationsList(request *bertlv.TLV) (*bertlv.TLV, error) {
+	match := func(*notification) bool { return true }
+	if criteria := request.First(bertlv.ContextSpecific.Constructed(0)); criteria != nil && len(criteria.Children) > 0 {
+		switch criterion := criteria.At(0); {
+		case criterion.Tag.If(bertlv.ContextSpecific, bertlv.Primitive, 0):
+			var sequenceNumber sgp22.SequenceNumber
+			if err := criterion.UnmarshalValue(primitive.UnmarshalInt(&sequenceNumber)); err != nil {
+				return nil, err
+			}
+			match = func(n *notification) bool { return n.metadata.SequenceNumber == sequenceNumber }
+		case criterion.Tag.If(bertlv.ContextSpecific, bertlv.Primitive, 1):
+			var event sgp22.NotificationEvent
+			if err := criterion.UnmarshalValue(&event); err != nil {
+				return nil, err
+			}
+			match = func(n *notification) bool { return n.metadata.ProfileManagementOperation == event }
+		}
+	}
+	list := bertlv.NewChildren(bertlv.ContextSpecific.Constructed(0))
+	for _, n := range s.notifications {
+		if n.pending != nil && match(n) {
+			list.Children = append(list.Children, n.pending)
+		}
+	}
+	return bertlv.NewChildren(bertlv.ContextSpecific.Constructed(43), list), nil
+}
+
+func (s *Simulator) removeNotification(request *bertlv.TLV) (*bertlv.TLV, error) {
+	tlv := request.First(bertlv.ContextSpecific.Primitive(0))
+	if tlv == nil {
+		return result(request.Tag, 127), nil
+	}
+	var sequenceNumber sgp22.SequenceNumber
+	if err := tlv.UnmarshalValue(primitive.UnmarshalInt(&sequenceNumber)); err != nil {
+		return nil, err
+	}
+	index := slices.IndexFunc(s.notifications, func(n *notification) bool {
+		return n.metadata.SequenceNumber == sequenceNumber
+	})
+	if index == -1 {
+		return result(request.Tag, 1), nil
+	}
+	s.notifications = slices.Delete(s.notifications, index, index+1)
+	return result(request.Tag, 0), nil
+}
+
+// endregion
+
+// region ES10c
+
+func (s *Simulator) profilesInfo(request *bertlv.TLV) (*bertlv.TLV, error) {
+	profiles := s.profiles
+	if criteria := request.First(bertlv.ContextSpecific.Constructed(0)); criteria != nil && len(criteria.Children) > 0 {
+		criterion := criteria.At(0)
+		profiles = slices.DeleteFunc(slices.Clone(profiles), func(profile *Profile) bool {
+			switch {
+			case criterion.Tag.Equal(sgp22.TagICCID):
+				return !bytes.Equal(profile.ICCID, criterion.Value)
+			case criterion.Tag.Equal(sgp22.TagISDPAID):
+				return !bytes.Equal(profile.ISDPAID, criterion.Value)
+			case criterion.Tag.Equal(sgp22.TagProfileClass):
+				var class sgp22.ProfileClass
+				_ = criterion.UnmarshalValue(primitive.UnmarshalInt(&class))
+				return profile.Class != class
+			}
+			return true
+		})
+	}
+	var tags []bertlv.Tag
+	if tagList := request.First(bertlv.Application.Primitive(28)); tagList != nil {
+		reader := bytes.NewReader(tagList.Value)
+		for reader.Len() > 0 {
+			var tag bertlv.Tag
+			if _, err := tag.ReadFrom(reader); err != nil {
+				return bertlv.NewChildren(request.Tag, integer(bertlv.ContextSpecific.Primitive(1), 1)), nil
+			}
+			tags = append(tags, tag)
+		}
+	}
+	list := bertlv.NewChildren(bertlv.ContextSpecific.Constructed(0))
+	for _, profile := range profiles {
+		list.Children = append(list.Children, profile.profileInfo(tags))
+	}
+	return bertlv.NewChildren(request.Tag, list), nil
+}
+
+// identifier extracts the ICCID or ISD-P AID used by the profile operations.
+func identifier(tlv *bertlv.TLV) []byte {
+	if tlv == nil {
+		return nil
+	}
+	if child := tlv.First(sgp22.TagICCID); child != nil {
+		return child.Value
+	}
+	if child := tlv.First(sgp22.TagISDPAID); child != nil {
+		return child.Value
+	}
+	return nil
+}
+
+func (s *Simulator) enableProfile(request *bertlv.TLV) (*bertlv.TLV, error) {
+	profile := s.profile(identifier(request.First(bertlv.ContextSpecific.Constructed(0))))
+	if profile == nil {
+		return result(request.Tag, 1), nil
+	}
+	if profile.State == sgp22.ProfileEnabled {
+		return result(request.Tag, 2), nil
+	}
+	for _, enabled := range s.profiles {
+		if enabled.State != sgp22.ProfileEnabled {
+			continue
+		}
+		if err := s.setState(enabled, sgp22.ProfileDisabled); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.setState(profile, sgp22.ProfileEnabled); err != nil {
+		return nil, err
+	}
+	s.refresh = refreshFlag(request)
+	return result(request.Tag, 0), nil
+}
+
+func (s *Simulator) disableProfile(request *bertlv.TLV) (*bertlv.TLV, error) {
+	profile := s.profile(identifier(request.First(bertlv.ContextSpecific.Constructed(0))))
+	if profile == nil {
+		return result(request.Tag, 1), nil
+	}
+	if profile.State != sgp22.ProfileEnabled {
+		return result(request.Tag, 2), nil
+	}
+	if err := s.setState(profile, sgp22.ProfileDisabled); err != nil {
+		return nil, err
+	}
+	s.refresh = refreshFlag(request)
+	return result(request.Tag, 0), nil
+}
+
+// refreshFlag reports whether the profile operation asks for a refresh of the card.
+func refreshFlag(request *bertlv.TLV) bool {
+	flag := request.First(bertlv.ContextSpecific.Primitive(1))
+	return flag != nil && len(flag.Value) > 0 && flag.Value[0] != 0
+}
+
+func (s *Simulator) deleteProfile(request *bertlv.TLV) (*bertlv.TLV, error) {
+	profile := s.profile(identifier(request))
+	if profile == nil {
+		return result(request.Tag, 1), nil
+	}
+	if profile.State == sgp22.ProfileEnabled {
+		return result(request.Tag, 2), nil
+	}
+	if address := profile.notificationAddress(sgp22.NotificationEventDelete); address != "" {
+		if _, err := s.addNotification(profile, sgp22.NotificationEventDelete, address); err != nil {
+			return nil, err
+		}
+	}
+	s.profiles = slices.DeleteFunc(s.profiles, func(p *Profile) bool { return p == profile })
+	return result(request.Tag, 0), nil
+}
+
+// setState changes the profile state and records the notification configured for it.
+func (s *Simulator) setState(profile *Profile, state sgp22.ProfileState) error {
+	profile.State = state
+	event := sgp22.NotificationEventDisable
+	if state == sgp22.ProfileEnabled {
+		event = sgp22.NotificationEventEnable
+	}
+	if address := profile.notificationAddress(event); address != "" {
+		_, err := s.addNotification(profile, event, address)
+		return err
+	}
+	return nil
+}
+
+func (s *Simulator) memoryReset(request *bertlv.TLV) (*bertlv.TLV, error) {
+	// The reset options are [2] BIT STRING, some LPAs encode them as [APPLICATION 2].
+	tlv := request.First(bertlv.ContextSpecific.Primitive(2))
+	if tlv == nil {
+		tlv = request.First(bertlv.Application.Primitive(2))
+	}
+	if tlv == nil {
+		return result(request.Tag, 127), nil
+	}
+	var options []bool
+	if err := tlv.UnmarshalValue(primitive.UnmarshalBitString(&options)); err != nil {
+		return nil, err
+	}
+	options = append(options, make([]bool, 3)...)
+	var changed bool
+	s.profiles = slices.DeleteFunc(s.profiles, func(profile *Profile) bool {
+		remove := (options[0] && profile.Class == sgp22.ProfileClassOperational) ||
+			(options[1] && profile.Class == sgp22.ProfileClassTest)
+		changed = changed || remove
+		return remove
+	})
+	if options[2] && s.defaultSMDPAddress != "" {
+		s.defaultSMDPAddress = ""
+		changed = true
+	}
+	if !changed {
+		return result(request.Tag, 1), nil
+	}
+	return result(request.Tag, 0), nil
+}
+
+func (s *Simulator) euiccData() (*bertlv.TLV, error) {
+	return bertlv.NewChildren(
+		bertlv.ContextSpecific.Constructed(62),
+		bertlv.NewValue(bertlv.Application.Primitive(26), s.eid),
+	), nil
+}
+
+func (s *Simulator) setNickname(request *bertlv.TLV) (*bertlv.TLV, error) {
+	iccid := request.First(sgp22.TagICCID)
+	if iccid == nil {
+		return result(request.Tag, 127), nil
+	}
+	profile := s.profile(iccid.Value)
+	if profile == nil {
+		return result(request.Tag, 1), nil
+	}
+	profile.Nickname = ""
+	if nickname := request.First(sgp22.TagNickname); nickname != nil {
+		profile.Nickname = string(nickname.Value)
+	}
+	return result(request.Tag, 0), nil
+}
+
+// endregion
+
+// result builds the common "[tag] SEQUENCE { result [0] INTEGER }" response.
+func result(tag bertlv.Tag, code int64) *bertlv.TLV {
+	return bertlv.NewChildren(tag, integer(bertlv.ContextSpecific.Primitive(0), code))
+}
@@ -0,0 +1,253 @@
+package simulator
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+
+	"github.com/damonto/euicc-go/bertlv"
+	"github.com/damonto/euicc-go/bertlv/primitive"
+	sgp22 "github.com/damonto/euicc-go/v2"
+)
+
+// Profile is a profile installed on the simulated eUICC.
+type Profile struct {
+	ICCID                         sgp22.ICCID
+	ISDPAID                       sgp22.ISDPAID
+	State                         sgp22.ProfileState
+	Nickname                      string
+	ServiceProviderName           string
+	ProfileName                   string
+	IconType                      byte
+	Icon                          []byte
+	Class                         sgp22.ProfileClass
+	Owner                         sgp22.OperatorId
+	NotificationConfigurationInfo sgp22.NotificationConfigurationInfo
+}
+
+// notification is a pending notification kept by the simulated eUICC.
+type notification struct {
+	metadata sgp22.NotificationMetadata
+	pending  *bertlv.TLV
+}
+
+type euicc struct {
+	eid                []byte
+	defaultSMDPAddress string
+	rootSMDSAddress    string
+	certificates       *Certificates
+	profiles           []*Profile
+	notifications      []*notification
+	sequenceNumber     sgp22.SequenceNumber
+	challenge          []byte
+	session            *session
+	bpp                *boundProfilePackage
+}
+
+// AddProfile installs a profile without going through a download.
+// A free ISD-P AID is allocated if the profile does not have one.
+func (s *Simulator) AddProfile(profile Profile) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(profile.ICCID) == 0 {
+		return errors.New("profile ICCID is required")
+	}
+	if s.profile(profile.ICCID) != nil {
+		return errors.New("profile already installed")
+	}
+	if profile.ISDPAID == nil {
+		profile.ISDPAID = s.nextISDPAID()
+	}
+	if profile.State == sgp22.ProfileEnabled {
+		for _, p := range s.profiles {
+			p.State = sgp22.ProfileDisabled
+		}
+	}
+	s.profiles = append(s.profiles, &profile)
+	return nil
+}
+
+// Profiles returns a copy of the installed profiles.
+func (s *Simulator) Profiles() []Profile {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	profiles := make([]Profile, 0, len(s.profiles))
+	for _, profile := range s.profiles {
+		profiles = append(profiles, *profile)
+	}
+	return profiles
+}
+
+// Certificates returns the certificate chain of the simulator, e.g. to create an SMDP trusted by it.
+func (s *Simulator) Certificates() *Certificates {
+	return s.certificates
+}
+
+// Notifications returns the metadata of the pending notifications.
+func (s *Simulator) Notifications() []sgp22.NotificationMetadata {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	notifications := make([]sgp22.NotificationMetadata, 0, len(s.notifications))
+	for _, n := range s.notifications {
+		notifications = append(notifications, n.metadata)
+	}
+	return notifications
+}
+
+func (e *euicc) profile(identifier []byte) *Profile {
+	for _, profile := range e.profiles {
+		if bytes.Equal(profile.ICCID, identifier) || bytes.Equal(profile.ISDPAID, identifier) {
+			return profile
+		}
+	}
+	return nil
+}
+
+func (e *euicc) nextISDPAID() sgp22.ISDPAID {
+	for index := byte(0x10); ; index++ {
+		aid := sgp22.ISDPAID{0xA0, 0x00, 0x00, 0x05, 0x59, 0x10, 0x10, 0xFF, 0xFF, 0xFF, 0xFF, 0x89, 0x00, 0x00, index, 0x00}
+		if e.profile(aid) == nil {
+			return aid
+		}
+	}
+}
+
+// notificationAddress returns the address configured for event, or "" if the profile has none.
+func (p *Profile) notificationAddress(event sgp22.NotificationEvent) string {
+	for _, configuration := range p.NotificationConfigurationInfo {
+		if configuration.ProfileManagementOperation == event {
+			return configuration.Address
+		}
+	}
+	return ""
+}
+
+// profileInfo encodes the profile as ProfileInfo, keeping only the requested tags.
+func (p *Profile) profileInfo(tags []bertlv.Tag) *bertlv.TLV {
+	children := []*bertlv.TLV{
+		bertlv.NewValue(sgp22.TagICCID, p.ICCID),
+		bertlv.NewValue(sgp22.TagISDPAID, p.ISDPAID),
+		integer(sgp22.TagProfileState, int64(p.State)),
+		bertlv.NewValue(sgp22.TagNickname, []byte(p.Nickname)),
+		bertlv.NewValue(sgp22.TagServiceProviderName, []byte(p.ServiceProviderName)),
+		bertlv.NewValue(sgp22.TagProfileName, []byte(p.ProfileName)),
+	}
+	if len(p.Icon) > 0 {
+		children = append(children,
+			integer(sgp22.TagProfileIconType, int64(p.IconType)),
+			bertlv.NewValue(sgp22.TagProfileIcon, p.Icon),
+		)
+	}
+	children = append(children, integer(sgp22.TagProfileClass, int64(p.Class)))
+	if len(p.NotificationConfigurationInfo) > 0 {
+		configurations := bertlv.NewChildren(sgp22.TagNotificationConfigurationInfo)
+		for _, configuration := range p.NotificationConfigurationInfo {
+			configurations.Children = append(configurations.Children, bertlv.NewChildren(
+				bertlv.Universal.Constructed(16),
+				event(bertlv.ContextSpecific.Primitive(0), configuration.ProfileManagementOperation),
+				bertlv.NewValue(bertlv.ContextSpecific.Primitive(1), []byte(configuration.Address)),
+			))
+		}
+		children = append(children, configurations)
+	}
+	if len(p.Owner.PLMN) > 0 {
+		owner := bertlv.NewChildren(sgp22.TagProfileOwner, bertlv.NewValue(bertlv.ContextSpecific.Primitive(0), p.Owner.PLMN))
+		if len(p.Owner.GID1) > 0 {
+			owner.Children = append(owner.Children, bertlv.NewValue(bertlv.ContextSpecific.Primitive(1), p.Owner.GID1))
+		}
+		if len(p.Owner.GID2) > 0 {
+			owner.Children = append(owner.Children, bertlv.NewValue(bertlv.ContextSpecific.Primitive(2), p.Owner.GID2))
+		}
+		children = append(children, owner)
+	}
+	if len(tags) > 0 {
+		children = slices.DeleteFunc(children, func(child *bertlv.TLV) bool {
+			return !slices.ContainsFunc(tags, func(tag bertlv.Tag) bool { return tag.Equal(child.Tag) })
+		})
+	}
+	return bertlv.NewChildren(bertlv.Private.Constructed(3), children...)
+}
+
+// unmarshalMetadata reads the StoreMetadataRequest of a bound profile package.
+func (p *Profile) unmarshalMetadata(tlv *bertlv.TLV) error {
+	if !tlv.Tag.If(bertlv.ContextSpecific, bertlv.Constructed, 37) {
+		return sgp22.ErrUnexpectedTag
+	}
+	var info sgp22.ProfileInfo
+	if err := info.UnmarshalBERTLV(tlv); err != nil {
+		return err
+	}
+	*p = Profile{
+		ICCID:                         info.ICCID,
+		ServiceProviderName:           info.ServiceProviderName,
+		ProfileName:                   info.ProfileName,
+		Icon:                          info.Icon,
+		Class:                         sgp22.ProfileClassOperational,
+		Owner:                         info.ProfileOwner,
+		NotificationConfigurationInfo: info.NotificationConfigurationInfo,
+	}
+	if iconType := tlv.First(sgp22.TagProfileIconType); iconType != nil && len(iconType.Value) > 0 {
+		p.IconType = iconType.Value[0]
+	}
+	if tlv.First(sgp22.TagProfileClass) != nil {
+		p.Class = info.ProfileClass
+	}
+	return nil
+}
+
+// addNotification records a notification for the profile and returns its metadata.
+func (e *euicc) addNotification(profile *Profile, event sgp22.NotificationEvent, address string) (*notification, error) {
+	e.sequenceNumber++
+	n := &notification{metadata: sgp22.NotificationMetadata{
+		SequenceNumber:             e.sequenceNumber,
+		ProfileManagementOperation: event,
+		Address:                    address,
+		ICCID:                      profile.ICCID,
+	}}
+	if event == sgp22.NotificationEventInstall {
+		e.notifications = append(e.notifications, n)
+		return n, nil
+	}
+	metadata := marshalNotificationMetadata(&n.metadata)
+	signature, err := e.certificates.sign(metadata.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	n.pending = bertlv.NewChildren(
+		bertlv.Universal.Constructed(16),
+		metadata,
+		bertlv.NewValue(bertlv.Application.Primitive(55), signature),
+		certificate(e.certificates.EUICC),
+		certificate(e.certificates.EUM),
+	)
+	e.notifications = append(e.notifications, n)
+	return n, nil
+}
+
+// marshalNotificationMetadata encodes the NotificationMetadata.
+func marshalNotificationMetadata(n *sgp22.NotificationMetadata) *bertlv.TLV {
+	return bertlv.NewChildren(
+		bertlv.ContextSpecific.Constructed(47),
+		integer(bertlv.ContextSpecific.Primitive(0), int64(n.SequenceNumber)),
+		event(bertlv.ContextSpecific.Primitive(1), n.ProfileManagementOperation),
+		bertlv.NewValue(bertlv.Universal.Primitive(12), []byte(n.Address)),
+		bertlv.NewValue(bertlv.Application.Primitive(26), n.ICCID),
+	)
+}
+
+func integer(tag bertlv.Tag, value int64) *bertlv.TLV {
+	tlv, _ := bertlv.MarshalValue(tag, primitive.MarshalInt(value))
+	return tlv
+}
+
+func event(tag bertlv.Tag, value sgp22.NotificationEvent) *bertlv.TLV {
+	tlv, _ := bertlv.MarshalValue(tag, &value)
+	return tlv
+}
+
+// certificate wraps a DER encoded certificate into a TLV.
+func certificate(der []byte) *bertlv.TLV {
+	var tlv bertlv.TLV
+	_ = tlv.UnmarshalBinary(der)
+	return &tlv
+}
@@ -0,0 +1,32 @@
+package simulator
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/euicc-go/driver"
+)
+
+// The simulator registers the "simulator" scheme, which opens a new empty in-memory eUICC, e.g.
+// "simulator:?eid=89049032123451234512345678901224&smdp=smdp.example.com".
+func init() {
+	driver.Register("simulator", open)
+}
+
+func open(u *url.URL) (apdu.SmartCardChannel, error) {
+	query := u.Query()
+	opts := &Options{
+		DefaultSMDPAddress: query.Get("smdp"),
+		RootSMDSAddress:    query.Get("smds"),
+	}
+	if value := query.Get("eid"); value != "" {
+		eid, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EID %q: %w", value, err)
+		}
+		opts.EID = eid
+	}
+	return New(opts)
+}
@@ -0,0 +1,193 @@
+package simulator
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"slices"
+
+	"github.com/damonto/euicc-go/bertlv"
+)
+
+// errInvalidMAC is returned when a secured TLV does not carry the expected MAC.
+var errInvalidMAC = errors.New("scp03t: invalid MAC")
+
+// scp03t holds the session keys used to protect the bound profile package.
+//
+// See https://aka.pw/sgp22/v2.5#page=56 (Section 2.6.4, SCP03t)
+type scp03t struct {
+	enc     []byte
+	mac     []byte
+	chain   []byte
+	counter uint32
+}
+
+// newSCP03t derives the session keys from the ECDH shared secret
+// with the X9.63 key derivation function using SHA-256.
+func newSCP03t(secret []byte, keyType, keyLength byte, hostID, eid []byte) *scp03t {
+	info := slices.Concat(
+		[]byte{keyType, keyLength},
+		[]byte{byte(len(hostID))}, hostID,
+		[]byte{byte(len(eid))}, eid,
+	)
+	var keys []byte
+	for counter := uint32(1); len(keys) < 48; counter++ {
+		h := sha256.New()
+		h.Write(secret)
+		_ = binary.Write(h, binary.BigEndian, counter)
+		h.Write(info)
+		keys = h.Sum(keys)
+	}
+	return &scp03t{chain: keys[:16], enc: keys[16:32], mac: keys[32:48]}
+}
+
+// replaceKeys switches to the profile protection keys.
+func (s *scp03t) replaceKeys(chain, enc, mac []byte) {
+	s.chain, s.enc, s.mac, s.counter = chain, enc, mac, 0
+}
+
+// unwrap verifies the MAC of a secured TLV and returns its plain value.
+// The value is decrypted when decrypt is true.
+func (s *scp03t) unwrap(tlv []byte, decrypt bool) ([]byte, error) {
+	header, value, err := splitTLV(tlv)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) < 8 {
+		return nil, errInvalidMAC
+	}
+	data, mac := value[:len(value)-8], value[len(value)-8:]
+	chain := cmac(s.mac, slices.Concat(s.chain, header, data))
+	if subtle.ConstantTimeCompare(chain[:8], mac) != 1 {
+		return nil, errInvalidMAC
+	}
+	s.chain = chain
+	if !decrypt {
+		return data, nil
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("scp03t: invalid ciphertext length")
+	}
+	block, _ := aes.NewCipher(s.enc)
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, s.iv(block)).CryptBlocks(plain, data)
+	index := bytes.LastIndexByte(plain, 0x80)
+	if index == -1 || slices.ContainsFunc(plain[index+1:], func(b byte) bool { return b != 0 }) {
+		return nil, errors.New("scp03t: invalid padding")
+	}
+	return plain[:index], nil
+}
+
+// wrap secures a value under tag, the reverse of unwrap.
+func (s *scp03t) wrap(tag byte, value []byte, encrypt bool) []byte {
+	if encrypt {
+		block, _ := aes.NewCipher(s.enc)
+		padded := append(bytes.Clone(value), 0x80)
+		padded = append(padded, make([]byte, (aes.BlockSize-len(padded)%aes.BlockSize)%aes.BlockSize)...)
+		value = make([]byte, len(padded))
+		cipher.NewCBCEncrypter(block, s.iv(block)).CryptBlocks(value, padded)
+	}
+	header := append([]byte{tag}, berLength(len(value)+8)...)
+	s.chain = cmac(s.mac, slices.Concat(s.chain, header, value))
+	return slices.Concat(header, value, s.chain[:8])
+}
+
+// iv returns the next initial chaining vector, the encrypted block counter.
+func (s *scp03t) iv(block cipher.Block) []byte {
+	s.counter++
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint32(iv[aes.BlockSize-4:], s.counter)
+	block.Encrypt(iv, iv)
+	return iv
+}
+
+// cmac computes the AES-CMAC of data as specified in NIST SP 800-38B.
+func cmac(key, data []byte) []byte {
+	block, _ := aes.NewCipher(key)
+	subkey := func(in []byte) []byte {
+		out := make([]byte, aes.BlockSize)
+		for i := range aes.BlockSize - 1 {
+			out[i] = in[i]<<1 | in[i+1]>>7
+		}
+		out[aes.BlockSize-1] = in[aes.BlockSize-1] << 1
+		if in[0]&0x80 != 0 {
+			out[aes.BlockSize-1] ^= 0x87
+		}
+		return out
+	}
+	k1 := make([]byte, aes.BlockSize)
+	block.Encrypt(k1, k1)
+	k1 = subkey(k1)
+	k2 := subkey(k1)
+	n := max((len(data)+aes.BlockSize-1)/aes.BlockSize, 1)
+	last := make([]byte, aes.BlockSize)
+	if len(data) > 0 && len(data)%aes.BlockSize == 0 {
+		subtle.XORBytes(last, data[(n-1)*aes.BlockSize:], k1)
+	} else {
+		copy(last, data[(n-1)*aes.BlockSize:])
+		last[len(data)-(n-1)*aes.BlockSize] = 0x80
+		subtle.XORBytes(last, last, k2)
+	}
+	mac := make([]byte, aes.BlockSize)
+	for i := range n - 1 {
+		subtle.XORBytes(mac, mac, data[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		block.Encrypt(mac, mac)
+	}
+	subtle.XORBytes(mac, mac, last)
+	block.Encrypt(mac, mac)
+	return mac
+}
+
+// splitTLV splits a single-byte tag TLV into its tag and length fields and its value.
+func splitTLV(tlv []byte) (header, value []byte, err error) {
+	tag, length, n, err := readHeader(tlv)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tag) != 1 || len(tlv) != n+length {
+		return nil, nil, errors.New("scp03t: malformed TLV")
+	}
+	return tlv[:n], tlv[n:], nil
+}
+
+// readHeader parses the tag and length fields at the start of data.
+// It returns the tag, the content length and the size of the header.
+func readHeader(data []byte) (tag bertlv.Tag, length int, n int, err error) {
+	reader := bytes.NewReader(data)
+	if _, err = tag.ReadFrom(reader); err != nil {
+		return nil, 0, 0, err
+	}
+	n = len(data) - reader.Len()
+	if n >= len(data) {
+		return nil, 0, 0, errors.New("truncated TLV header")
+	}
+	first := data[n]
+	n++
+	if first < 0x80 {
+		return tag, int(first), n, nil
+	}
+	size := int(first & 0x7F)
+	if size == 0 || size > 3 || n+size > len(data) {
+		return nil, 0, 0, errors.New("invalid TLV length")
+	}
+	for _, b := range data[n : n+size] {
+		length = length<<8 | int(b)
+	}
+	return tag, length, n + size, nil
+}
+
+func berLength(n int) []byte {
+	switch {
+	case n < 0x80:
+		return []byte{byte(n)}
+	case n < 0x100:
+		return []byte{0x81, byte(n)}
+	case n < 0x10000:
+		return []byte{0x82, byte(n >> 8), byte(n)}
+	}
+	return []byte{0x83, byte(n >> 16), byte(n >> 8), byte(n)}
+}
@@ -0,0 +1,300 @@
+package simulator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/euicc-go/bertlv"
+)
+
+// ISDRAID is the AID of the ISD-R application answered by the simulator.
+var ISDRAID = []byte{0xA0, 0x00, 0x00, 0x05, 0x59, 0x10, 0x10, 0xFF, 0xFF, 0xFF, 0xFF, 0x89, 0x00, 0x00, 0x01, 0x00}
+
+// Status words returned by the simulator.
+const (
+	swOK                    = 0x9000
+	swWrongLength           = 0x6700
+	swConditionsNotSatisfy  = 0x6985
+	swFileNotFound          = 0x6A82
+	swIncorrectP1P2         = 0x6A86
+	swInsNotSupported       = 0x6D00
+	swClaNotSupported       = 0x6E00
+	swNoLogicalChannelsLeft = 0x6A81
+	swLogicalChannelUnknown = 0x6881
+)
+
+const maxLogicalChannels = 4
+
+// Options configures a new Simulator.
+type Options struct {
+	// EID is the eUICC identifier. It defaults to a fixed test EID.
+	EID []byte
+	// AID is the ISD-R AID answered by SELECT. It defaults to ISDRAID.
+	AID []byte
+	// DefaultSMDPAddress is the initial default SM-DP+ address.
+	DefaultSMDPAddress string
+	// RootSMDSAddress is the root SM-DS address. It defaults to "testrootsmds.gsma.com".
+	RootSMDSAddress string
+	// Certificates are used to sign responses. A fresh test chain is generated if nil.
+	Certificates *Certificates
+}
+
+// Simulator is an in-memory eUICC implementing apdu.SmartCardChannel.
+// It answers the ES10a/b/c functions used by the sgp22 package.
+type Simulator struct {
+	mutex     sync.Mutex
+	connected bool
+	aid       []byte
+	channels  [maxLogicalChannels]bool
+	selected  [maxLogicalChannels]bool
+	command   bytes.Buffer
+	pending   []byte
+	// refresh is set by a profile operation asking for a refresh, which closes the logical channels
+	// once its response is read.
+	refresh bool
+
+	euicc
+}
+
+// New creates a new Simulator with an empty profile store.
+func New(opts *Options) (*Simulator, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	s := &Simulator{aid: opts.AID}
+	if s.aid == nil {
+		s.aid = ISDRAID
+	}
+	s.eid = opts.EID
+	if s.eid == nil {
+		s.eid = []byte{0x89, 0x04, 0x90, 0x32, 0x12, 0x34, 0x51, 0x23, 0x45, 0x12, 0x34, 0x56, 0x78, 0x90, 0x12, 0x24}
+	}
+	s.defaultSMDPAddress = opts.DefaultSMDPAddress
+	s.rootSMDSAddress = opts.RootSMDSAddress
+	if s.rootSMDSAddress == "" {
+		s.rootSMDSAddress = "testrootsmds.gsma.com"
+	}
+	s.certificates = opts.Certificates
+	if s.certificates == nil {
+		var err error
+		if s.certificates, err = NewTestCertificates(s.eid); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Connect implements apdu.SmartCardChannel.
+func (s *Simulator) Connect() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.connected = true
+	s.channels[0] = true
+	return nil
+}
+
+// Disconnect implements apdu.SmartCardChannel.
+func (s *Simulator) Disconnect() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.connected = false
+	s.channels = [maxLogicalChannels]bool{}
+	s.selected = [maxLogicalChannels]bool{}
+	s.command.Reset()
+	s.pending = nil
+	return nil
+}
+
+// OpenLogicalChannel implements apdu.SmartCardChannel.
+// It sends MANAGE CHANNEL and SELECT in the same way a card reader driver does.
+func (s *Simulator) OpenLogicalChannel(AID []byte) (byte, error) {
+	response, err := s.Transmit([]byte{0x00, 0x70, 0x00, 0x00, 0x01})
+	if err != nil {
+		return 0, err
+	}
+	if r := apdu.Response(response); !r.OK() {
+		return 0, fmt.Errorf("open logical channel: %X", response)
+	}
+	channel := response[0]
+	response, err = s.Transmit(append([]byte{channel, 0xA4, 0x04, 0x00, byte(len(AID))}, AID...))
+	if err != nil {
+		return 0, err
+	}
+	if r := apdu.Response(response); !r.OK() {
+		s.CloseLogicalChannel(channel)
+		return 0, fmt.Errorf("select AID: %X", response)
+	}
+	return channel, nil
+}
+
+// CloseLogicalChannel implements apdu.SmartCardChannel.
+func (s *Simulator) CloseLogicalChannel(channel byte) error {
+	response, err := s.Transmit([]byte{0x00, 0x70, 0x80, channel, 0x00})
+	if err != nil {
+		return err
+	}
+	if r := apdu.Response(response); !r.OK() {
+		return fmt.Errorf("close logical channel: %X", response)
+	}
+	return nil
+}
+
+// Transmit implements apdu.SmartCardChannel.
+func (s *Simulator) Transmit(command []byte) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.connected {
+		return nil, errors.New("simulator is not connected")
+	}
+	if len(command) < 4 {
+		return sw(swWrongLength), nil
+	}
+	request := apdu.Request{CLA: command[0], INS: command[1], P1: command[2], P2: command[3]}
+	if len(command) > 5 {
+		if len(command) < 5+int(command[4]) {
+			return sw(swWrongLength), nil
+		}
+		request.Data = command[5 : 5+int(command[4])]
+	} else if len(command) == 5 {
+		request.Le = &command[4]
+	}
+	if s.refresh && request.INS != 0xC0 {
+		s.refresh = false
+		s.channels = [maxLogicalChannels]bool{0: true}
+		s.selected = [maxLogicalChannels]bool{}
+	}
+	channel, ok := s.channelOf(request.CLA)
+	if !ok {
+		return sw(swClaNotSupported), nil
+	}
+	if !s.channels[channel] {
+		return sw(swLogicalChannelUnknown), nil
+	}
+	switch request.INS {
+	case 0x70:
+		return s.manageChannel(&request), nil
+	case 0xA4:
+		return s.selectApplication(channel, &request), nil
+	case 0xAA:
+		return sw(swOK), nil
+	case 0xC0:
+		return s.getResponse(&request), nil
+	case 0xE2:
+		if !s.selected[channel] {
+			return sw(swConditionsNotSatisfy), nil
+		}
+		return s.storeData(&request), nil
+	}
+	return sw(swInsNotSupported), nil
+}
+
+func (s *Simulator) channelOf(cla byte) (byte, bool) {
+	var channel byte
+	if cla&0x40 == 0 {
+		channel = cla & 0x03
+	} else {
+		channel = 4 + cla&0x0F
+	}
+	return channel, channel < maxLogicalChannels
+}
+
+func (s *Simulator) manageChannel(request *apdu.Request) []byte {
+	switch request.P1 {
+	case 0x00:
+		for channel := 1; channel < maxLogicalChannels; channel++ {
+			if !s.channels[channel] {
+				s.channels[channel] = true
+				return []byte{byte(channel), 0x90, 0x00}
+			}
+		}
+		return sw(swNoLogicalChannelsLeft)
+	case 0x80:
+		if request.P2 == 0 || request.P2 >= maxLogicalChannels || !s.channels[request.P2] {
+			return sw(swLogicalChannelUnknown)
+		}
+		s.channels[request.P2] = false
+		s.selected[request.P2] = false
+		return sw(swOK)
+	}
+	return sw(swIncorrectP1P2)
+}
+
+func (s *Simulator) selectApplication(channel byte, request *apdu.Request) []byte {
+	if request.P1 != 0x04 {
+		return sw(swIncorrectP1P2)
+	}
+	if !bytes.Equal(request.Data, s.aid) {
+		s.selected[channel] = false
+		return sw(swFileNotFound)
+	}
+	s.selected[channel] = true
+	return sw(swOK)
+}
+
+func (s *Simulator) getResponse(request *apdu.Request) []byte {
+	if len(s.pending) == 0 {
+		return sw(swConditionsNotSatisfy)
+	}
+	n := 256
+	if request.Le != nil && *request.Le != 0 {
+		n = int(*request.Le)
+	}
+	n = min(n, len(s.pending))
+	response := append([]byte{}, s.pending[:n]...)
+	s.pending = s.pending[n:]
+	return append(response, s.status()...)
+}
+
+// storeData collects STORE DATA blocks and processes the command once the last block arrives.
+func (s *Simulator) storeData(request *apdu.Request) []byte {
+	if request.P2 == 0 {
+		s.command.Reset()
+	}
+	s.command.Write(request.Data)
+	if request.P1&0x80 == 0 {
+		return sw(swOK)
+	}
+	data := bytes.Clone(s.command.Bytes())
+	s.command.Reset()
+	response, err := s.handle(data)
+	if err != nil {
+		return sw(swConditionsNotSatisfy)
+	}
+	s.pending = response
+	return s.status()
+}
+
+// status returns 61xx while response data is pending, like a T=0 card.
+func (s *Simulator) status() []byte {
+	switch {
+	case len(s.pending) == 0:
+		return sw(swOK)
+	case len(s.pending) >= 256:
+		return []byte{0x61, 0x00}
+	}
+	return []byte{0x61, byte(len(s.pending))}
+}
+
+func (s *Simulator) handle(data []byte) ([]byte, error) {
+	// Segments of a bound profile package never start with an ES10 request tag.
+	if bytes.HasPrefix(data, []byte{0xBF, 0x36}) || (s.bpp != nil && len(data) > 0 && data[0] != 0xBF) {
+		return s.loadBoundProfilePackage(data)
+	}
+	s.bpp = nil
+	var request bertlv.TLV
+	if err := request.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	response, err := s.dispatch(&request)
+	if err != nil || response == nil {
+		return nil, err
+	}
+	return response.MarshalBinary()
+}
+
+func sw(value uint16) []byte {
+	return []byte{byte(value >> 8), byte(value)}
+}
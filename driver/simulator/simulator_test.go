@@ -0,0 +1,490 @@
+package simulator
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/euicc-go/bertlv"
+	"github.com/damonto/euicc-go/driver"
+	"github.com/damonto/euicc-go/lpa"
+	sgp22 "github.com/damonto/euicc-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newClient(t *testing.T) (*Simulator, *lpa.Client) {
+	s, err := New(nil)
+	assert.NoError(t, err)
+	client, err := lpa.New(&lpa.Options{
+		Channel: s,
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+	return s, client
+}
+
+func TestCMAC(t *testing.T) {
+	// RFC 4493, Section 4
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	message, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172a")
+	assert.Equal(t, "bb1d6929e95937287fa37d129b756746", hex.EncodeToString(cmac(key, nil)))
+	assert.Equal(t, "070a16b46b4d4144f79bdd9dd04a287c", hex.EncodeToString(cmac(key, message)))
+}
+
+func TestSimulator_Profiles(t *testing.T) {
+	s, client := newClient(t)
+	eid, err := client.EID()
+	assert.NoError(t, err)
+	assert.Equal(t, s.eid, eid)
+
+	first, _ := sgp22.NewICCID("8944476500001224158")
+	second, _ := sgp22.NewICCID("8944476500001224166")
+	assert.NoError(t, s.AddProfile(Profile{
+		ICCID:               first,
+		ServiceProviderName: "Test",
+		ProfileName:         "First",
+		NotificationConfigurationInfo: sgp22.NotificationConfigurationInfo{
+			{ProfileManagementOperation: sgp22.NotificationEventEnable, Address: "smdp.example.com"},
+		},
+	}))
+	assert.NoError(t, s.AddProfile(Profile{ICCID: second, ProfileName: "Second", State: sgp22.ProfileEnabled}))
+	assert.Error(t, s.AddProfile(Profile{ICCID: second}))
+
+	profiles, err := client.ListProfile(nil, nil)
+	assert.NoError(t, err)
+	assert.Len(t, profiles, 2)
+	assert.Equal(t, first, profiles[0].ICCID)
+	assert.Equal(t, "First", profiles[0].ProfileName)
+	assert.Equal(t, sgp22.ProfileDisabled, profiles[0].ProfileState)
+
+	assert.NoError(t, client.EnableProfile(first, false))
+	assert.NoError(t, client.SetNickname(first, "Travel"))
+	assert.Error(t, client.DeleteProfile(first))
+	assert.NoError(t, client.DeleteProfile(second))
+	installed := s.Profiles()
+	assert.Len(t, installed, 1)
+	assert.Equal(t, sgp22.ProfileEnabled, installed[0].State)
+	assert.Equal(t, "Travel", installed[0].Nickname)
+
+	notifications, err := client.ListNotification()
+	assert.NoError(t, err)
+	assert.Len(t, notifications, 1)
+	assert.Equal(t, sgp22.NotificationEventEnable, notifications[0].ProfileManagementOperation)
+	pending, err := client.RetrieveNotificationList(notifications[0].SequenceNumber)
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1)
+	assert.NoError(t, client.RemoveNotificationFromList(notifications[0].SequenceNumber))
+	assert.Empty(t, s.Notifications())
+}
+
+// mustSign signs the data with the key of the SM-DP+, to run its side of a download by hand.
+func mustSign(t *testing.T, dp *SMDP, data ...[]byte) *bertlv.TLV {
+	signature, err := dp.sign(data...)
+	assert.NoError(t, err)
+	return signature
+}
+
+func TestSimulator_Download(t *testing.T) {
+	s, client := newClient(t)
+	dp, err := NewSMDP(s.Certificates())
+	assert.NoError(t, err)
+	transactionID := []byte{0x01, 0x02, 0x03, 0x04}
+
+	challenge, err := client.EUICCChallenge()
+	assert.NoError(t, err)
+	signed1 := bertlv.NewChildren(
+		bertlv.Universal.Constructed(16),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(0), transactionID),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(1), challenge),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(3), []byte("smdp.example.com")),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(4), make([]byte, 16)),
+	)
+	pkid, err := s.certificates.CIPKID()
+	assert.NoError(t, err)
+	imei, _ := sgp22.NewIMEI("356938035643809")
+	authenticated, err := sgp22.InvokeAPDU(client.APDU, &sgp22.AuthenticateServerRequest{
+		TransactionID: transactionID,
+		Signed1:       signed1,
+		Signature1:    mustSign(t, dp, signed1.Bytes()),
+		UsedIssuer:    bertlv.NewValue(bertlv.Universal.Primitive(4), pkid),
+		Certificate:   certificate(dp.certificate),
+		IMEI:          imei,
+		MatchingID:    []byte("TEST"),
+	})
+	assert.NoError(t, err)
+	ok := authenticated.Response.First(bertlv.ContextSpecific.Constructed(0))
+	assert.NotNil(t, ok)
+	euiccSigned1 := ok.First(bertlv.Universal.Constructed(16))
+	euiccSignature1 := ok.First(bertlv.Application.Primitive(55))
+	assert.NoError(t, verify(s.certificates.EUICC, euiccSigned1.Bytes(), euiccSignature1.Value))
+
+	signed2 := bertlv.NewChildren(
+		bertlv.Universal.Constructed(16),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(0), transactionID),
+		bertlv.NewValue(bertlv.Universal.Primitive(1), []byte{0x00}),
+	)
+	prepared, err := sgp22.InvokeAPDU(client.APDU, &sgp22.PrepareDownloadRequest{
+		TransactionID: transactionID,
+		Signed2:       signed2,
+		Signature2:    mustSign(t, dp, signed2.Bytes(), euiccSignature1.Bytes()),
+		Certificate:   certificate(dp.certificate),
+	})
+	assert.NoError(t, err)
+	ok = prepared.Response.First(bertlv.ContextSpecific.Constructed(0))
+	assert.NotNil(t, ok)
+	euiccSigned2 := ok.First(bertlv.Universal.Constructed(16))
+	euiccSignature2 := ok.First(bertlv.Application.Primitive(55))
+
+	otpk, err := ecdh.P256().NewPublicKey(euiccSigned2.First(bertlv.Application.Primitive(73)).Value)
+	assert.NoError(t, err)
+	otsk, err := ecdh.P256().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	secret, err := otsk.ECDH(otpk)
+	assert.NoError(t, err)
+	hostID := []byte("euicc-go")
+	channel := newSCP03t(secret, 0x88, 0x10, hostID, s.eid)
+	secured := func(tag byte, value []byte, encrypt bool) *bertlv.TLV {
+		var tlv bertlv.TLV
+		assert.NoError(t, tlv.UnmarshalBinary(channel.wrap(tag, value, encrypt)))
+		return &tlv
+	}
+
+	initialise := []*bertlv.TLV{
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(2), []byte{0x01}),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(0), transactionID),
+		bertlv.NewChildren(
+			bertlv.ContextSpecific.Constructed(6),
+			bertlv.NewValue(bertlv.ContextSpecific.Primitive(0), []byte{0x88}),
+			bertlv.NewValue(bertlv.ContextSpecific.Primitive(1), []byte{0x10}),
+			bertlv.NewValue(bertlv.ContextSpecific.Primitive(4), hostID),
+		),
+		bertlv.NewValue(bertlv.Application.Primitive(73), otsk.PublicKey().Bytes()),
+	}
+	var signed []byte
+	for _, tlv := range initialise {
+		signed = append(signed, tlv.Bytes()...)
+	}
+	initialise = append(initialise, mustSign(t, dp, signed, euiccSignature2.Bytes()))
+
+	iccid, _ := sgp22.NewICCID("8944476500001224174")
+	metadata := bertlv.NewChildren(
+		bertlv.ContextSpecific.Constructed(37),
+		bertlv.NewValue(sgp22.TagICCID, iccid),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(17), []byte("Test Operator")),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(18), []byte("Test Profile")),
+	)
+	bpp := bertlv.NewChildren(
+		bertlv.ContextSpecific.Constructed(54),
+		bertlv.NewChildren(bertlv.ContextSpecific.Constructed(35), initialise...),
+		bertlv.NewChildren(bertlv.ContextSpecific.Constructed(0), secured(0x87, bertlv.NewChildren(bertlv.ContextSpecific.Constructed(36)).Bytes(), true)),
+		bertlv.NewChildren(bertlv.ContextSpecific.Constructed(1), secured(0x88, metadata.Bytes(), false)),
+		bertlv.NewChildren(
+			bertlv.ContextSpecific.Constructed(3),
+			secured(0x86, make([]byte, 300), true),
+			secured(0x86, []byte{0xA0, 0x00}, true),
+		),
+	)
+	segments, err := sgp22.SegmentedBoundProfilePackage(bpp)
+	assert.NoError(t, err)
+	var r []byte
+	for index, segment := range segments {
+		r, err = sgp22.InvokeRawAPDU(client.APDU, segment)
+		assert.NoError(t, err)
+		if index < len(segments)-1 {
+			assert.Empty(t, r)
+		}
+	}
+	var tlv bertlv.TLV
+	assert.NoError(t, tlv.UnmarshalBinary(r))
+	var result sgp22.LoadBoundProfilePackageResponse
+	assert.NoError(t, result.UnmarshalBERTLV(&tlv))
+	assert.NoError(t, result.Valid())
+	assert.Equal(t, transactionID, result.TransactionID)
+	assert.Equal(t, sgp22.NotificationEventInstall, result.Notification.ProfileManagementOperation)
+	assert.NotEmpty(t, result.ISDPAID())
+
+	profiles := s.Profiles()
+	assert.Len(t, profiles, 1)
+	assert.Equal(t, sgp22.ICCID(iccid), profiles[0].ICCID)
+	assert.Equal(t, "Test Profile", profiles[0].ProfileName)
+	assert.Equal(t, sgp22.ProfileDisabled, profiles[0].State)
+
+	pending, err := client.RetrieveNotificationList(result.Notification.SequenceNumber)
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1)
+}
+
+func TestSimulator_AuthenticateServerChallengeMismatch(t *testing.T) {
+	s, client := newClient(t)
+	dp, err := NewSMDP(s.Certificates())
+	assert.NoError(t, err)
+	_, err = client.EUICCChallenge()
+	assert.NoError(t, err)
+	signed1 := bertlv.NewChildren(
+		bertlv.Universal.Constructed(16),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(0), []byte{0x01}),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(1), make([]byte, 16)),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(3), []byte("smdp.example.com")),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(4), make([]byte, 16)),
+	)
+	imei, _ := sgp22.NewIMEI("356938035643809")
+	response, err := sgp22.InvokeAPDU(client.APDU, &sgp22.AuthenticateServerRequest{
+		TransactionID: []byte{0x01},
+		Signed1:       signed1,
+		Signature1:    mustSign(t, dp, signed1.Bytes()),
+		UsedIssuer:    bertlv.NewValue(bertlv.Universal.Primitive(4), nil),
+		Certificate:   certificate(dp.certificate),
+		IMEI:          imei,
+	})
+	assert.NoError(t, err)
+	failed := response.Response.First(bertlv.ContextSpecific.Constructed(1))
+	assert.NotNil(t, failed)
+	assert.Equal(t, []byte{0x06}, failed.First(bertlv.Universal.Primitive(2)).Value)
+}
+
+func TestSimulator_Concurrent(t *testing.T) {
+	s, client := newClient(t)
+	iccid, _ := sgp22.NewICCID("8944476500001224182")
+	assert.NoError(t, s.AddProfile(Profile{ICCID: iccid, ProfileName: strings.Repeat("Long Profile Name ", 20)}))
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			eid, err := client.EID()
+			assert.NoError(t, err)
+			assert.Equal(t, s.eid, eid)
+		}()
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, client.Session(func(c *lpa.Client) error {
+				if err := c.SetNickname(iccid, "Session"); err != nil {
+					return err
+				}
+				profiles, err := c.ListProfile(nil, nil)
+				assert.Len(t, profiles, 1)
+				return err
+			}))
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSimulator_WrongLength(t *testing.T) {
+	s, err := New(nil)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Connect())
+	defer s.Disconnect()
+	response, err := s.Transmit([]byte{0x80, 0xE2, 0x91, 0x00, 0x10, 0xBF, 0x2D})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x67, 0x00}, response)
+}
+
+func TestSimulator_Refresh(t *testing.T) {
+	s, err := New(nil)
+	assert.NoError(t, err)
+	iccid, _ := sgp22.NewICCID("8944476500001224190")
+	assert.NoError(t, s.AddProfile(Profile{ICCID: iccid}))
+	client, err := lpa.New(&lpa.Options{
+		Channel:  s,
+		Recovery: driver.Recovery{Retries: 2},
+		Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	assert.NoError(t, err)
+	defer client.Close()
+
+	// The refresh closes the logical channel, the next command reopens it.
+	assert.NoError(t, client.EnableProfile(iccid, true))
+	profiles, err := client.ListProfile(nil, nil)
+	assert.NoError(t, err)
+	assert.Len(t, profiles, 1)
+	assert.Equal(t, sgp22.ProfileEnabled, profiles[0].ProfileState)
+
+	disabled, err := lpa.New(&lpa.Options{
+		Channel: s,
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	assert.NoError(t, err)
+	defer disabled.Close()
+	assert.NoError(t, disabled.DisableProfile(iccid, true))
+	_, err = disabled.ListProfile(nil, nil)
+	assert.ErrorIs(t, err, apdu.StatusError(0x6881))
+}
+
+// failOnce is a channel whose driver fails once after the card ran a STORE DATA command.
+type failOnce struct {
+	*Simulator
+	fail   bool
+	stores int
+}
+
+func (f *failOnce) Transmit(command []byte) ([]byte, error) {
+	response, err := f.Simulator.Transmit(command)
+	if len(command) > 1 && command[1] == 0xE2 {
+		f.stores++
+		if f.fail {
+			f.fail = false
+			return nil, errors.New("modem reset")
+		}
+	}
+	return response, err
+}
+
+func TestSimulator_RecoveryDriverFailure(t *testing.T) {
+	s, err := New(nil)
+	assert.NoError(t, err)
+	channel := &failOnce{Simulator: s}
+	client, err := lpa.New(&lpa.Options{
+		Channel:  channel,
+		Recovery: driver.Recovery{Retries: 2},
+		Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	assert.NoError(t, err)
+	defer client.Close()
+
+	// The command may have run, so it is not sent again, but the channel is restored for the next one.
+	channel.fail, channel.stores = true, 0
+	_, err = client.EID()
+	assert.EqualError(t, err, "modem reset")
+	assert.Equal(t, 1, channel.stores)
+	eid, err := client.EID()
+	assert.NoError(t, err)
+	assert.Equal(t, s.eid, eid)
+}
+
+// connectOnce is a channel which cannot connect again once disconnected, as many drivers.
+type connectOnce struct {
+	*Simulator
+	connects, disconnects int
+}
+
+func (c *connectOnce) Connect() error {
+	if c.disconnects > 0 {
+		return errors.New("channel is closed")
+	}
+	c.connects++
+	return c.Simulator.Connect()
+}
+
+func (c *connectOnce) Disconnect() error {
+	c.disconnects++
+	return c.Simulator.Disconnect()
+}
+
+func TestSimulator_ProbeAID(t *testing.T) {
+	s, err := New(&Options{AID: lpa.ISDRApplications[3].AID})
+	assert.NoError(t, err)
+	channel := &connectOnce{Simulator: s}
+	client, err := lpa.New(&lpa.Options{
+		Channel: channel,
+		Probe:   true,
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "9eSIM", client.Application().Vendor)
+	assert.Equal(t, 1, channel.connects)
+	eid, err := client.EID()
+	assert.NoError(t, err)
+	assert.Equal(t, s.eid, eid)
+	assert.NoError(t, client.Close())
+	assert.Equal(t, 1, channel.disconnects)
+
+	_, err = lpa.New(&lpa.Options{
+		Channel:      s,
+		Probe:        true,
+		Applications: lpa.ISDRApplications[:3],
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	assert.Error(t, err)
+}
+
+// captureWriter records whether the capture was closed.
+type captureWriter struct{ closed bool }
+
+func (w *captureWriter) WriteAPDU(command, response []byte) error { return nil }
+
+func (w *captureWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestSimulator_CaptureClosedOnError(t *testing.T) {
+	s, err := New(nil)
+	assert.NoError(t, err)
+	capture := new(captureWriter)
+	_, err = lpa.New(&lpa.Options{
+		Channel: s,
+		AID:     []byte{0xA0, 0x00, 0x00, 0x00, 0x00},
+		Capture: capture,
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	assert.Error(t, err)
+	assert.True(t, capture.closed)
+}
+
+func TestSimulator_Open(t *testing.T) {
+	ch, err := driver.Open("simulator:?eid=89049032123451234512345678900001&smdp=smdp.example.com")
+	assert.NoError(t, err)
+	client, err := lpa.New(&lpa.Options{
+		Channel: ch,
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	assert.NoError(t, err)
+	defer client.Close()
+	eid, err := client.EID()
+	assert.NoError(t, err)
+	assert.Equal(t, "89049032123451234512345678900001", hex.EncodeToString(eid))
+}
+
+func TestSMDP_DownloadProfile(t *testing.T) {
+	s, client := newClient(t)
+	dp, err := NewSMDP(s.Certificates())
+	assert.NoError(t, err)
+	iccid, _ := sgp22.NewICCID("8944476500001224208")
+	dp.AddProfile("MATCHING", Profile{ICCID: iccid, ServiceProviderName: "Test Operator", ProfileName: "Test Profile"}, "1234")
+	server := httptest.NewTLSServer(dp)
+	defer server.Close()
+	client.HTTP.Client = server.Client()
+
+	var ac lpa.ActivationCode
+	assert.NoError(t, ac.UnmarshalText([]byte("LPA:1$"+server.Listener.Addr().String()+"$MATCHING")))
+	ac.IMEI = "356938035643809"
+	var stages []lpa.DownloadStage
+	response, err := client.DownloadProfile(t.Context(), &ac, &lpa.DownloadOptions{
+		OnProgress: func(stage lpa.DownloadStage) { stages = append(stages, stage) },
+		OnConfirm: func(metadata *sgp22.ProfileInfo) bool {
+			assert.Equal(t, "Test Profile", metadata.ProfileName)
+			// The eUICC is not locked while waiting for the user.
+			eid, err := client.EID()
+			assert.NoError(t, err)
+			assert.Equal(t, s.eid, eid)
+			return true
+		},
+		OnEnterConfirmationCode: func() string { return "1234" },
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []lpa.DownloadStage{
+		lpa.DownloadStageAuthenticateClient,
+		lpa.DownloadStageAuthenticateServer,
+		lpa.DownloadStageInstall,
+	}, stages)
+	assert.Equal(t, sgp22.NotificationEventInstall, response.Notification.ProfileManagementOperation)
+	profiles := s.Profiles()
+	assert.Len(t, profiles, 1)
+	assert.Equal(t, "Test Profile", profiles[0].ProfileName)
+
+	// A wrong confirmation code is rejected by the SM-DP+.
+	ac.ConfirmationCode = "0000"
+	_, err = client.DownloadProfile(t.Context(), &ac, nil)
+	assert.ErrorContains(t, err, "invalid confirmation code")
+}
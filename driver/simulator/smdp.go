@@ -0,0 +1,359 @@
+package simulator
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/damonto/euicc-go/bertlv"
+	sgp22 "github.com/damonto/euicc-go/v2"
+)
+
+// SMDP is an in-memory SM-DP+ serving the ES9+ functions over HTTP, so the profile downloads
+// of an lpa.Client can be tested against a Simulator end to end, e.g. behind httptest.NewTLSServer.
+// Its certificate is issued by the test CI of the simulator.
+type SMDP struct {
+	mutex       sync.Mutex
+	key         *ecdsa.PrivateKey
+	certificate []byte
+	ciPKID      []byte
+	offers      map[string]offer
+	sessions    map[string]*dpSession
+}
+
+// offer is a profile offered to the downloads with its matching ID.
+type offer struct {
+	profile          Profile
+	confirmationCode string
+}
+
+// dpSession is the RSP session of a download, keyed by its transaction ID.
+type dpSession struct {
+	transactionID []byte
+	challenge     []byte
+	offer         *offer
+	eid           []byte
+	certificate   []byte
+	signature2    *bertlv.TLV
+}
+
+// NewSMDP creates an SM-DP+ trusted by the simulators using certificates, see Simulator.Certificates.
+// The CI private key of certificates is required to issue the SM-DP+ certificate.
+func NewSMDP(certificates *Certificates) (*SMDP, error) {
+	if certificates == nil || certificates.CIKey == nil {
+		return nil, errors.New("CI private key is required")
+	}
+	ci, err := x509.ParseCertificate(certificates.CI)
+	if err != nil {
+		return nil, err
+	}
+	dp := &SMDP{
+		ciPKID:   ci.SubjectKeyId,
+		offers:   make(map[string]offer),
+		sessions: make(map[string]*dpSession),
+	}
+	if dp.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return nil, err
+	}
+	dp.certificate, err = x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(4),
+		Subject:      pkix.Name{CommonName: "euicc-go Test SM-DP+", Organization: []string{"euicc-go"}},
+		NotBefore:    ci.NotBefore,
+		NotAfter:     ci.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, ci, &dp.key.PublicKey, certificates.CIKey)
+	if err != nil {
+		return nil, err
+	}
+	return dp, nil
+}
+
+// AddProfile offers the profile to the downloads using matchingID.
+// The download requires the confirmation code unless it is empty.
+func (dp *SMDP) AddProfile(matchingID string, profile Profile, confirmationCode string) {
+	dp.mutex.Lock()
+	defer dp.mutex.Unlock()
+	dp.offers[matchingID] = offer{profile: profile, confirmationCode: confirmationCode}
+}
+
+// ServeHTTP implements http.Handler.
+func (dp *SMDP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/gsma/rsp2/es9plus/initiateAuthentication":
+		serveES9(w, r, dp.initiateAuthentication)
+	case "/gsma/rsp2/es9plus/authenticateClient":
+		serveES9(w, r, dp.authenticateClient)
+	case "/gsma/rsp2/es9plus/getBoundProfilePackage":
+		serveES9(w, r, dp.getBoundProfilePackage)
+	case "/gsma/rsp2/es9plus/cancelSession":
+		serveES9(w, r, dp.cancelSession)
+	case "/gsma/rsp2/es9plus/handleNotification":
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveES9 decodes the request, and answers the response of fn or its error in the header.
+func serveES9[T any](w http.ResponseWriter, r *http.Request, fn func(request *T) (any, error)) {
+	request := new(T)
+	response, err := any(nil), json.NewDecoder(r.Body).Decode(request)
+	if err == nil {
+		response, err = fn(request)
+	}
+	if err != nil {
+		response = map[string]*sgp22.Header{"header": {ExecutionStatus: &sgp22.ExecutionStatus{
+			Status:         "Failed",
+			StatusCodeData: &sgp22.StatusCodeData{Message: err.Error()},
+		}}}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Admin-Protocol", r.Header.Get("X-Admin-Protocol"))
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func executedSuccess() *sgp22.Header {
+	return &sgp22.Header{ExecutionStatus: &sgp22.ExecutionStatus{Status: "Executed-Success"}}
+}
+
+// session returns the session of the transaction ID, holding the mutex.
+func (dp *SMDP) session(transactionID []byte) (*dpSession, error) {
+	session, ok := dp.sessions[hex.EncodeToString(transactionID)]
+	if !ok {
+		return nil, errors.New("unknown transaction ID")
+	}
+	return session, nil
+}
+
+func (dp *SMDP) initiateAuthentication(request *sgp22.ES9InitiateAuthenticationRequest) (any, error) {
+	session := &dpSession{transactionID: make([]byte, 16), challenge: make([]byte, 16)}
+	_, _ = rand.Read(session.transactionID)
+	_, _ = rand.Read(session.challenge)
+	signed1 := bertlv.NewChildren(
+		bertlv.Universal.Constructed(16),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(0), session.transactionID),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(1), request.Challenge),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(3), []byte(request.Address)),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(4), session.challenge),
+	)
+	signature1, err := dp.sign(signed1.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	dp.mutex.Lock()
+	dp.sessions[hex.EncodeToString(session.transactionID)] = session
+	dp.mutex.Unlock()
+	return &sgp22.ES9InitiateAuthenticationResponse{
+		Header:        executedSuccess(),
+		TransactionID: session.transactionID,
+		Signed1:       signed1,
+		Signature1:    signature1,
+		UsedIssuer:    bertlv.NewValue(bertlv.Universal.Primitive(4), dp.ciPKID),
+		Certificate:   certificate(dp.certificate),
+	}, nil
+}
+
+func (dp *SMDP) authenticateClient(request *sgp22.ES9AuthenticateClientRequest) (any, error) {
+	dp.mutex.Lock()
+	defer dp.mutex.Unlock()
+	session, err := dp.session(request.TransactionID)
+	if err != nil {
+		return nil, err
+	}
+	ok := request.Response.First(bertlv.ContextSpecific.Constructed(0))
+	if ok == nil || len(ok.Children) < 4 {
+		return nil, errors.New("eUICC failed to authenticate the server")
+	}
+	euiccSigned1, euiccSignature1 := ok.At(0), ok.At(1)
+	euiccCertificate, err := x509.ParseCertificate(ok.At(2).Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if err := verify(ok.At(2).Bytes(), euiccSigned1.Bytes(), euiccSignature1.Value); err != nil {
+		return nil, err
+	}
+	if challenge := euiccSigned1.First(bertlv.ContextSpecific.Primitive(4)); challenge == nil || !bytes.Equal(challenge.Value, session.challenge) {
+		return nil, errors.New("server challenge mismatch")
+	}
+	var matchingID []byte
+	if ctxParams1 := euiccSigned1.First(bertlv.ContextSpecific.Constructed(0)); ctxParams1 != nil {
+		if tlv := ctxParams1.First(bertlv.ContextSpecific.Primitive(0)); tlv != nil {
+			matchingID = tlv.Value
+		}
+	}
+	offer, found := dp.offers[string(matchingID)]
+	if !found {
+		return nil, errors.New("no profile for the matching ID")
+	}
+	if session.eid, err = hex.DecodeString(euiccCertificate.Subject.SerialNumber); err != nil {
+		return nil, err
+	}
+	session.offer, session.certificate = &offer, ok.At(2).Bytes()
+	ccRequired := []byte{0x00}
+	if offer.confirmationCode != "" {
+		ccRequired = []byte{0xFF}
+	}
+	signed2 := bertlv.NewChildren(
+		bertlv.Universal.Constructed(16),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(0), session.transactionID),
+		bertlv.NewValue(bertlv.Universal.Primitive(1), ccRequired),
+	)
+	if session.signature2, err = dp.sign(signed2.Bytes(), euiccSignature1.Bytes()); err != nil {
+		return nil, err
+	}
+	return &sgp22.ES9AuthenticateClientResponse{
+		Header:          executedSuccess(),
+		TransactionID:   session.transactionID,
+		ProfileMetadata: offer.profile.storeMetadataRequest(),
+		Signed2:         signed2,
+		Signature2:      session.signature2,
+		Certificate:     certificate(dp.certificate),
+	}, nil
+}
+
+func (dp *SMDP) getBoundProfilePackage(request *sgp22.ES9BoundProfilePackageRequest) (any, error) {
+	dp.mutex.Lock()
+	defer dp.mutex.Unlock()
+	session, err := dp.session(request.TransactionID)
+	if err != nil || session.offer == nil {
+		return nil, errors.New("unknown transaction ID")
+	}
+	delete(dp.sessions, hex.EncodeToString(session.transactionID))
+	ok := request.Response.First(bertlv.ContextSpecific.Constructed(0))
+	if ok == nil || len(ok.Children) < 2 {
+		return nil, errors.New("eUICC failed to prepare the download")
+	}
+	euiccSigned2, euiccSignature2 := ok.At(0), ok.At(1)
+	if err := verify(session.certificate, slices.Concat(euiccSigned2.Bytes(), session.signature2.Bytes()), euiccSignature2.Value); err != nil {
+		return nil, err
+	}
+	otpk := euiccSigned2.First(bertlv.Application.Primitive(73))
+	if otpk == nil {
+		return nil, errors.New("malformed euiccSigned2")
+	}
+	if code := session.offer.confirmationCode; code != "" {
+		hashed := sha256.Sum256([]byte(code))
+		expected := sha256.Sum256(slices.Concat(hashed[:], session.transactionID))
+		if hashCc := euiccSigned2.First(bertlv.Universal.Primitive(4)); hashCc == nil || !bytes.Equal(hashCc.Value, expected[:]) {
+			return nil, errors.New("invalid confirmation code")
+		}
+	}
+	bpp, err := dp.boundProfilePackage(session, otpk.Value, euiccSignature2)
+	if err != nil {
+		return nil, err
+	}
+	return &sgp22.ES9BoundProfilePackageResponse{
+		Header:              executedSuccess(),
+		TransactionID:       session.transactionID,
+		BoundProfilePackage: bpp,
+	}, nil
+}
+
+func (dp *SMDP) cancelSession(request *sgp22.ES9CancelSessionRequest) (any, error) {
+	dp.mutex.Lock()
+	defer dp.mutex.Unlock()
+	if _, err := dp.session(request.TransactionID); err != nil {
+		return nil, err
+	}
+	delete(dp.sessions, hex.EncodeToString(request.TransactionID))
+	return &sgp22.ES9CancelSessionResponse{Header: executedSuccess()}, nil
+}
+
+// boundProfilePackage binds the profile of the session to the one-time public key of the eUICC.
+//
+// See https://aka.pw/sgp22/v2.5#page=60 (Section 2.5.5, Bound Profile Package)
+func (dp *SMDP) boundProfilePackage(session *dpSession, otpk []byte, euiccSignature2 *bertlv.TLV) (*bertlv.TLV, error) {
+	publicKey, err := ecdh.P256().NewPublicKey(otpk)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := privateKey.ECDH(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	hostID := []byte("euicc-go")
+	initialise := []*bertlv.TLV{
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(2), []byte{0x01}),
+		bertlv.NewValue(bertlv.ContextSpecific.Primitive(0), session.transactionID),
+		bertlv.NewChildren(
+			bertlv.ContextSpecific.Constructed(6),
+			bertlv.NewValue(bertlv.ContextSpecific.Primitive(0), []byte{0x88}),
+			bertlv.NewValue(bertlv.ContextSpecific.Primitive(1), []byte{0x10}),
+			bertlv.NewValue(bertlv.ContextSpecific.Primitive(4), hostID),
+		),
+		bertlv.NewValue(bertlv.Application.Primitive(73), privateKey.PublicKey().Bytes()),
+	}
+	var signed []byte
+	for _, tlv := range initialise {
+		signed = append(signed, tlv.Bytes()...)
+	}
+	signature, err := dp.sign(signed, euiccSignature2.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	initialise = append(initialise, signature)
+
+	channel := newSCP03t(secret, 0x88, 0x10, hostID, session.eid)
+	var secureErr error
+	secured := func(tag byte, value []byte, encrypt bool) *bertlv.TLV {
+		var tlv bertlv.TLV
+		if err := tlv.UnmarshalBinary(channel.wrap(tag, value, encrypt)); err != nil {
+			secureErr = err
+		}
+		return &tlv
+	}
+	bpp := bertlv.NewChildren(
+		bertlv.ContextSpecific.Constructed(54),
+		bertlv.NewChildren(bertlv.ContextSpecific.Constructed(35), initialise...),
+		bertlv.NewChildren(bertlv.ContextSpecific.Constructed(0), secured(0x87, bertlv.NewChildren(bertlv.ContextSpecific.Constructed(36)).Bytes(), true)),
+		bertlv.NewChildren(bertlv.ContextSpecific.Constructed(1), secured(0x88, session.offer.profile.storeMetadataRequest().Bytes(), false)),
+		bertlv.NewChildren(bertlv.ContextSpecific.Constructed(3), secured(0x86, []byte{0xA0, 0x00}, true)),
+	)
+	return bpp, secureErr
+}
+
+// sign signs the concatenated data with the SM-DP+ key and returns the signature TLV.
+func (dp *SMDP) sign(data ...[]byte) (*bertlv.TLV, error) {
+	digest := sha256.Sum256(slices.Concat(data...))
+	der, err := ecdsa.SignASN1(rand.Reader, dp.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	signature, err := rawSignature(der, dp.key.Curve.Params().BitSize)
+	if err != nil {
+		return nil, err
+	}
+	return bertlv.NewValue(bertlv.Application.Primitive(55), signature), nil
+}
+
+// storeMetadataRequest encodes the metadata of the profile as StoreMetadataRequest.
+func (p *Profile) storeMetadataRequest() *bertlv.TLV {
+	metadata := p.profileInfo([]bertlv.Tag{
+		sgp22.TagICCID,
+		sgp22.TagServiceProviderName,
+		sgp22.TagProfileName,
+		sgp22.TagProfileIconType,
+		sgp22.TagProfileIcon,
+		sgp22.TagProfileClass,
+		sgp22.TagNotificationConfigurationInfo,
+		sgp22.TagProfileOwner,
+	})
+	metadata.Tag = bertlv.ContextSpecific.Constructed(37)
+	return metadata
+}
@@ -0,0 +1,24 @@
+package driver
+
+import sgp22 "github.com/damonto/euicc-go/v2"
+
+// SlotManager is implemented by the channels of modems with several physical SIM slots, such as QMI and MBIM.
+// Such a channel created for slot 0 uses the active slot as is, so slots are only switched with SwitchSlot.
+type SlotManager interface {
+	// Slots returns the physical slots of the modem.
+	Slots() ([]Slot, error)
+	// SwitchSlot activates the physical slot, numbered from 1, and waits for its card to be ready.
+	// The logical channels opened before the switch are lost.
+	SwitchSlot(slot uint8) error
+}
+
+// Slot is a physical SIM slot. The ICCID and the EID are only reported when the modem knows them.
+type Slot struct {
+	// Number is the physical slot number, starting from 1.
+	Number  uint8
+	Active  bool
+	Present bool
+	ICCID   sgp22.ICCID
+	EID     []byte
+	EUICC   bool
+}
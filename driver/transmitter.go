@@ -1,52 +1,110 @@
 package driver
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"sync"
+	"sync/atomic"
 
 	"github.com/damonto/euicc-go/apdu"
 	"github.com/damonto/euicc-go/bertlv"
 	sgp22 "github.com/damonto/euicc-go/v2"
 )
 
+var ErrSessionClosed = errors.New("session is closed")
+
+// Error is the error of a Transmitter when the driver failed rather than the card answered,
+// e.g. because the modem was unplugged. The channel may have to be opened again.
+type Error struct {
+	Err error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
 type Transmitter interface {
 	sgp22.Transmitter
+	// Session acquires exclusive access to the card until the returned transmitter is closed.
+	// Commands sent through the parent transmitter wait for the session to be closed.
+	// Closing a session releases the card but does not close it.
+	Session() Transmitter
 	Close() error
 }
 
 type transmitter struct {
-	card   io.ReadWriteCloser
-	logger *slog.Logger
+	mutex    sync.Mutex
+	card     *apdu.Transmitter
+	channel  apdu.SmartCardChannel
+	recovery Recovery
+	logger   *slog.Logger
+}
+
+// Selector is a Transmitter whose application can be changed, e.g. to probe the AIDs of a card.
+type Selector interface {
+	Transmitter
+	// Select opens a logical channel to the application with the given AID, in place of the open one.
+	Select(AID []byte) error
 }
 
-func NewTransmitter(logger *slog.Logger, channel apdu.SmartCardChannel, AID []byte, MSS int) (Transmitter, error) {
+// NewTransmitter connects the channel and selects the application on a new logical channel.
+// When the logical channel is lost, the transmitter restores it as configured by recovery.
+func NewTransmitter(logger *slog.Logger, channel apdu.SmartCardChannel, AID []byte, MSS int, recovery Recovery) (Transmitter, error) {
 	t, err := apdu.NewTransmitter(channel, AID, MSS)
 	if err != nil {
 		return nil, err
 	}
-	return &transmitter{card: t, logger: logger}, nil
+	recovery.setDefaults()
+	return &transmitter{card: t, channel: channel, recovery: recovery, logger: logger}, nil
 }
 
-func (t *transmitter) Transmit(request bertlv.Marshaler, response bertlv.Unmarshaler) error {
-	req, err := request.MarshalBERTLV()
-	if err != nil {
-		return err
-	}
-	bs, err := t.TransmitRaw(req.Bytes())
+// Connect connects the channel without selecting an application.
+// The channel stays connected until the transmitter is closed, whatever the applications selected meanwhile,
+// as some drivers cannot connect again once disconnected.
+func Connect(logger *slog.Logger, channel apdu.SmartCardChannel, MSS int, recovery Recovery) (Selector, error) {
+	t, err := apdu.Connect(channel, MSS)
 	if err != nil {
-		return err
-	}
-	var tlv bertlv.TLV
-	if err := tlv.UnmarshalBinary(bs); err != nil {
-		return err
+		return nil, err
 	}
-	return response.UnmarshalBERTLV(&tlv)
+	recovery.setDefaults()
+	return &transmitter{card: t, channel: channel, recovery: recovery, logger: logger}, nil
+}
+
+func (t *transmitter) Transmit(request bertlv.Marshaler, response bertlv.Unmarshaler) error {
+	return transmit(t.TransmitRaw, request, response)
 }
 
 func (t *transmitter) TransmitRaw(command []byte) ([]byte, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.transmitRaw(command)
+}
+
+// transmitRaw sends the command, returning the failures of the driver as an Error.
+func (t *transmitter) transmitRaw(command []byte) ([]byte, error) {
+	response, err := t.exchange(command)
+	if err != nil && driverFailed(err) {
+		return nil, &Error{Err: err}
+	}
+	return response, err
+}
+
+func (t *transmitter) exchange(command []byte) ([]byte, error) {
 	t.logger.Debug("[APDU] sending", "command", fmt.Sprintf("%X", command))
-	if _, err := t.card.Write(command); err != nil {
+	_, err := t.card.Write(command)
+	for retry := 0; err != nil && retry < t.recovery.Retries && (channelLost(err) || driverFailed(err)); retry++ {
+		if recoverErr := t.recover(err); recoverErr != nil {
+			return nil, errors.Join(err, fmt.Errorf("recover logical channel: %w", recoverErr))
+		}
+		if !channelLost(err) {
+			// The command may have run before the driver failed, sending it again could repeat it.
+			return nil, err
+		}
+		_, err = t.card.Write(command)
+	}
+	if err != nil {
 		return nil, err
 	}
 	bs, err := io.ReadAll(t.card)
@@ -57,6 +115,73 @@ func (t *transmitter) TransmitRaw(command []byte) ([]byte, error) {
 	return bs, err
 }
 
+func (t *transmitter) Select(AID []byte) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.card.Select(AID)
+}
+
+func (t *transmitter) Session() Transmitter {
+	t.mutex.Lock()
+	return &session{transmitter: t}
+}
+
+// Close closes the logical channel, disconnects the card and closes the channel when it is an io.Closer.
 func (t *transmitter) Close() error {
-	return t.card.Close()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err := t.card.Close(); err != nil {
+		return err
+	}
+	if closer, ok := closer(t.channel); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// session is a transmitter holding the card lock.
+type session struct {
+	transmitter *transmitter
+	nested      bool
+	closed      atomic.Bool
+}
+
+func (s *session) Transmit(request bertlv.Marshaler, response bertlv.Unmarshaler) error {
+	return transmit(s.TransmitRaw, request, response)
+}
+
+func (s *session) TransmitRaw(command []byte) ([]byte, error) {
+	if s.closed.Load() {
+		return nil, ErrSessionClosed
+	}
+	return s.transmitter.transmitRaw(command)
+}
+
+// Session returns a nested session sharing the lock of s.
+func (s *session) Session() Transmitter {
+	return &session{transmitter: s.transmitter, nested: true}
+}
+
+func (s *session) Close() error {
+	if s.closed.Swap(true) || s.nested {
+		return nil
+	}
+	s.transmitter.mutex.Unlock()
+	return nil
+}
+
+func transmit(transmitRaw func([]byte) ([]byte, error), request bertlv.Marshaler, response bertlv.Unmarshaler) error {
+	req, err := request.MarshalBERTLV()
+	if err != nil {
+		return err
+	}
+	bs, err := transmitRaw(req.Bytes())
+	if err != nil {
+		return err
+	}
+	var tlv bertlv.TLV
+	if err := tlv.UnmarshalBinary(bs); err != nil {
+		return err
+	}
+	return response.UnmarshalBERTLV(&tlv)
 }
@@ -0,0 +1,43 @@
+package vpcd
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/euicc-go/driver"
+)
+
+// The vpcd driver registers the following schemes, whose port defaults to DefaultPort:
+//
+//	vpcd://:35963?timeout=30s          waits for a virtual card to connect, see Listen
+//	vpcd+dial://localhost:35963        connects to a virtual card in reversed mode, see Dial
+func init() {
+	driver.Register("vpcd", func(u *url.URL) (apdu.SmartCardChannel, error) {
+		opts, err := parseOptions(u)
+		if err != nil {
+			return nil, err
+		}
+		return Listen(defaultAddress(u.Host), opts)
+	})
+	driver.Register("vpcd+dial", func(u *url.URL) (apdu.SmartCardChannel, error) {
+		opts, err := parseOptions(u)
+		if err != nil {
+			return nil, err
+		}
+		return Dial(defaultAddress(u.Host), opts)
+	})
+}
+
+func parseOptions(u *url.URL) (*Options, error) {
+	opts := new(Options)
+	if value := u.Query().Get("timeout"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout %q: %w", value, err)
+		}
+		opts.Timeout = timeout
+	}
+	return opts, nil
+}
@@ -0,0 +1,189 @@
+package vpcd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/damonto/euicc-go/apdu"
+)
+
+// DefaultATR is the answer to reset reported for a channel: T=0 and T=1, with the historical bytes of an eUICC.
+var DefaultATR = []byte{0x3B, 0x9F, 0x96, 0x80, 0x1F, 0x87, 0x80, 0x31, 0xE0, 0x73, 0xFE, 0x21, 0x1B, 0x67, 0x4A, 0x4C, 0x75, 0x30, 0x34, 0x05, 0x4B, 0xA9}
+
+// Status words returned by the server.
+const (
+	swOK                    = 0x9000
+	swWrongLength           = 0x6700
+	swFileNotFound          = 0x6A82
+	swNoLogicalChannelsLeft = 0x6A81
+	swLogicalChannelUnknown = 0x6881
+	swNoPreciseDiagnosis    = 0x6F00
+)
+
+const maxLogicalChannels = 20
+
+type ServerOptions struct {
+	// ATR is the answer to reset reported to the reader. It defaults to DefaultATR.
+	ATR []byte
+}
+
+// Server is the card side of the protocol. It exposes a connected apdu.SmartCardChannel to pcscd through vpcd,
+// so PC/SC applications can use a modem, e.g.
+//
+//	ch, _ := driver.Open("qmi:///dev/cdc-wdm0?slot=1")
+//	ch.Connect()
+//	vpcd.NewServer(ch, nil).DialAndServe("localhost:35963")
+//
+// Drivers do not forward MANAGE CHANNEL, so the server emulates it: a SELECT by DF name on a logical channel opened
+// by the application opens a logical channel of the driver, and the commands sent on that channel are forwarded to it.
+// Commands on the basic channel are forwarded unchanged.
+type Server struct {
+	channel apdu.SmartCardChannel
+	atr     []byte
+	// channels maps the logical channels of the application to those of the driver.
+	// A channel opened by the application without a selected application maps to nil.
+	channels map[byte]*byte
+}
+
+func NewServer(channel apdu.SmartCardChannel, opts *ServerOptions) *Server {
+	if opts == nil {
+		opts = new(ServerOptions)
+	}
+	s := &Server{channel: channel, atr: opts.ATR, channels: make(map[byte]*byte)}
+	if s.atr == nil {
+		s.atr = DefaultATR
+	}
+	return s
+}
+
+// DialAndServe connects to vpcd at address and serves it until vpcd closes the connection.
+// The address defaults to port DefaultPort if it has none.
+func (s *Server) DialAndServe(address string) error {
+	address = defaultAddress(address)
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", address, err)
+	}
+	defer conn.Close()
+	return s.Serve(conn)
+}
+
+// Serve answers the reader on conn until it closes the connection, then closes the logical channels it opened.
+func (s *Server) Serve(conn io.ReadWriter) error {
+	defer s.reset()
+	for {
+		message, err := readMessage(conn)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(message) != 1 {
+			if err := writeMessage(conn, s.transmit(message)); err != nil {
+				return err
+			}
+			continue
+		}
+		switch message[0] {
+		case controlPowerOff, controlReset:
+			s.reset()
+		case controlGetATR:
+			if err := writeMessage(conn, s.atr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// reset closes the logical channels opened by the application.
+func (s *Server) reset() {
+	for virtual := range s.channels {
+		s.close(virtual)
+	}
+}
+
+func (s *Server) transmit(command []byte) []byte {
+	if len(command) < 4 {
+		return status(swWrongLength)
+	}
+	virtual := channelOf(command[0])
+	switch ins, p1, p2 := command[1], command[2], command[3]; {
+	case ins == 0x70 && p1 == 0x00:
+		return s.openChannel(p2)
+	case ins == 0x70 && p1 == 0x80:
+		if _, ok := s.channels[p2]; !ok {
+			return status(swLogicalChannelUnknown)
+		}
+		s.close(p2)
+		return status(swOK)
+	case virtual == 0:
+		return s.forward(command)
+	case ins == 0xA4 && p1 == 0x04:
+		return s.selectApplication(virtual, command)
+	}
+	channel, ok := s.channels[virtual]
+	if !ok || channel == nil {
+		return status(swLogicalChannelUnknown)
+	}
+	forwarded := append([]byte{withChannel(command[0], *channel)}, command[1:]...)
+	return s.forward(forwarded)
+}
+
+// openChannel opens the requested logical channel, or the first free one if requested is 0.
+func (s *Server) openChannel(requested byte) []byte {
+	if requested != 0 {
+		if _, ok := s.channels[requested]; ok || requested >= maxLogicalChannels {
+			return status(swNoLogicalChannelsLeft)
+		}
+		s.channels[requested] = nil
+		return status(swOK)
+	}
+	for virtual := byte(1); virtual < maxLogicalChannels; virtual++ {
+		if _, ok := s.channels[virtual]; !ok {
+			s.channels[virtual] = nil
+			return append([]byte{virtual}, status(swOK)...)
+		}
+	}
+	return status(swNoLogicalChannelsLeft)
+}
+
+func (s *Server) selectApplication(virtual byte, command []byte) []byte {
+	if _, ok := s.channels[virtual]; !ok {
+		return status(swLogicalChannelUnknown)
+	}
+	if len(command) < 5 || len(command) < 5+int(command[4]) {
+		return status(swWrongLength)
+	}
+	s.close(virtual)
+	channel, err := s.channel.OpenLogicalChannel(command[5 : 5+int(command[4])])
+	s.channels[virtual] = nil
+	if err != nil {
+		return status(swFileNotFound)
+	}
+	s.channels[virtual] = &channel
+	return status(swOK)
+}
+
+// close closes the logical channel of the driver mapped to virtual and releases virtual.
+func (s *Server) close(virtual byte) {
+	if channel := s.channels[virtual]; channel != nil {
+		s.channel.CloseLogicalChannel(*channel)
+	}
+	delete(s.channels, virtual)
+}
+
+// forward sends the command to the driver. Drivers return the response along with an error on
+// an unexpected status word, so the response is returned whenever there is one.
+func (s *Server) forward(command []byte) []byte {
+	if response, _ := s.channel.Transmit(command); len(response) >= 2 {
+		return response
+	}
+	return status(swNoPreciseDiagnosis)
+}
+
+func status(sw uint16) []byte {
+	return []byte{byte(sw >> 8), byte(sw)}
+}
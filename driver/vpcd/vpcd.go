@@ -0,0 +1,283 @@
+// Package vpcd implements the socket protocol of vsmartcard's virtual smart card reader (vpcd).
+//
+// VPCD plays the reader and talks to a virtual card (vpicc), e.g. jCardSim or vicc.
+// Server plays the card and exposes any apdu.SmartCardChannel to pcscd through vpcd.
+//
+// Every message is prefixed with its length as a 16-bit big-endian integer.
+// A message of one byte sent by the reader is a control code, any other message is an APDU.
+package vpcd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/damonto/euicc-go/apdu"
+)
+
+// DefaultPort is the port vpcd listens on for virtual cards.
+const DefaultPort = 35963
+
+// Control codes sent by the reader.
+const (
+	controlPowerOff = 0x00
+	controlPowerOn  = 0x01
+	controlReset    = 0x02
+	controlGetATR   = 0x04
+)
+
+var ErrNotConnected = errors.New("vpcd: no card connected")
+
+func readMessage(r io.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func writeMessage(w io.Writer, message []byte) error {
+	if len(message) > 0xFFFF {
+		return fmt.Errorf("message too long: %d bytes", len(message))
+	}
+	b := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(message)), uint16(len(message)))
+	_, err := w.Write(append(b, message...))
+	return err
+}
+
+type Options struct {
+	// Timeout is the time to wait for the card to connect and to answer a command. It defaults to 30 seconds.
+	Timeout time.Duration
+	// TerminalCapability is sent to the card on Connect. It defaults to apdu.DefaultTerminalCapability;
+	// an empty TerminalCapability sends nothing.
+	TerminalCapability *apdu.TerminalCapability
+}
+
+func (opts *Options) setDefaults() {
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if opts.TerminalCapability == nil {
+		capability := apdu.DefaultTerminalCapability
+		opts.TerminalCapability = &capability
+	}
+}
+
+// VPCD is the reader side of the protocol. It implements apdu.SmartCardChannel.
+type VPCD struct {
+	mutex      sync.Mutex
+	open       func() (io.ReadWriteCloser, error)
+	listener   net.Listener
+	conn       io.ReadWriteCloser
+	timeout    time.Duration
+	capability *apdu.TerminalCapability
+	atr        []byte
+}
+
+// Listen waits for a virtual card to connect to address, as vpcd does, e.g. jCardSim in vsmartcard mode.
+// The address is bound immediately and the card is accepted on Connect, so the card may reconnect
+// after Disconnect. Close stops listening.
+func Listen(address string, opts *Options) (*VPCD, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	opts.setDefaults()
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("listen %s: %w", address, err)
+	}
+	v := &VPCD{listener: listener, timeout: opts.Timeout, capability: opts.TerminalCapability}
+	v.open = func() (io.ReadWriteCloser, error) {
+		if l, ok := listener.(*net.TCPListener); ok {
+			l.SetDeadline(time.Now().Add(v.timeout))
+		}
+		return listener.Accept()
+	}
+	return v, nil
+}
+
+// Dial connects to a virtual card listening on address on Connect, as vpcd does in reversed mode, e.g. "vicc --reversed".
+func Dial(address string, opts *Options) (*VPCD, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	opts.setDefaults()
+	v := &VPCD{timeout: opts.Timeout, capability: opts.TerminalCapability}
+	v.open = func() (io.ReadWriteCloser, error) {
+		return net.DialTimeout("tcp", address, v.timeout)
+	}
+	return v, nil
+}
+
+// NewFromConn uses an already open connection to a virtual card. The connection is closed on Disconnect,
+// so the reader connects only once and Connect fails afterwards.
+func NewFromConn(conn io.ReadWriteCloser, opts *Options) *VPCD {
+	if opts == nil {
+		opts = new(Options)
+	}
+	opts.setDefaults()
+	var used bool
+	return &VPCD{
+		open: func() (io.ReadWriteCloser, error) {
+			if used {
+				return nil, errors.New("vpcd: the connection was closed on disconnect")
+			}
+			used = true
+			return conn, nil
+		},
+		timeout:    opts.Timeout,
+		capability: opts.TerminalCapability,
+	}
+}
+
+// Connect waits for the card, powers it on, reads its ATR and sends the terminal capability.
+// A card still connected is disconnected first.
+func (v *VPCD) Connect() error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.disconnect()
+	conn, err := v.open()
+	if err != nil {
+		return fmt.Errorf("connect card: %w", err)
+	}
+	v.conn = conn
+	if err = writeMessage(conn, []byte{controlPowerOn}); err == nil {
+		if v.atr, err = v.exchange([]byte{controlGetATR}); err != nil {
+			err = fmt.Errorf("get ATR: %w", err)
+		} else {
+			err = v.capability.Send(v.exchange)
+		}
+	}
+	if err != nil {
+		v.conn.Close()
+		v.conn, v.atr = nil, nil
+	}
+	return err
+}
+
+// ATR returns the answer to reset of the connected card.
+func (v *VPCD) ATR() []byte {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.atr
+}
+
+func (v *VPCD) Disconnect() error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.disconnect()
+}
+
+// Close disconnects the card and stops listening for virtual cards.
+func (v *VPCD) Close() error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	err := v.disconnect()
+	if v.listener != nil {
+		if closeErr := v.listener.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (v *VPCD) disconnect() error {
+	if v.conn == nil {
+		return nil
+	}
+	writeMessage(v.conn, []byte{controlPowerOff})
+	err := v.conn.Close()
+	v.conn = nil
+	return err
+}
+
+func (v *VPCD) Transmit(command []byte) ([]byte, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	response, err := v.exchange(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(response) < 2 {
+		return nil, fmt.Errorf("invalid response: %X", response)
+	}
+	return response, nil
+}
+
+// exchange sends a message to the card and returns its answer.
+func (v *VPCD) exchange(message []byte) ([]byte, error) {
+	if v.conn == nil {
+		return nil, ErrNotConnected
+	}
+	if conn, ok := v.conn.(interface{ SetDeadline(time.Time) error }); ok {
+		conn.SetDeadline(time.Now().Add(v.timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+	if err := writeMessage(v.conn, message); err != nil {
+		return nil, err
+	}
+	return readMessage(v.conn)
+}
+
+func (v *VPCD) OpenLogicalChannel(AID []byte) (byte, error) {
+	response, err := v.Transmit([]byte{0x00, 0x70, 0x00, 0x00, 0x01})
+	if err != nil {
+		return 0, err
+	}
+	if r := apdu.Response(response); !r.OK() || len(r.Data()) != 1 {
+		return 0, fmt.Errorf("open logical channel: %X", response)
+	}
+	channel := response[0]
+	response, err = v.Transmit(append([]byte{withChannel(0x00, channel), 0xA4, 0x04, 0x00, byte(len(AID))}, AID...))
+	if err != nil {
+		return 0, err
+	}
+	if r := apdu.Response(response); !r.OK() && !r.HasMore() {
+		v.CloseLogicalChannel(channel)
+		return 0, fmt.Errorf("select AID: %X", response)
+	}
+	return channel, nil
+}
+
+func (v *VPCD) CloseLogicalChannel(channel byte) error {
+	response, err := v.Transmit([]byte{0x00, 0x70, 0x80, channel, 0x00})
+	if err != nil {
+		return err
+	}
+	if r := apdu.Response(response); !r.OK() {
+		return fmt.Errorf("close logical channel: %X", response)
+	}
+	return nil
+}
+
+// channelOf returns the logical channel encoded in the class byte (ISO/IEC 7816-4, Section 5.4.1).
+func channelOf(cla byte) byte {
+	if cla&0x40 == 0 {
+		return cla & 0x03
+	}
+	return cla&0x0F + 4
+}
+
+// withChannel encodes the logical channel in the class byte.
+func withChannel(cla, channel byte) byte {
+	if channel < 4 {
+		return cla&0x9C | channel
+	}
+	return cla&0xB0 | 0x40 | (channel - 4)
+}
+
+func defaultAddress(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(DefaultPort))
+}
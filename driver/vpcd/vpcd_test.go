@@ -0,0 +1,86 @@
+package vpcd
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/damonto/euicc-go/driver/simulator"
+	"github.com/damonto/euicc-go/lpa"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelOf(t *testing.T) {
+	for channel := byte(0); channel < maxLogicalChannels; channel++ {
+		assert.Equal(t, channel, channelOf(withChannel(0x80, channel)))
+	}
+	assert.Equal(t, byte(0x81), withChannel(0x80, 1))
+	assert.Equal(t, byte(0xC0), withChannel(0x80, 4))
+}
+
+func TestServer(t *testing.T) {
+	card, err := simulator.New(nil)
+	assert.NoError(t, err)
+	assert.NoError(t, card.Connect())
+	reader, cardSide := net.Pipe()
+	served := make(chan error, 1)
+	go func() { served <- NewServer(card, nil).Serve(cardSide) }()
+
+	v := NewFromConn(reader, &Options{Timeout: time.Second})
+	client, err := lpa.New(&lpa.Options{
+		Channel: v,
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultATR, v.ATR())
+	eid, err := client.EID()
+	assert.NoError(t, err)
+	assert.Len(t, eid, 16)
+
+	// A command on a logical channel that was never opened is rejected by the server.
+	response, err := v.Transmit([]byte{0x83, 0xE2, 0x91, 0x00, 0x03, 0xBF, 0x3E, 0x00})
+	assert.NoError(t, err)
+	assert.Equal(t, status(swLogicalChannelUnknown), response)
+
+	assert.NoError(t, client.Close())
+	assert.NoError(t, <-served)
+	assert.Error(t, v.Connect())
+}
+
+func TestListen(t *testing.T) {
+	card, err := simulator.New(nil)
+	assert.NoError(t, err)
+	assert.NoError(t, card.Connect())
+	v, err := Listen("127.0.0.1:0", &Options{Timeout: time.Second})
+	assert.NoError(t, err)
+	address := v.listener.Addr().String()
+	insert := func() {
+		conn, err := net.Dial("tcp", address)
+		assert.NoError(t, err)
+		go NewServer(card, nil).Serve(conn)
+	}
+
+	// The card may reconnect after Disconnect, and Connect replaces the card still connected.
+	for range 2 {
+		insert()
+		assert.NoError(t, v.Connect())
+		assert.Equal(t, DefaultATR, v.ATR())
+	}
+	assert.NoError(t, v.Disconnect())
+	insert()
+	assert.NoError(t, v.Connect())
+
+	// A card leaving before its ATR is read leaves the reader disconnected.
+	conn, err := net.Dial("tcp", address)
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Close())
+	assert.Error(t, v.Connect())
+	_, err = v.Transmit([]byte{0x00, 0xA4, 0x04, 0x00, 0x00})
+	assert.ErrorIs(t, err, ErrNotConnected)
+
+	assert.NoError(t, v.Close())
+	_, err = net.Dial("tcp", address)
+	assert.Error(t, err)
+}
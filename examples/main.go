@@ -7,7 +7,15 @@ import (
 	"log/slog"
 	"net/url"
 
-	"github.com/damonto/euicc-go/driver/localnet"
+	"github.com/damonto/euicc-go/driver"
+	_ "github.com/damonto/euicc-go/driver/at"
+	_ "github.com/damonto/euicc-go/driver/ccid"
+	_ "github.com/damonto/euicc-go/driver/localnet"
+	_ "github.com/damonto/euicc-go/driver/mbim"
+	_ "github.com/damonto/euicc-go/driver/pcsc"
+	_ "github.com/damonto/euicc-go/driver/qmi"
+	_ "github.com/damonto/euicc-go/driver/simulator"
+	_ "github.com/damonto/euicc-go/driver/vpcd"
 	"github.com/damonto/euicc-go/lpa"
 	sgp22 "github.com/damonto/euicc-go/v2"
 )
@@ -15,33 +23,16 @@ import (
 func main() {
 	slog.SetLogLoggerLevel(slog.LevelDebug)
 
-	// ch, err := mbim.New("/dev/cdc-wdm0", 1)
-	// if err != nil {
-	// 	panic(err)
-	// }
-	// ch, err := qmi.New("/dev/cdc-wdm0", 1)
-	ch, err := localnet.NewUDP("192.168.11.100:8080", "/dev/cdc-wdm0", "qrtr", 2, 2048)
-	//ch, err := qmi.NewQRTR(2)
+	// Any driver URI of an imported driver package, e.g.
+	// "mbim:///dev/cdc-wdm0?slot=1&mode=direct", "qmi:///dev/cdc-wdm0?slot=1", "qrtr:?slot=2",
+	// "qrtr:?slot=2&node=0&instance=1", "at:///dev/ttyUSB7", "at+rfc2217://192.168.1.1:2001",
+	// "ccid:?reader=(?i)omnikey|acs", "pcsc:?reader=(?i)omnikey|acs" without libpcsclite,
+	// "vpcd://:35963" for jCardSim, "simulator:", or "localnet+tcp://192.168.11.100:8080/dev/cdc-wdm0?proto=qmi"
+	// for a server started with -transport tcp.
+	ch, err := driver.Open("localnet://192.168.11.100:8080/dev/cdc-wdm0?proto=qrtr&slot=2&buffer=2048")
 	if err != nil {
 		panic(err)
 	}
-	// ch, err := at.New("/dev/ttyUSB7")
-	// if err != nil {
-	// 	panic(err)
-	// }
-	// ch, err := ccid.New()
-	// if err != nil {
-	// 	panic(err)
-	// }
-	// reader, err := ch.ListReaders()
-	// if err != nil {
-	// 	panic(err)
-	// }
-	// if len(reader) == 0 {
-	// 	panic("No readers found")
-	// }
-	// fmt.Printf("Using reader: %s\n", reader[0])
-	// ch.SetReader(reader[0])
 
 	client, err := lpa.New(&lpa.Options{
 		Channel: ch,
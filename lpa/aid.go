@@ -0,0 +1,59 @@
+package lpa
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/euicc-go/driver"
+	sgp22 "github.com/damonto/euicc-go/v2"
+)
+
+// ISDRApplication is an ISD-R application AID and the vendor using it.
+type ISDRApplication struct {
+	Vendor string
+	AID    []byte
+}
+
+// ISDRApplications are the known ISD-R applications, tried in order when probing.
+// Append to it, or set Options.Applications, to probe additional AIDs.
+var ISDRApplications = []ISDRApplication{
+	{Vendor: "GSMA", AID: GSMAISDRApplicationAID},
+	{Vendor: "5ber", AID: []byte{0xA0, 0x00, 0x00, 0x05, 0x59, 0x10, 0x10, 0xFF, 0xFF, 0xFF, 0xFF, 0x89, 0x00, 0x05, 0x05, 0x00}},
+	{Vendor: "ESTKme", AID: []byte{0xA0, 0x65, 0x73, 0x74, 0x6B, 0x6D, 0x65, 0xFF, 0xFF, 0xFF, 0xFF, 0x49, 0x53, 0x44, 0x2D, 0x52}},
+	{Vendor: "9eSIM", AID: []byte{0xA0, 0x00, 0x00, 0x05, 0x59, 0x10, 0x10, 0xFF, 0xFF, 0xFF, 0xFF, 0x89, 0x00, 0x00, 0x01, 0x77}},
+}
+
+// lookupApplication returns the application with the given AID, or an application without vendor.
+func lookupApplication(applications []ISDRApplication, AID []byte) ISDRApplication {
+	for _, application := range applications {
+		if bytes.Equal(application.AID, AID) {
+			return application
+		}
+	}
+	return ISDRApplication{AID: AID}
+}
+
+// probe opens the first application that answers GetEuiccData.
+// The channel is connected once, only the logical channel is closed between the applications.
+func probe(logger *slog.Logger, channel apdu.SmartCardChannel, applications []ISDRApplication, MSS int, recovery driver.Recovery) (driver.Transmitter, ISDRApplication, error) {
+	transmitter, err := driver.Connect(logger, channel, MSS, recovery)
+	if err != nil {
+		return nil, ISDRApplication{}, err
+	}
+	var errs []error
+	for _, application := range applications {
+		err := transmitter.Select(application.AID)
+		if err == nil {
+			if _, err = sgp22.InvokeAPDU(transmitter, new(sgp22.GetEuiccDataRequest)); err == nil {
+				logger.Debug("[LPA] ISD-R application found", "vendor", application.Vendor, "aid", fmt.Sprintf("%X", application.AID))
+				return transmitter, application, nil
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s (%X): %w", application.Vendor, application.AID, err))
+	}
+	_ = transmitter.Close()
+	return nil, ISDRApplication{}, fmt.Errorf("no ISD-R application found: %w", errors.Join(errs...))
+}
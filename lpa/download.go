@@ -109,6 +109,8 @@ type DownloadOptions struct {
 }
 
 // DownloadProfile downloads a profile using the provided activation code and options.
+// The eUICC is only locked while a group of ES10 commands runs, such as the segments of the bound profile package,
+// so other goroutines may use the client during the requests to the SM-DP+ and the callbacks of opts.
 func (c *Client) DownloadProfile(ctx context.Context, ac *ActivationCode, opts *DownloadOptions) (*sgp22.LoadBoundProfilePackageResponse, error) {
 	if err := ac.validate(); err != nil {
 		return nil, err
@@ -177,14 +179,16 @@ func (c *Client) install(bppResponse *sgp22.ES9BoundProfilePackageResponse) (*sg
 		return nil, err
 	}
 	var r []byte
-	for _, command := range segments {
-		r, err = sgp22.InvokeRawAPDU(c.APDU, command)
-		if err != nil {
-			return nil, err
-		}
-		if len(r) > 0 {
-			break
+	err = c.Session(func(c *Client) error {
+		for _, command := range segments {
+			if r, err = sgp22.InvokeRawAPDU(c.APDU, command); err != nil || len(r) > 0 {
+				return err
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	var tlv bertlv.TLV
 	if err := tlv.UnmarshalBinary(r); err != nil {
@@ -10,12 +10,15 @@ import (
 //
 // See https://aka.pw/sgp22/v2.5#page=170 (Section 5.6.1, ES9p.InitiateAuthentication)
 func (c *Client) InitiateAuthentication(address *url.URL) (*sgp22.ES9InitiateAuthenticationResponse, error) {
-	var err error
 	request := sgp22.ES9InitiateAuthenticationRequest{Address: address.Host}
-	if request.Challenge, err = c.EUICCChallenge(); err != nil {
-		return nil, err
-	}
-	if request.Info1, err = c.EUICCInfo1(); err != nil {
+	err := c.Session(func(c *Client) (err error) {
+		if request.Challenge, err = c.EUICCChallenge(); err != nil {
+			return err
+		}
+		request.Info1, err = c.EUICCInfo1()
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
 	return sgp22.InvokeHTTP(c.HTTP, address, &request)
@@ -9,6 +9,7 @@ import (
 
 	"github.com/damonto/euicc-go/apdu"
 	"github.com/damonto/euicc-go/driver"
+	"github.com/damonto/euicc-go/driver/gsmtap"
 	"github.com/damonto/euicc-go/http"
 	sgp22 "github.com/damonto/euicc-go/v2"
 )
@@ -23,6 +24,7 @@ type Client struct {
 	APDU sgp22.Transmitter
 
 	transmitter driver.Transmitter
+	application ISDRApplication
 }
 
 // Options is the configuration for the LPA client.
@@ -32,6 +34,11 @@ type Options struct {
 	Channel apdu.SmartCardChannel
 	// AID is the application identifier for the GSMA ISD-R application. It defaults to GSMA ISD-R Application AID.
 	AID []byte
+	// Probe tries the Applications in order and keeps the first one that answers GetEuiccData. AID is ignored when it is set.
+	// It defaults to false.
+	Probe bool
+	// Applications are the ISD-R applications tried when probing. It defaults to ISDRApplications.
+	Applications []ISDRApplication
 	// MSS is the maximum APDU size. It defaults to 254.
 	MSS int
 	// AdminProtocolVersion is the version of the admin protocol. It defaults to "2.5.0".
@@ -42,6 +49,12 @@ type Options struct {
 	Timeout time.Duration
 	// Proxy for the HTTP client. It defaults to "" (unused).
 	InternalProxy string
+	// Recovery restores the logical channel when it is lost, e.g. after the refresh triggered by EnableProfile
+	// or a modem reset, see driver.Recovery. It defaults to disabled, callers opt in by setting Recovery.Retries.
+	Recovery driver.Recovery
+	// Capture receives a copy of every APDU exchanged with the card, e.g. a pcap file or a GSMTAP UDP sink.
+	// It is closed together with the client, or by New when it fails. It defaults to nil (disabled).
+	Capture gsmtap.Writer
 }
 
 func (opts *Options) validateAdminProtocolVersion() error {
@@ -80,6 +93,9 @@ func (opts *Options) setDefaults() {
 	if opts.AID == nil {
 		opts.AID = GSMAISDRApplicationAID
 	}
+	if opts.Applications == nil {
+		opts.Applications = ISDRApplications
+	}
 	if opts.MSS == 0 {
 		opts.MSS = 254
 	}
@@ -107,7 +123,20 @@ func New(opts *Options) (*Client, error) {
 	if err := opts.Normalize(); err != nil {
 		return nil, err
 	}
-	if c.transmitter, err = driver.NewTransmitter(opts.Logger, opts.Channel, opts.AID, opts.MSS); err != nil {
+	channel := opts.Channel
+	if opts.Capture != nil {
+		channel = gsmtap.NewChannel(channel, opts.Capture)
+	}
+	if opts.Probe {
+		c.transmitter, c.application, err = probe(opts.Logger, channel, opts.Applications, opts.MSS, opts.Recovery)
+	} else {
+		c.application = lookupApplication(opts.Applications, opts.AID)
+		c.transmitter, err = driver.NewTransmitter(opts.Logger, channel, opts.AID, opts.MSS, opts.Recovery)
+	}
+	if err != nil {
+		if opts.Capture != nil {
+			_ = opts.Capture.Close()
+		}
 		return nil, err
 	}
 	c.APDU = c.transmitter
@@ -140,3 +169,20 @@ func New(opts *Options) (*Client, error) {
 func (c *Client) Close() error {
 	return c.transmitter.Close()
 }
+
+// Application returns the ISD-R application in use. Its vendor is empty if the AID is not a known one.
+func (c *Client) Application() ISDRApplication {
+	return c.application
+}
+
+// Session runs fn with exclusive access to the eUICC.
+// Commands sent through c by other goroutines wait until fn returns,
+// so a sequence of commands such as the segments of a bound profile package is never interleaved with them.
+// The eUICC cannot be used by the other goroutines meanwhile, so fn should not wait for the network or the user.
+// The client passed to fn shares the HTTP client of c and must not be used after fn returns.
+// Calling Session on that client runs fn in the same session.
+func (c *Client) Session(fn func(c *Client) error) error {
+	session := c.transmitter.Session()
+	defer session.Close()
+	return fn(&Client{HTTP: c.HTTP, APDU: session, transmitter: session, application: c.application})
+}
@@ -0,0 +1,114 @@
+package rest
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/damonto/euicc-go/lpa"
+	sgp22 "github.com/damonto/euicc-go/v2"
+)
+
+func (s *Server) chipInfo(w http.ResponseWriter, r *http.Request, m *modem, client *lpa.Client) {
+	var info ChipInfo
+	eid, err := client.EID()
+	if err != nil {
+		s.fail(w, m, client, err)
+		return
+	}
+	info.EID = hexString(eid)
+	info.Vendor = client.Application().Vendor
+	addresses, err := client.EUICCConfiguredAddresses()
+	if err != nil {
+		s.fail(w, m, client, err)
+		return
+	}
+	info.DefaultSMDPAddress, info.RootSMDSAddress = addresses.DefaultSMDPAddress, addresses.RootSMDSAddress
+	euiccInfo2, err := client.EUICCInfo2()
+	if err != nil {
+		s.fail(w, m, client, err)
+		return
+	}
+	info.EUICCInfo2 = euiccInfo2.Bytes()
+	s.json(w, http.StatusOK, info)
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request, m *modem, client *lpa.Client) {
+	var request DiscoveryRequest
+	if err := decode(r, &request); err != nil {
+		s.error(w, http.StatusBadRequest, err)
+		return
+	}
+	imei, err := m.discoveryIMEI(&request)
+	if err != nil {
+		s.error(w, http.StatusBadRequest, err)
+		return
+	}
+	events, err := discover(client, request.Address, imei)
+	if err != nil {
+		s.fail(w, m, client, err)
+		return
+	}
+	s.json(w, http.StatusOK, events)
+}
+
+// discoveryIMEI validates the SM-DS address of a discovery request and returns its IMEI, or nil if it has none.
+func (m *modem) discoveryIMEI(request *DiscoveryRequest) (sgp22.IMEI, error) {
+	if request.Address != "" {
+		if _, err := serverURL(request.Address); err != nil {
+			return nil, fmt.Errorf("invalid SM-DS address %q: %w", request.Address, err)
+		}
+	}
+	if request.IMEI = cmp.Or(request.IMEI, m.IMEI); request.IMEI == "" {
+		return nil, nil
+	}
+	imei, err := sgp22.NewIMEI(request.IMEI)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IMEI %q: %w", request.IMEI, err)
+	}
+	return imei, nil
+}
+
+// discover returns the events of the SM-DS, the root SM-DS of the eUICC if the address is empty.
+func discover(client *lpa.Client, address string, imei sgp22.IMEI) ([]Event, error) {
+	if address == "" {
+		addresses, err := client.EUICCConfiguredAddresses()
+		if err != nil {
+			return nil, err
+		}
+		address = addresses.RootSMDSAddress
+	}
+	u, err := serverURL(address)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := client.Discovery(u, imei)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0, len(entries))
+	for _, entry := range entries {
+		ac := lpa.ActivationCode{SMDP: &url.URL{Scheme: "https", Host: entry.Address}, MatchingID: entry.EventID}
+		text, _ := ac.MarshalText()
+		events = append(events, Event{EventID: entry.EventID, Address: entry.Address, ActivationCode: string(text)})
+	}
+	return events, nil
+}
+
+// serverURL returns the URL of an SM-DP+ or SM-DS given by its address, e.g. "smds.example.com", or URL.
+func serverURL(address string) (*url.URL, error) {
+	if !strings.Contains(address, "://") {
+		address = "https://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, errors.New("server address is required")
+	}
+	return u, nil
+}
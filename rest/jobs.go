@@ -0,0 +1,424 @@
+package rest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/damonto/euicc-go/lpa"
+	sgp22 "github.com/damonto/euicc-go/v2"
+)
+
+var errNotWaiting = errors.New("job is not waiting for a decision")
+
+// jobs keeps the running jobs, and the finished ones until the retention time elapsed.
+type jobs struct {
+	mutex sync.Mutex
+	jobs  map[string]*job
+	// retention is the time a finished job is kept.
+	retention time.Duration
+	// decisionTimeout is the time a job waits for a decision before rejecting the profile.
+	decisionTimeout time.Duration
+	// running counts the goroutines of the jobs using an LPA client, including the detached SM-DS requests.
+	running sync.WaitGroup
+}
+
+type job struct {
+	mutex sync.Mutex
+	Job
+	cancel context.CancelFunc
+	// decisions receives the decision of the client. It has room for one, sent when the job leaves its waiting state.
+	decisions chan JobDecision
+	// changed are the channels of the event streams, notified of each change.
+	changed map[chan struct{}]struct{}
+}
+
+func (js *jobs) add(j *job) {
+	js.mutex.Lock()
+	defer js.mutex.Unlock()
+	js.jobs[j.ID] = j
+}
+
+func (js *jobs) get(id string) *job {
+	js.mutex.Lock()
+	defer js.mutex.Unlock()
+	return js.jobs[id]
+}
+
+func (js *jobs) remove(id string) {
+	js.mutex.Lock()
+	defer js.mutex.Unlock()
+	delete(js.jobs, id)
+}
+
+// cancel cancels the running jobs, e.g. when the server is closed.
+func (js *jobs) cancel() {
+	js.mutex.Lock()
+	defer js.mutex.Unlock()
+	for _, j := range js.jobs {
+		j.cancel()
+	}
+}
+
+// wait waits at most timeout for the goroutines of the jobs to return. It reports whether they did.
+func (js *jobs) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		js.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// start runs the job in the background. The error returned by run fails the job,
+// otherwise the job succeeds unless run already set a final state.
+func (js *jobs) start(m *modem, jobType JobType, run func(ctx context.Context, j *job) error) *job {
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+	j := &job{
+		Job:       Job{ID: newJobID(), Type: jobType, Modem: m.Name, State: JobRunning, Created: now, Updated: now},
+		cancel:    cancel,
+		decisions: make(chan JobDecision, 1),
+		changed:   make(map[chan struct{}]struct{}),
+	}
+	js.add(j)
+	js.running.Add(1)
+	go func() {
+		defer js.running.Done()
+		defer cancel()
+		err := run(ctx, j)
+		j.update(func(job *Job) {
+			switch {
+			case ctx.Err() != nil && !job.State.Finished():
+				job.State = JobCanceled
+			case err != nil:
+				job.State, job.Error = JobFailed, err.Error()
+			case !job.State.Finished():
+				job.State = JobSucceeded
+			}
+		})
+		time.AfterFunc(js.retention, func() { js.remove(j.ID) })
+	}()
+	return j
+}
+
+// update changes the job and notifies the event streams.
+func (j *job) update(fn func(job *Job)) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	fn(&j.Job)
+	j.touch()
+}
+
+// touch records a change of the job. The caller holds the mutex.
+func (j *job) touch() {
+	j.Updated = time.Now()
+	for changed := range j.changed {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (j *job) snapshot() Job {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.Job
+}
+
+// subscribe returns a channel notified of the next changes of the job, which coalesces the changes
+// not received yet.
+func (j *job) subscribe() chan struct{} {
+	changed := make(chan struct{}, 1)
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.changed[changed] = struct{}{}
+	return changed
+}
+
+func (j *job) unsubscribe(changed chan struct{}) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	delete(j.changed, changed)
+}
+
+// wait sets the waiting state and returns the decision of the client.
+// It returns false if the job is canceled or the client did not decide in time.
+func (j *job) wait(ctx context.Context, state JobState, timeout time.Duration) (JobDecision, bool) {
+	j.update(func(job *Job) { job.State = state })
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case decision := <-j.decisions:
+		return decision, true
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	// The decision may have arrived together with the timeout.
+	select {
+	case decision := <-j.decisions:
+		return decision, true
+	default:
+	}
+	j.State = JobRunning
+	j.touch()
+	return JobDecision{}, false
+}
+
+// decide gives the decision of the client to the job waiting for it.
+func (j *job) decide(decision JobDecision) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	switch j.State {
+	case JobWaitingConfirmation:
+	case JobWaitingConfirmationCode:
+		if decision.Accept && decision.ConfirmationCode == "" {
+			return errors.New("confirmation code is required")
+		}
+	default:
+		return errNotWaiting
+	}
+	j.State = JobRunning
+	j.touch()
+	j.decisions <- decision
+	return nil
+}
+
+// downloadJob starts a download job.
+func (s *Server) downloadJob(w http.ResponseWriter, r *http.Request, m *modem, client *lpa.Client) {
+	var request DownloadJobRequest
+	if err := decode(r, &request); err != nil {
+		s.error(w, http.StatusBadRequest, err)
+		return
+	}
+	ac, err := activationCode(&request.DownloadRequest, m)
+	if err != nil {
+		s.error(w, http.StatusBadRequest, err)
+		return
+	}
+	j := s.jobs.start(m, JobDownload, func(ctx context.Context, j *job) error {
+		err := s.runDownload(ctx, j, client, ac, request.AutoConfirm)
+		m.failed(client, err)
+		return err
+	})
+	s.json(w, http.StatusAccepted, j.snapshot())
+}
+
+// runDownload downloads the profile, asking the client for the confirmation and the confirmation code.
+// The eUICC is only locked around the ES10 commands of the download, so the other requests to the modem
+// are served while the job waits for the decisions.
+func (s *Server) runDownload(ctx context.Context, j *job, client *lpa.Client, ac *lpa.ActivationCode, autoConfirm bool) error {
+	var metadata *sgp22.ProfileInfo
+	var rejected bool
+	response, err := client.DownloadProfile(ctx, ac, &lpa.DownloadOptions{
+		OnProgress: func(stage lpa.DownloadStage) {
+			j.update(func(job *Job) { job.Stage = downloadStage(stage) })
+		},
+		OnConfirm: func(profile *sgp22.ProfileInfo) bool {
+			metadata = profile
+			p := newProfile(profile)
+			j.update(func(job *Job) { job.Profile = &p })
+			if autoConfirm {
+				return true
+			}
+			decision, ok := j.wait(ctx, JobWaitingConfirmation, s.jobs.decisionTimeout)
+			if ac.ConfirmationCode == "" {
+				ac.ConfirmationCode = decision.ConfirmationCode
+			}
+			rejected = !ok || !decision.Accept
+			return !rejected
+		},
+		OnEnterConfirmationCode: func() string {
+			decision, ok := j.wait(ctx, JobWaitingConfirmationCode, s.jobs.decisionTimeout)
+			if rejected = !ok || !decision.Accept; rejected {
+				return ""
+			}
+			return decision.ConfirmationCode
+		},
+	})
+	if rejected && ctx.Err() == nil {
+		j.update(func(job *Job) {
+			if job.State = JobRejected; err != nil {
+				job.Error = err.Error()
+			}
+		})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if response == nil {
+		return ctx.Err()
+	}
+	result := downloadResult(metadata, response)
+	j.update(func(job *Job) { job.Download = &result })
+	return nil
+}
+
+// discoveryJob starts a discovery job.
+func (s *Server) discoveryJob(w http.ResponseWriter, r *http.Request, m *modem, client *lpa.Client) {
+	var request DiscoveryRequest
+	if err := decode(r, &request); err != nil {
+		s.error(w, http.StatusBadRequest, err)
+		return
+	}
+	imei, err := m.discoveryIMEI(&request)
+	if err != nil {
+		s.error(w, http.StatusBadRequest, err)
+		return
+	}
+	j := s.jobs.start(m, JobDiscovery, func(ctx context.Context, j *job) error {
+		// The SM-DS requests cannot be canceled, a canceled job stops waiting for them.
+		type result struct {
+			events []Event
+			err    error
+		}
+		done := make(chan result, 1)
+		s.jobs.running.Add(1)
+		go func() {
+			defer s.jobs.running.Done()
+			events, err := discover(client, request.Address, imei)
+			done <- result{events, err}
+		}()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case r := <-done:
+			if r.err != nil {
+				m.failed(client, r.err)
+				return r.err
+			}
+			j.update(func(job *Job) { job.Discovery = &DiscoveryResult{Events: r.events} })
+			return nil
+		}
+	})
+	s.json(w, http.StatusAccepted, j.snapshot())
+}
+
+func (s *Server) listJobs(w http.ResponseWriter, r *http.Request) {
+	modem := r.URL.Query().Get("modem")
+	s.jobs.mutex.Lock()
+	list := make([]Job, 0, len(s.jobs.jobs))
+	for _, j := range s.jobs.jobs {
+		if job := j.snapshot(); modem == "" || job.Modem == modem {
+			list = append(list, job)
+		}
+	}
+	s.jobs.mutex.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	s.json(w, http.StatusOK, list)
+}
+
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	j := s.jobs.get(r.PathValue("job"))
+	if j == nil {
+		s.error(w, http.StatusNotFound, fmt.Errorf("unknown job %q", r.PathValue("job")))
+		return
+	}
+	s.json(w, http.StatusOK, j.snapshot())
+}
+
+// cancelJob cancels a running job, or forgets a finished one.
+func (s *Server) cancelJob(w http.ResponseWriter, r *http.Request) {
+	j := s.jobs.get(r.PathValue("job"))
+	if j == nil {
+		s.error(w, http.StatusNotFound, fmt.Errorf("unknown job %q", r.PathValue("job")))
+		return
+	}
+	if job := j.snapshot(); job.State.Finished() {
+		s.jobs.remove(job.ID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	j.cancel()
+	s.json(w, http.StatusAccepted, j.snapshot())
+}
+
+func (s *Server) decideJob(w http.ResponseWriter, r *http.Request) {
+	j := s.jobs.get(r.PathValue("job"))
+	if j == nil {
+		s.error(w, http.StatusNotFound, fmt.Errorf("unknown job %q", r.PathValue("job")))
+		return
+	}
+	var decision JobDecision
+	if err := decode(r, &decision); err != nil {
+		s.error(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := j.decide(decision); errors.Is(err, errNotWaiting) {
+		s.error(w, http.StatusConflict, err)
+		return
+	} else if err != nil {
+		s.error(w, http.StatusBadRequest, err)
+		return
+	}
+	s.json(w, http.StatusOK, j.snapshot())
+}
+
+// jobEvents streams the job as Server-Sent Events until it is finished.
+// Each event is the job, named after its state; the changes made between two events are coalesced.
+func (s *Server) jobEvents(w http.ResponseWriter, r *http.Request) {
+	j := s.jobs.get(r.PathValue("job"))
+	if j == nil {
+		s.error(w, http.StatusNotFound, fmt.Errorf("unknown job %q", r.PathValue("job")))
+		return
+	}
+	changed := j.subscribe()
+	defer j.unsubscribe(changed)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	controller := http.NewResponseController(w)
+	for {
+		job := j.snapshot()
+		data, _ := json.Marshal(job)
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", job.State, data); err != nil {
+			return
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+		if job.State.Finished() {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func downloadStage(stage lpa.DownloadStage) string {
+	switch stage {
+	case lpa.DownloadStageAuthenticateClient:
+		return "authenticateClient"
+	case lpa.DownloadStageAuthenticateServer:
+		return "authenticateServer"
+	case lpa.DownloadStageInstall:
+		return "install"
+	}
+	return "unknown"
+}
+
+func newJobID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
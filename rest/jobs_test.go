@@ -0,0 +1,168 @@
+package rest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/damonto/euicc-go/driver/simulator"
+	sgp22 "github.com/damonto/euicc-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobs(t *testing.T) {
+	_, server := newServer(t)
+	s := server.Config.Handler.(*Server)
+	m := s.modems["sim"]
+
+	j := s.jobs.start(m, JobDownload, func(ctx context.Context, j *job) error {
+		decision, ok := j.wait(ctx, JobWaitingConfirmation, time.Minute)
+		if !ok || !decision.Accept {
+			j.update(func(job *Job) { job.State = JobRejected })
+			return nil
+		}
+		decision, ok = j.wait(ctx, JobWaitingConfirmationCode, time.Minute)
+		assert.True(t, ok)
+		assert.Equal(t, "1234", decision.ConfirmationCode)
+		return nil
+	})
+
+	response, err := http.Get(server.URL + "/jobs/" + j.ID + "/events")
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+	events := bufio.NewScanner(response.Body)
+	next := func(state JobState) Job {
+		for events.Scan() {
+			if event, ok := strings.CutPrefix(events.Text(), "event: "); ok && JobState(event) == state {
+				events.Scan()
+				var job Job
+				assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(events.Text(), "data: ")), &job))
+				return job
+			}
+		}
+		t.Fatalf("no %s event", state)
+		return Job{}
+	}
+
+	assert.Equal(t, j.ID, next(JobWaitingConfirmation).ID)
+	var e Error
+	assert.Equal(t, http.StatusOK, request(t, server, http.MethodPost, "/jobs/"+j.ID+"/decision", `{"accept": true}`, nil))
+	next(JobWaitingConfirmationCode)
+	assert.Equal(t, http.StatusBadRequest, request(t, server, http.MethodPost, "/jobs/"+j.ID+"/decision", `{"accept": true}`, &e))
+	assert.Equal(t, "confirmation code is required", e.Error)
+	assert.Equal(t, http.StatusOK, request(t, server, http.MethodPost, "/jobs/"+j.ID+"/decision", `{"accept": true, "confirmationCode": "1234"}`, nil))
+	assert.Equal(t, JobSucceeded, next(JobSucceeded).State)
+	// The stream ends with the job.
+	for events.Scan() {
+		assert.Empty(t, events.Text())
+	}
+
+	assert.Equal(t, http.StatusConflict, request(t, server, http.MethodPost, "/jobs/"+j.ID+"/decision", `{"accept": true}`, &e))
+	var jobs []Job
+	assert.Equal(t, http.StatusOK, request(t, server, http.MethodGet, "/jobs?modem=sim", "", &jobs))
+	assert.Len(t, jobs, 1)
+	assert.Equal(t, http.StatusNoContent, request(t, server, http.MethodDelete, "/jobs/"+j.ID, "", nil))
+	assert.Equal(t, http.StatusNotFound, request(t, server, http.MethodGet, "/jobs/"+j.ID, "", &e))
+}
+
+func TestJobs_Cancel(t *testing.T) {
+	_, server := newServer(t)
+	s := server.Config.Handler.(*Server)
+	s.jobs.retention = 0
+
+	done := make(chan struct{})
+	j := s.jobs.start(s.modems["sim"], JobDownload, func(ctx context.Context, j *job) error {
+		defer close(done)
+		_, ok := j.wait(ctx, JobWaitingConfirmation, time.Minute)
+		assert.False(t, ok)
+		return nil
+	})
+	for j.snapshot().State != JobWaitingConfirmation {
+		time.Sleep(time.Millisecond)
+	}
+	var job Job
+	assert.Equal(t, http.StatusAccepted, request(t, server, http.MethodDelete, "/jobs/"+j.ID, "", &job))
+	<-done
+	assert.Eventually(t, func() bool { return s.jobs.get(j.ID) == nil }, time.Second, time.Millisecond)
+	assert.Equal(t, JobCanceled, j.snapshot().State)
+}
+
+func TestJobs_Timeout(t *testing.T) {
+	_, server := newServer(t)
+	s := server.Config.Handler.(*Server)
+	j := s.jobs.start(s.modems["sim"], JobDownload, func(ctx context.Context, j *job) error {
+		_, ok := j.wait(ctx, JobWaitingConfirmation, time.Millisecond)
+		assert.False(t, ok)
+		return nil
+	})
+	assert.Eventually(t, func() bool { return j.snapshot().State.Finished() }, time.Second, time.Millisecond)
+
+	var e Error
+	assert.Equal(t, http.StatusBadRequest, request(t, server, http.MethodPost, "/modems/sim/jobs/download", `{"activationCode": "LPA:1$smdp.example.com$TEST"}`, &e))
+	assert.Equal(t, "IMEI is required", e.Error)
+}
+
+func TestJobs_Download(t *testing.T) {
+	card, server := newServer(t)
+	s := server.Config.Handler.(*Server)
+	dp, err := simulator.NewSMDP(card.Certificates())
+	assert.NoError(t, err)
+	iccid, _ := sgp22.NewICCID("8944476500001224216")
+	dp.AddProfile("MATCHING", simulator.Profile{ICCID: iccid, ServiceProviderName: "Test", ProfileName: "Downloaded"}, "1234")
+	smdp := httptest.NewTLSServer(dp)
+	defer smdp.Close()
+	client, err := s.modems["sim"].open()
+	assert.NoError(t, err)
+	client.HTTP.Client = smdp.Client()
+
+	var job Job
+	body := `{"activationCode": "LPA:1$` + smdp.Listener.Addr().String() + `$MATCHING", "imei": "356938035643809"}`
+	assert.Equal(t, http.StatusAccepted, request(t, server, http.MethodPost, "/modems/sim/jobs/download", body, &job))
+	waiting := func(state JobState) {
+		assert.Eventually(t, func() bool { return s.jobs.get(job.ID).snapshot().State == state }, 5*time.Second, time.Millisecond)
+	}
+
+	waiting(JobWaitingConfirmation)
+	// The modem serves the other requests while the job waits for the decision.
+	var profiles []Profile
+	assert.Equal(t, http.StatusOK, request(t, server, http.MethodGet, "/modems/sim/profiles", "", &profiles))
+	assert.Empty(t, profiles)
+	assert.Equal(t, http.StatusOK, request(t, server, http.MethodPost, "/jobs/"+job.ID+"/decision", `{"accept": true}`, nil))
+	waiting(JobWaitingConfirmationCode)
+	assert.Equal(t, http.StatusOK, request(t, server, http.MethodPost, "/jobs/"+job.ID+"/decision", `{"accept": true, "confirmationCode": "1234"}`, nil))
+	waiting(JobSucceeded)
+
+	assert.Equal(t, http.StatusOK, request(t, server, http.MethodGet, "/jobs/"+job.ID, "", &job))
+	assert.Equal(t, "install", job.Stage)
+	assert.Equal(t, iccid.String(), job.Download.Profile.ICCID)
+	assert.Equal(t, "Downloaded", job.Download.Profile.ProfileName)
+	assert.Equal(t, http.StatusOK, request(t, server, http.MethodGet, "/modems/sim/profiles", "", &profiles))
+	assert.Len(t, profiles, 1)
+}
+
+func TestJobs_CancelDiscovery(t *testing.T) {
+	_, server := newServer(t)
+	s := server.Config.Handler.(*Server)
+	// The SM-DS never answers the TLS handshake.
+	smds, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer smds.Close()
+
+	var job Job
+	body := `{"address": "` + smds.Addr().String() + `", "imei": "356938035643809"}`
+	assert.Equal(t, http.StatusAccepted, request(t, server, http.MethodPost, "/modems/sim/jobs/discovery", body, &job))
+	assert.Equal(t, http.StatusAccepted, request(t, server, http.MethodDelete, "/jobs/"+job.ID, "", nil))
+	assert.Eventually(t, func() bool { return s.jobs.get(job.ID).snapshot().State == JobCanceled }, time.Second, time.Millisecond)
+
+	// Close waits for the SM-DS request still using the LPA client.
+	assert.False(t, s.jobs.wait(50*time.Millisecond))
+	smds.Close()
+	assert.True(t, s.jobs.wait(time.Second))
+}
@@ -0,0 +1,75 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/damonto/euicc-go/lpa"
+	sgp22 "github.com/damonto/euicc-go/v2"
+)
+
+func (s *Server) listNotifications(w http.ResponseWriter, r *http.Request, m *modem, client *lpa.Client) {
+	notifications, err := client.ListNotification()
+	if err != nil {
+		s.fail(w, m, client, err)
+		return
+	}
+	response := make([]Notification, 0, len(notifications))
+	for _, notification := range notifications {
+		response = append(response, newNotification(notification))
+	}
+	s.json(w, http.StatusOK, response)
+}
+
+// sendNotification sends the notification to its SM-DP+, then removes it from the eUICC unless "remove=false".
+func (s *Server) sendNotification(w http.ResponseWriter, r *http.Request, m *modem, client *lpa.Client) {
+	sequence, err := sequenceNumber(r)
+	if err != nil {
+		s.error(w, http.StatusBadRequest, err)
+		return
+	}
+	remove := r.URL.Query().Get("remove") != "false"
+	err = client.Session(func(client *lpa.Client) error {
+		notifications, err := client.RetrieveNotificationList(sequence)
+		if err != nil {
+			return err
+		}
+		if len(notifications) == 0 {
+			return sgp22.ErrNotificationNotFound
+		}
+		if err := client.HandleNotification(notifications[0]); err != nil {
+			return err
+		}
+		if remove {
+			return client.RemoveNotificationFromList(sequence)
+		}
+		return nil
+	})
+	if err != nil {
+		s.fail(w, m, client, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) removeNotification(w http.ResponseWriter, r *http.Request, m *modem, client *lpa.Client) {
+	sequence, err := sequenceNumber(r)
+	if err != nil {
+		s.error(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := client.RemoveNotificationFromList(sequence); err != nil {
+		s.fail(w, m, client, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func sequenceNumber(r *http.Request) (sgp22.SequenceNumber, error) {
+	sequence, err := strconv.ParseInt(r.PathValue("sequence"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid sequence number %q", r.PathValue("sequence"))
+	}
+	return sgp22.SequenceNumber(sequence), nil
+}
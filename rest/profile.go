@@ -0,0 +1,139 @@
+package rest
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/damonto/euicc-go/lpa"
+	sgp22 "github.com/damonto/euicc-go/v2"
+)
+
+func (s *Server) listProfiles(w http.ResponseWriter, r *http.Request, m *modem, client *lpa.Client) {
+	profiles, err := client.ListProfile(nil, nil)
+	if err != nil {
+		s.fail(w, m, client, err)
+		return
+	}
+	response := make([]Profile, 0, len(profiles))
+	for _, profile := range profiles {
+		response = append(response, newProfile(profile))
+	}
+	s.json(w, http.StatusOK, response)
+}
+
+func (s *Server) renameProfile(w http.ResponseWriter, r *http.Request, m *modem, client *lpa.Client) {
+	iccid, err := sgp22.NewICCID(r.PathValue("iccid"))
+	if err != nil {
+		s.error(w, http.StatusBadRequest, fmt.Errorf("invalid ICCID %q: %w", r.PathValue("iccid"), err))
+		return
+	}
+	var request ProfileUpdate
+	if err := decode(r, &request); err != nil {
+		s.error(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := client.SetNickname(iccid, request.Nickname); err != nil {
+		s.fail(w, m, client, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) deleteProfile(w http.ResponseWriter, r *http.Request, m *modem, client *lpa.Client) {
+	iccid, err := sgp22.NewICCID(r.PathValue("iccid"))
+	if err != nil {
+		s.error(w, http.StatusBadRequest, fmt.Errorf("invalid ICCID %q: %w", r.PathValue("iccid"), err))
+		return
+	}
+	if err := client.DeleteProfile(iccid); err != nil {
+		s.fail(w, m, client, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) enableProfile(w http.ResponseWriter, r *http.Request, m *modem, client *lpa.Client) {
+	s.setProfile(w, r, m, client, client.EnableProfile)
+}
+
+func (s *Server) disableProfile(w http.ResponseWriter, r *http.Request, m *modem, client *lpa.Client) {
+	s.setProfile(w, r, m, client, client.DisableProfile)
+}
+
+func (s *Server) setProfile(w http.ResponseWriter, r *http.Request, m *modem, client *lpa.Client, operation func(identifier any, refresh bool) error) {
+	iccid, err := sgp22.NewICCID(r.PathValue("iccid"))
+	if err != nil {
+		s.error(w, http.StatusBadRequest, fmt.Errorf("invalid ICCID %q: %w", r.PathValue("iccid"), err))
+		return
+	}
+	var request ProfileOperation
+	if err := decode(r, &request); err != nil {
+		s.error(w, http.StatusBadRequest, err)
+		return
+	}
+	refresh := request.Refresh == nil || *request.Refresh
+	if err := operation(iccid, refresh); err != nil {
+		s.fail(w, m, client, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// download installs the profile of the activation code. The profile is accepted without confirmation.
+func (s *Server) download(w http.ResponseWriter, r *http.Request, m *modem, client *lpa.Client) {
+	var request DownloadRequest
+	if err := decode(r, &request); err != nil {
+		s.error(w, http.StatusBadRequest, err)
+		return
+	}
+	ac, err := activationCode(&request, m)
+	if err != nil {
+		s.error(w, http.StatusBadRequest, err)
+		return
+	}
+	var metadata *sgp22.ProfileInfo
+	response, err := client.DownloadProfile(r.Context(), ac, &lpa.DownloadOptions{
+		OnConfirm: func(profile *sgp22.ProfileInfo) bool {
+			metadata = profile
+			return true
+		},
+	})
+	if err != nil {
+		s.fail(w, m, client, err)
+		return
+	}
+	s.json(w, http.StatusCreated, downloadResult(metadata, response))
+}
+
+// activationCode returns the activation code of a download request.
+func activationCode(request *DownloadRequest, m *modem) (*lpa.ActivationCode, error) {
+	var ac lpa.ActivationCode
+	if err := ac.UnmarshalText([]byte(request.ActivationCode)); err != nil {
+		return nil, fmt.Errorf("invalid activation code: %w", err)
+	}
+	ac.ConfirmationCode = request.ConfirmationCode
+	if ac.IMEI = cmp.Or(request.IMEI, m.IMEI); ac.IMEI == "" {
+		return nil, errors.New("IMEI is required")
+	}
+	return &ac, nil
+}
+
+func downloadResult(metadata *sgp22.ProfileInfo, response *sgp22.LoadBoundProfilePackageResponse) DownloadResult {
+	var result DownloadResult
+	if metadata != nil {
+		result.Profile = newProfile(metadata)
+	}
+	if response != nil {
+		result.Profile.ISDPAID = response.ISDPAID().String()
+		result.Profile.State = profileState(sgp22.ProfileDisabled)
+		if response.Notification != nil {
+			result.Notification = int64(response.Notification.SequenceNumber)
+			if result.Profile.ICCID == "" && len(response.Notification.ICCID) > 0 {
+				result.Profile.ICCID = response.Notification.ICCID.String()
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,241 @@
+// Package rest serves an HTTP/JSON API over lpa.Client, so clients manage the eUICC of a modem
+// without implementing the LPA. The API is described by the OpenAPI document served at "/openapi.yaml".
+package rest
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/damonto/euicc-go/driver"
+	"github.com/damonto/euicc-go/lpa"
+	sgp22 "github.com/damonto/euicc-go/v2"
+)
+
+//go:embed openapi.yaml
+var openAPI []byte
+
+// Modem is a modem whose eUICC is managed through the API.
+type Modem struct {
+	// Name identifies the modem in the paths, e.g. "/modems/{name}/profiles".
+	Name string
+	// Open creates the LPA client of the modem. It is called by the first request,
+	// and again by the next request if it failed.
+	Open func() (*lpa.Client, error)
+	// IMEI is sent to the SM-DP+ and SM-DS servers when the request does not have one.
+	// It defaults to "" (required in the download requests).
+	IMEI string
+}
+
+// Options configures the Server.
+type Options struct {
+	// Logger is the logger of the server. It defaults to slog.Default().
+	Logger *slog.Logger
+	// JobRetention is the time a finished job is kept for the clients to get its result. It defaults to 1 hour.
+	JobRetention time.Duration
+	// DecisionTimeout is the time a download job waits for the confirmation or the confirmation code
+	// before rejecting the profile. It defaults to 2 minutes.
+	DecisionTimeout time.Duration
+}
+
+func (opts *Options) setDefaults() {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.JobRetention == 0 {
+		opts.JobRetention = time.Hour
+	}
+	if opts.DecisionTimeout == 0 {
+		opts.DecisionTimeout = 2 * time.Minute
+	}
+}
+
+// Server is an http.Handler serving the API for a set of modems.
+type Server struct {
+	logger *slog.Logger
+	mux    *http.ServeMux
+	modems map[string]*modem
+	jobs   *jobs
+}
+
+type modem struct {
+	Modem
+	mutex  sync.Mutex
+	client *lpa.Client
+}
+
+// New returns a Server managing the modems.
+func New(modems []Modem, opts *Options) (*Server, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	opts.setDefaults()
+	s := &Server{
+		logger: opts.Logger,
+		mux:    http.NewServeMux(),
+		modems: make(map[string]*modem, len(modems)),
+		jobs:   &jobs{jobs: make(map[string]*job), retention: opts.JobRetention, decisionTimeout: opts.DecisionTimeout},
+	}
+	for _, m := range modems {
+		if m.Name == "" || m.Open == nil {
+			return nil, errors.New("modem name and open function are required")
+		}
+		if _, ok := s.modems[m.Name]; ok {
+			return nil, fmt.Errorf("duplicate modem %q", m.Name)
+		}
+		s.modems[m.Name] = &modem{Modem: m}
+	}
+
+	s.mux.HandleFunc("GET /openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(openAPI)
+	})
+	s.mux.HandleFunc("GET /modems", s.listModems)
+	s.handle("GET /modems/{modem}", s.chipInfo)
+	s.handle("GET /modems/{modem}/profiles", s.listProfiles)
+	s.handle("PATCH /modems/{modem}/profiles/{iccid}", s.renameProfile)
+	s.handle("DELETE /modems/{modem}/profiles/{iccid}", s.deleteProfile)
+	s.handle("POST /modems/{modem}/profiles/{iccid}/enable", s.enableProfile)
+	s.handle("POST /modems/{modem}/profiles/{iccid}/disable", s.disableProfile)
+	s.handle("POST /modems/{modem}/downloads", s.download)
+	s.handle("POST /modems/{modem}/discovery", s.discovery)
+	s.handle("GET /modems/{modem}/notifications", s.listNotifications)
+	s.handle("POST /modems/{modem}/notifications/{sequence}/send", s.sendNotification)
+	s.handle("DELETE /modems/{modem}/notifications/{sequence}", s.removeNotification)
+	s.handle("POST /modems/{modem}/jobs/download", s.downloadJob)
+	s.handle("POST /modems/{modem}/jobs/discovery", s.discoveryJob)
+	s.mux.HandleFunc("GET /jobs", s.listJobs)
+	s.mux.HandleFunc("GET /jobs/{job}", s.getJob)
+	s.mux.HandleFunc("DELETE /jobs/{job}", s.cancelJob)
+	s.mux.HandleFunc("POST /jobs/{job}/decision", s.decideJob)
+	s.mux.HandleFunc("GET /jobs/{job}/events", s.jobEvents)
+	return s, nil
+}
+
+// ServeHTTP implements http.Handler. The requests are logged at the debug level.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.logger.Debug("[HTTP] request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+	s.mux.ServeHTTP(w, r)
+}
+
+// closeTimeout is the time Close waits for the jobs to return before closing the LPA clients.
+const closeTimeout = 10 * time.Second
+
+// Close cancels the running jobs and closes the LPA clients opened by the server,
+// once the jobs returned or after 10 seconds.
+func (s *Server) Close() error {
+	s.jobs.cancel()
+	if !s.jobs.wait(closeTimeout) {
+		s.logger.Warn("closing the modems with jobs still running")
+	}
+	var errs []error
+	for _, m := range s.modems {
+		m.mutex.Lock()
+		if m.client != nil {
+			errs = append(errs, m.client.Close())
+			m.client = nil
+		}
+		m.mutex.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// handle registers a handler of the requests to a modem, which gets the LPA client of the modem.
+func (s *Server) handle(pattern string, handler func(w http.ResponseWriter, r *http.Request, m *modem, client *lpa.Client)) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		m, ok := s.modems[r.PathValue("modem")]
+		if !ok {
+			s.error(w, http.StatusNotFound, fmt.Errorf("unknown modem %q", r.PathValue("modem")))
+			return
+		}
+		client, err := m.open()
+		if err != nil {
+			s.error(w, http.StatusServiceUnavailable, fmt.Errorf("open modem %s: %w", m.Name, err))
+			return
+		}
+		handler(w, r, m, client)
+	})
+}
+
+func (m *modem) open() (*lpa.Client, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.client == nil {
+		client, err := m.Open()
+		if err != nil {
+			return nil, err
+		}
+		m.client = client
+	}
+	return m.client, nil
+}
+
+// failed closes the client of the modem when its driver failed, e.g. because the modem was replugged,
+// so the next request opens the modem again.
+func (m *modem) failed(client *lpa.Client, err error) {
+	var driverErr *driver.Error
+	if !errors.As(err, &driverErr) {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.client == client {
+		_ = client.Close()
+		m.client = nil
+	}
+}
+
+func (s *Server) listModems(w http.ResponseWriter, r *http.Request) {
+	modems := make([]ModemInfo, 0, len(s.modems))
+	for name := range s.modems {
+		modems = append(modems, ModemInfo{Name: name})
+	}
+	sort.Slice(modems, func(i, j int) bool { return modems[i].Name < modems[j].Name })
+	s.json(w, http.StatusOK, modems)
+}
+
+// json writes the value as the JSON response.
+func (s *Server) json(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		s.logger.Debug("write response", "error", err)
+	}
+}
+
+// error writes the error as the JSON response.
+func (s *Server) error(w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		s.logger.Error("request failed", "error", err)
+	}
+	s.json(w, status, Error{Error: err.Error()})
+}
+
+// fail writes the error of an LPA function of the client of the modem, with the status matching the error.
+func (s *Server) fail(w http.ResponseWriter, m *modem, client *lpa.Client, err error) {
+	m.failed(client, err)
+	switch {
+	case errors.Is(err, sgp22.ErrICCIDNotFound), errors.Is(err, sgp22.ErrProfileNotFound),
+		errors.Is(err, sgp22.ErrNotificationNotFound), errors.Is(err, sgp22.ErrNothingToDelete):
+		s.error(w, http.StatusNotFound, err)
+	case errors.Is(err, sgp22.ErrCatBusy):
+		s.error(w, http.StatusConflict, err)
+	default:
+		s.error(w, http.StatusBadGateway, err)
+	}
+}
+
+// decode reads the JSON request body into value. An empty body leaves value unchanged.
+func decode(r *http.Request, value any) error {
+	if err := json.NewDecoder(r.Body).Decode(value); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
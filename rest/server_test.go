@@ -0,0 +1,143 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/damonto/euicc-go/driver/simulator"
+	"github.com/damonto/euicc-go/lpa"
+	sgp22 "github.com/damonto/euicc-go/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newServer(t *testing.T) (*simulator.Simulator, *httptest.Server) {
+	s, err := simulator.New(&simulator.Options{DefaultSMDPAddress: "smdp.example.com"})
+	assert.NoError(t, err)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	server, err := New([]Modem{{Name: "sim", Open: func() (*lpa.Client, error) {
+		return lpa.New(&lpa.Options{Channel: s, Logger: logger})
+	}}}, &Options{Logger: logger})
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = server.Close() })
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	return s, httpServer
+}
+
+func request(t *testing.T, server *httptest.Server, method, path, body string, response any) int {
+	r, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	assert.NoError(t, err)
+	resp, err := http.DefaultClient.Do(r)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	if response != nil {
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(response))
+	}
+	return resp.StatusCode
+}
+
+func TestServer(t *testing.T) {
+	s, server := newServer(t)
+	first, _ := sgp22.NewICCID("8944476500001224158")
+	second, _ := sgp22.NewICCID("8944476500001224166")
+	assert.NoError(t, s.AddProfile(simulator.Profile{ICCID: first, ProfileName: "First", ServiceProviderName: "Test"}))
+	assert.NoError(t, s.AddProfile(simulator.Profile{
+		ICCID:       second,
+		ProfileName: "Second",
+		State:       sgp22.ProfileEnabled,
+		NotificationConfigurationInfo: sgp22.NotificationConfigurationInfo{
+			{ProfileManagementOperation: sgp22.NotificationEventDelete, Address: "smdp.example.com"},
+		},
+	}))
+
+	var modems []ModemInfo
+	assert.Equal(t, http.StatusOK, request(t, server, http.MethodGet, "/modems", "", &modems))
+	assert.Equal(t, []ModemInfo{{Name: "sim"}}, modems)
+
+	var info ChipInfo
+	assert.Equal(t, http.StatusOK, request(t, server, http.MethodGet, "/modems/sim", "", &info))
+	assert.Equal(t, "89049032123451234512345678901224", info.EID)
+	assert.Equal(t, "smdp.example.com", info.DefaultSMDPAddress)
+	assert.NotEmpty(t, info.EUICCInfo2)
+
+	var profiles []Profile
+	assert.Equal(t, http.StatusOK, request(t, server, http.MethodGet, "/modems/sim/profiles", "", &profiles))
+	assert.Len(t, profiles, 2)
+	assert.Equal(t, "8944476500001224158", profiles[0].ICCID)
+	assert.Equal(t, "disabled", profiles[0].State)
+	assert.Equal(t, "enabled", profiles[1].State)
+
+	assert.Equal(t, http.StatusNoContent, request(t, server, http.MethodPost, "/modems/sim/profiles/8944476500001224158/enable", `{"refresh": false}`, nil))
+	assert.Equal(t, http.StatusNoContent, request(t, server, http.MethodPatch, "/modems/sim/profiles/8944476500001224158", `{"nickname": "Travel"}`, nil))
+	assert.Equal(t, http.StatusNoContent, request(t, server, http.MethodDelete, "/modems/sim/profiles/8944476500001224166", "", nil))
+	installed := s.Profiles()
+	assert.Len(t, installed, 1)
+	assert.Equal(t, sgp22.ProfileEnabled, installed[0].State)
+	assert.Equal(t, "Travel", installed[0].Nickname)
+
+	var notifications []Notification
+	assert.Equal(t, http.StatusOK, request(t, server, http.MethodGet, "/modems/sim/notifications", "", &notifications))
+	assert.Len(t, notifications, 1)
+	assert.Equal(t, "delete", notifications[0].Operation)
+	assert.Equal(t, "8944476500001224166", notifications[0].ICCID)
+	path := "/modems/sim/notifications/" + strconv.FormatInt(notifications[0].SequenceNumber, 10)
+	assert.Equal(t, http.StatusNoContent, request(t, server, http.MethodDelete, path, "", nil))
+	assert.Empty(t, s.Notifications())
+}
+
+func TestServer_Errors(t *testing.T) {
+	_, server := newServer(t)
+	var e Error
+	assert.Equal(t, http.StatusNotFound, request(t, server, http.MethodGet, "/modems/unknown/profiles", "", &e))
+	assert.Equal(t, `unknown modem "unknown"`, e.Error)
+	assert.Equal(t, http.StatusBadRequest, request(t, server, http.MethodPost, "/modems/sim/profiles/89X/enable", "", &e))
+	assert.Equal(t, http.StatusBadRequest, request(t, server, http.MethodPost, "/modems/sim/downloads", `{"activationCode": "LPA:1$smdp.example.com$TEST"}`, &e))
+	assert.Equal(t, "IMEI is required", e.Error)
+	assert.Equal(t, http.StatusNotFound, request(t, server, http.MethodDelete, "/modems/sim/profiles/8944476500001224158", "", &e))
+}
+
+// unplugged is a channel whose driver fails while the modem is unplugged.
+type unplugged struct {
+	*simulator.Simulator
+	unplugged bool
+}
+
+func (u *unplugged) Transmit(command []byte) ([]byte, error) {
+	if u.unplugged {
+		return nil, errors.New("modem unplugged")
+	}
+	return u.Simulator.Transmit(command)
+}
+
+func TestServer_ReopenAfterDriverFailure(t *testing.T) {
+	s, err := simulator.New(nil)
+	assert.NoError(t, err)
+	channel := &unplugged{Simulator: s}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var opened int
+	server, err := New([]Modem{{Name: "sim", Open: func() (*lpa.Client, error) {
+		opened++
+		return lpa.New(&lpa.Options{Channel: channel, Logger: logger})
+	}}}, &Options{Logger: logger})
+	assert.NoError(t, err)
+	defer server.Close()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	assert.Equal(t, http.StatusOK, request(t, httpServer, http.MethodGet, "/modems/sim/profiles", "", nil))
+	channel.unplugged = true
+	var e Error
+	assert.Equal(t, http.StatusBadGateway, request(t, httpServer, http.MethodGet, "/modems/sim/profiles", "", &e))
+	assert.Equal(t, "modem unplugged", e.Error)
+	// The modem is opened again once plugged back.
+	channel.unplugged = false
+	assert.Equal(t, http.StatusOK, request(t, httpServer, http.MethodGet, "/modems/sim/profiles", "", nil))
+	assert.Equal(t, 2, opened)
+}
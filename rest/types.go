@@ -0,0 +1,225 @@
+package rest
+
+import (
+	"encoding/hex"
+	"strings"
+	"time"
+
+	sgp22 "github.com/damonto/euicc-go/v2"
+)
+
+// Error is the body of the responses of failed requests.
+type Error struct {
+	Error string `json:"error"`
+}
+
+// ModemInfo is a modem managed by the server.
+type ModemInfo struct {
+	Name string `json:"name"`
+}
+
+// ChipInfo is the information of the eUICC of a modem.
+type ChipInfo struct {
+	EID string `json:"eid"`
+	// Vendor is the vendor of the ISD-R application, empty if it is not a known one.
+	Vendor             string `json:"vendor,omitempty"`
+	DefaultSMDPAddress string `json:"defaultSmdpAddress"`
+	RootSMDSAddress    string `json:"rootSmdsAddress"`
+	// EUICCInfo2 is the DER encoding of EUICCInfo2, in base64.
+	EUICCInfo2 []byte `json:"euiccInfo2"`
+}
+
+// Profile is a profile installed on the eUICC, or the metadata of a downloaded profile.
+type Profile struct {
+	ICCID               string    `json:"iccid"`
+	ISDPAID             string    `json:"isdpAid,omitempty"`
+	State               string    `json:"state"`
+	Nickname            string    `json:"nickname,omitempty"`
+	ServiceProviderName string    `json:"serviceProviderName"`
+	ProfileName         string    `json:"profileName"`
+	Class               string    `json:"class"`
+	IconType            string    `json:"iconType,omitempty"`
+	Icon                []byte    `json:"icon,omitempty"`
+	Owner               *Operator `json:"owner,omitempty"`
+}
+
+// Operator is the owner of a profile.
+type Operator struct {
+	MCC string `json:"mcc"`
+	MNC string `json:"mnc"`
+}
+
+// ProfileUpdate is the body of the requests changing a profile.
+type ProfileUpdate struct {
+	Nickname string `json:"nickname"`
+}
+
+// ProfileOperation is the body of the requests enabling or disabling a profile.
+type ProfileOperation struct {
+	// Refresh asks the eUICC to refresh the modem after the operation. It defaults to true.
+	Refresh *bool `json:"refresh,omitempty"`
+}
+
+// DownloadRequest is the body of the requests downloading a profile.
+type DownloadRequest struct {
+	// ActivationCode is the activation code, e.g. "LPA:1$smdp.example.com$MATCHING-ID".
+	ActivationCode   string `json:"activationCode"`
+	ConfirmationCode string `json:"confirmationCode,omitempty"`
+	// IMEI defaults to the IMEI of the modem.
+	IMEI string `json:"imei,omitempty"`
+}
+
+// DownloadResult is the profile installed by a download.
+type DownloadResult struct {
+	Profile Profile `json:"profile"`
+	// Notification is the sequence number of the install notification, to be sent to the SM-DP+.
+	Notification int64 `json:"notification"`
+}
+
+// DiscoveryRequest is the body of the SM-DS discovery requests.
+type DiscoveryRequest struct {
+	// Address is the SM-DS. It defaults to the root SM-DS of the eUICC.
+	Address string `json:"address,omitempty"`
+	// IMEI defaults to the IMEI of the modem.
+	IMEI string `json:"imei,omitempty"`
+}
+
+// Event is a profile download offered by an SM-DS.
+type Event struct {
+	EventID string `json:"eventId"`
+	Address string `json:"address"`
+	// ActivationCode downloads the profile of the event.
+	ActivationCode string `json:"activationCode"`
+}
+
+// Notification is a pending notification of the eUICC.
+type Notification struct {
+	SequenceNumber int64  `json:"sequenceNumber"`
+	Operation      string `json:"operation"`
+	Address        string `json:"address"`
+	ICCID          string `json:"iccid,omitempty"`
+}
+
+// JobType is the operation of a job.
+type JobType string
+
+const (
+	JobDownload  JobType = "download"
+	JobDiscovery JobType = "discovery"
+)
+
+// JobState is the state of a job.
+type JobState string
+
+const (
+	JobRunning JobState = "running"
+	// JobWaitingConfirmation waits for the client to accept or reject the profile, see JobDecision.
+	JobWaitingConfirmation JobState = "waitingConfirmation"
+	// JobWaitingConfirmationCode waits for the client to give the confirmation code of the profile.
+	JobWaitingConfirmationCode JobState = "waitingConfirmationCode"
+	JobSucceeded               JobState = "succeeded"
+	JobFailed                  JobState = "failed"
+	// JobRejected is a download rejected by the client, or not decided in time.
+	JobRejected JobState = "rejected"
+	JobCanceled JobState = "canceled"
+)
+
+// Finished reports whether the job ended.
+func (s JobState) Finished() bool {
+	return s != JobRunning && s != JobWaitingConfirmation && s != JobWaitingConfirmationCode
+}
+
+// Job is a long-running operation on the eUICC of a modem.
+type Job struct {
+	ID    string   `json:"id"`
+	Type  JobType  `json:"type"`
+	Modem string   `json:"modem"`
+	State JobState `json:"state"`
+	// Stage is the stage of a download: authenticateClient, authenticateServer or install.
+	Stage string `json:"stage,omitempty"`
+	// Profile is the metadata of the downloaded profile, known once the SM-DP+ authenticated the eUICC.
+	Profile   *Profile         `json:"profile,omitempty"`
+	Download  *DownloadResult  `json:"download,omitempty"`
+	Discovery *DiscoveryResult `json:"discovery,omitempty"`
+	Error     string           `json:"error,omitempty"`
+	Created   time.Time        `json:"created"`
+	Updated   time.Time        `json:"updated"`
+}
+
+// DownloadJobRequest is the body of the requests starting a download job.
+type DownloadJobRequest struct {
+	DownloadRequest
+	// AutoConfirm accepts the profile without waiting for a JobDecision.
+	AutoConfirm bool `json:"autoConfirm,omitempty"`
+}
+
+// DiscoveryResult is the result of a discovery job.
+type DiscoveryResult struct {
+	Events []Event `json:"events"`
+}
+
+// JobDecision is the body of the requests answering a job waiting for a decision.
+// The confirmation code may be given with the confirmation, it is then not asked for.
+type JobDecision struct {
+	Accept           bool   `json:"accept"`
+	ConfirmationCode string `json:"confirmationCode,omitempty"`
+}
+
+func newProfile(p *sgp22.ProfileInfo) Profile {
+	profile := Profile{
+		ICCID:               p.ICCID.String(),
+		ISDPAID:             p.ISDPAID.String(),
+		State:               profileState(p.ProfileState),
+		Nickname:            p.ProfileNickname,
+		ServiceProviderName: p.ServiceProviderName,
+		ProfileName:         p.ProfileName,
+		Class:               p.ProfileClass.String(),
+	}
+	if p.Icon.Valid() {
+		profile.IconType, profile.Icon = p.Icon.FileType(), p.Icon
+	}
+	if len(p.ProfileOwner.PLMN) == 3 {
+		profile.Owner = &Operator{MCC: p.ProfileOwner.MCC(), MNC: p.ProfileOwner.MNC()}
+	}
+	return profile
+}
+
+func profileState(state sgp22.ProfileState) string {
+	switch state {
+	case sgp22.ProfileEnabled:
+		return "enabled"
+	case sgp22.ProfileDisabled:
+		return "disabled"
+	}
+	return "unknown"
+}
+
+func newNotification(n *sgp22.NotificationMetadata) Notification {
+	notification := Notification{
+		SequenceNumber: int64(n.SequenceNumber),
+		Operation:      notificationOperation(n.ProfileManagementOperation),
+		Address:        n.Address,
+	}
+	if len(n.ICCID) > 0 {
+		notification.ICCID = n.ICCID.String()
+	}
+	return notification
+}
+
+func notificationOperation(event sgp22.NotificationEvent) string {
+	switch event {
+	case sgp22.NotificationEventInstall:
+		return "install"
+	case sgp22.NotificationEventEnable:
+		return "enable"
+	case sgp22.NotificationEventDisable:
+		return "disable"
+	case sgp22.NotificationEventDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+func hexString(b []byte) string {
+	return strings.ToUpper(hex.EncodeToString(b))
+}
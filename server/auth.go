@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/damonto/euicc-go/driver/localnet"
+)
+
+// role is what a client may do with the eUICC.
+type role int
+
+const (
+	// roleRead only reads the eUICC: profiles, notifications, EID and eUICC information.
+	roleRead role = iota + 1
+	// roleManage also downloads, enables, disables and deletes profiles.
+	roleManage
+)
+
+func parseRole(name string) (role, error) {
+	switch name {
+	case "read":
+		return roleRead, nil
+	case "manage":
+		return roleManage, nil
+	}
+	return 0, fmt.Errorf("unknown role %q (read or manage)", name)
+}
+
+func (r role) String() string {
+	if r == roleRead {
+		return "read"
+	}
+	return "manage"
+}
+
+// readTags are the tags of the ES10 requests that do not change the eUICC.
+var readTags = map[uint16]bool{
+	0xBF20: true, // GetEuiccInfo1
+	0xBF22: true, // GetEuiccInfo2
+	0xBF28: true, // ListNotification
+	0xBF2B: true, // RetrieveNotificationsList
+	0xBF2D: true, // ProfileInfoList
+	0xBF2E: true, // GetEuiccChallenge
+	0xBF3C: true, // EuiccConfiguredAddresses
+	0xBF3E: true, // GetEuiccData
+	0xBF43: true, // GetRAT
+}
+
+// client is a remote client and what it is allowed to do.
+type client struct {
+	name string
+	role role
+}
+
+// authorizer authenticates the clients and decides which devices they may use.
+type authorizer struct {
+	// devices are the patterns of the allowed devices.
+	devices []string
+	// roles are the roles of the clients by certificate common name, all clients manage if nil.
+	roles map[string]role
+	// anonymous is the role of the clients without a verified certificate, 0 rejects them.
+	anonymous role
+}
+
+// loadRoles reads a JSON object mapping the certificate common names to their role, e.g. {"lpa": "manage", "monitor": "read"}.
+func loadRoles(name string) (map[string]role, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var names map[string]string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", name, err)
+	}
+	roles := make(map[string]role, len(names))
+	for client, name := range names {
+		if roles[client], err = parseRole(name); err != nil {
+			return nil, fmt.Errorf("client %s: %w", client, err)
+		}
+	}
+	return roles, nil
+}
+
+// serverTLS returns the TLS configuration of the server, requiring a client certificate signed by ca when it is set.
+func serverTLS(cert, key, ca string) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("load certificate: %w", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}
+	if ca == "" {
+		return config, nil
+	}
+	pem, err := os.ReadFile(ca)
+	if err != nil {
+		return nil, err
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", ca)
+	}
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
+
+// authenticate returns the client of a connection.
+// The client of a TLS connection with a verified certificate is named after its common name.
+func (a *authorizer) authenticate(conn net.Conn) (*client, error) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, fmt.Errorf("TLS handshake: %w", err)
+		}
+		if chains := tlsConn.ConnectionState().VerifiedChains; len(chains) > 0 {
+			name := chains[0][0].Subject.CommonName
+			if a.roles == nil {
+				return &client{name: name, role: roleManage}, nil
+			}
+			if r, ok := a.roles[name]; ok {
+				return &client{name: name, role: r}, nil
+			}
+			return nil, fmt.Errorf("client %s is not authorized", name)
+		}
+	}
+	return a.anonymousClient(conn.RemoteAddr())
+}
+
+func (a *authorizer) anonymousClient(address net.Addr) (*client, error) {
+	if a.anonymous == 0 {
+		return nil, fmt.Errorf("client %s has no certificate", address)
+	}
+	return &client{name: address.String(), role: a.anonymous}, nil
+}
+
+// allows reports whether the device of a driver URI is allowed.
+// The patterns match "scheme:path", e.g. "qmi:/dev/cdc-wdm0", "mbim:/dev/cdc-wdm*" or "qrtr:".
+func (a *authorizer) allows(uri string) error {
+	device, err := deviceName(uri)
+	if err != nil {
+		return err
+	}
+	for _, pattern := range a.devices {
+		if matched, _ := path.Match(pattern, device); matched {
+			return nil
+		}
+	}
+	return &localnet.ServerError{Code: localnet.ErrorDeviceNotAllowed, Message: "device " + device + " is not allowed"}
+}
+
+// splitList splits a comma-separated flag value.
+func splitList(value string) []string {
+	var items []string
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/damonto/euicc-go/driver/localnet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorizer_Allows(t *testing.T) {
+	a := &authorizer{devices: []string{"qmi:/dev/cdc-wdm*", "at:COM3", "qrtr:", "ccid:"}}
+	for uri, allowed := range map[string]bool{
+		"qmi:///dev/cdc-wdm0?slot=1":     true,
+		"qmi:/dev/cdc-wdm1":              true,
+		"qmi:///dev/ttyUSB0":             false,
+		"qmi:///dev/cdc-wdm0/../ttyUSB0": false,
+		"qmi:///dev/../dev/cdc-wdm0":     true,
+		"qmi:///dev/cdc-wdm0/..":         false,
+		"at:COM3":                        true,
+		"at:COM4":                        false,
+		"at://COM3":                      true,
+		"qrtr:?slot=2":                   true,
+		"ccid:?reader=omnikey":           true,
+		"mbim:///dev/cdc-wdm0":           false,
+	} {
+		err := a.allows(uri)
+		if allowed {
+			assert.NoError(t, err, uri)
+			continue
+		}
+		var serverErr *localnet.ServerError
+		if assert.ErrorAs(t, err, &serverErr, uri) {
+			assert.Equal(t, localnet.ErrorDeviceNotAllowed, serverErr.Code, uri)
+		}
+	}
+}
+
+// certificates returns a CA and a client certificate signed by it with the common name.
+func certificates(t *testing.T, name string) (*x509.CertPool, tls.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	ca, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err = x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}, ca, &clientKey.PublicKey, key)
+	assert.NoError(t, err)
+	return pool, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: clientKey}
+}
+
+// authenticate authenticates a TLS client, sending the certificate if it is not nil.
+func authenticate(t *testing.T, a *authorizer, pool *x509.CertPool, certificate *tls.Certificate) (*client, error) {
+	t.Helper()
+	_, server := certificates(t, "server")
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	config := &tls.Config{InsecureSkipVerify: true}
+	if certificate != nil {
+		config.Certificates = []tls.Certificate{*certificate}
+	}
+	// The client reads until the server closes, taking the session tickets and the closing alert.
+	go io.Copy(io.Discard, tls.Client(clientConn, config))
+	conn := tls.Server(serverConn, &tls.Config{
+		Certificates: []tls.Certificate{server},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+	defer conn.Close()
+	return a.authenticate(conn)
+}
+
+func TestAuthorizer_Authenticate(t *testing.T) {
+	pool, certificate := certificates(t, "monitor")
+
+	c, err := authenticate(t, &authorizer{}, pool, &certificate)
+	assert.NoError(t, err)
+	assert.Equal(t, &client{name: "monitor", role: roleManage}, c)
+
+	c, err = authenticate(t, &authorizer{roles: map[string]role{"monitor": roleRead}}, pool, &certificate)
+	assert.NoError(t, err)
+	assert.Equal(t, &client{name: "monitor", role: roleRead}, c)
+
+	_, err = authenticate(t, &authorizer{roles: map[string]role{"lpa": roleManage}, anonymous: roleRead}, pool, &certificate)
+	assert.EqualError(t, err, "client monitor is not authorized")
+
+	// Without a verified certificate, the client is anonymous.
+	_, err = authenticate(t, &authorizer{}, pool, nil)
+	assert.EqualError(t, err, "client pipe has no certificate")
+	c, err = authenticate(t, &authorizer{anonymous: roleRead}, pool, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &client{name: "pipe", role: roleRead}, c)
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	c, err = (&authorizer{anonymous: roleRead}).authenticate(serverConn)
+	assert.NoError(t, err)
+	assert.Equal(t, roleRead, c.role)
+}
@@ -1,48 +1,127 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"log/slog"
 
-	"github.com/damonto/euicc-go/driver/at"
+	_ "github.com/damonto/euicc-go/driver/at"
+	_ "github.com/damonto/euicc-go/driver/ccid"
 	"github.com/damonto/euicc-go/driver/localnet"
-	"github.com/damonto/euicc-go/driver/mbim"
-	"github.com/damonto/euicc-go/driver/qmi"
-	"github.com/damonto/euicc-go/lpa"
+	_ "github.com/damonto/euicc-go/driver/mbim"
+	_ "github.com/damonto/euicc-go/driver/qmi"
 )
 
 var (
-	options lpa.Options
+	auth     authorizer
+	sessions *sessionManager
 )
 
 func main() {
 	slog.SetLogLoggerLevel(slog.LevelDebug)
 
-	bindAddrFlag := flag.String("bindAddr", "0.0.0.0", "Binding address")
+	bindAddrFlag := flag.String("bindAddr", "127.0.0.1", "Binding address")
 	bindPortFlag := flag.Int("bindPort", 8080, "Binding port")
-	bufferSizeFlag := flag.Int("bufferSize", 2048, "Buffer size in byte")
+	bufferSizeFlag := flag.Int("bufferSize", 2048, "Buffer size in byte (udp only)")
+	transportFlag := flag.String("transport", "udp", "Transport: udp, tcp or tls")
+	certFlag := flag.String("cert", "", "TLS certificate file (tls only)")
+	keyFlag := flag.String("key", "", "TLS private key file (tls only)")
+	caFlag := flag.String("ca", "", "CA file verifying the client certificates, enables mutual TLS (tls only)")
+	rolesFlag := flag.String("roles", "", `JSON file with the role of each client certificate common name, e.g. {"lpa": "manage", "monitor": "read"}`)
+	devicesFlag := flag.String("devices", "", "Comma-separated patterns of the allowed devices, e.g. qmi:/dev/cdc-wdm0,mbim:/dev/cdc-wdm*,qrtr: (required)")
+	anonymousFlag := flag.String("anonymous", "none", "Role of the clients without a verified certificate: read, manage or none")
+	idleFlag := flag.Duration("idleTimeout", 5*time.Minute, "Time after which an unused session is closed")
+	waitFlag := flag.Duration("lockTimeout", 30*time.Second, "Time to wait for a device used by another session")
 	flag.Parse()
 
-	options.AdminProtocolVersion = "2"
+	sessions = newSessionManager(*idleFlag, *waitFlag)
 
-	addr := net.UDPAddr{
-		Port: *bindPortFlag,
-		IP:   net.ParseIP(*bindAddrFlag),
+	if auth.devices = splitList(*devicesFlag); len(auth.devices) == 0 {
+		fmt.Println("Error: -devices is required")
+		return
+	}
+	if *anonymousFlag != "none" {
+		var err error
+		if auth.anonymous, err = parseRole(*anonymousFlag); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+	if *rolesFlag != "" {
+		var err error
+		if auth.roles, err = loadRoles(*rolesFlag); err != nil {
+			fmt.Println("Error loading the roles:", err)
+			return
+		}
+	}
+	if *caFlag != "" && *transportFlag != "tls" {
+		fmt.Println("Error: mutual TLS requires -transport tls")
+		return
+	}
+	if *caFlag == "" && auth.anonymous == 0 {
+		fmt.Println("Error: without mutual TLS every client is anonymous, set -anonymous read or manage")
+		return
 	}
 
-	conn, err := net.ListenUDP("udp", &addr)
+	address := net.JoinHostPort(*bindAddrFlag, strconv.Itoa(*bindPortFlag))
+	switch *transportFlag {
+	case "udp":
+		serveUDP(address, *bufferSizeFlag)
+	case "tcp", "tls":
+		listenConfig := net.ListenConfig{KeepAlive: 15 * time.Second}
+		listener, err := listenConfig.Listen(context.Background(), "tcp", address)
+		if err != nil {
+			fmt.Println("Error on socket server listening:", err)
+			return
+		}
+		if *transportFlag == "tls" {
+			config, err := serverTLS(*certFlag, *keyFlag, *caFlag)
+			if err != nil {
+				fmt.Println("Error configuring TLS:", err)
+				return
+			}
+			listener = tls.NewListener(listener, config)
+		}
+		defer listener.Close()
+		serveTCP(listener)
+	default:
+		fmt.Printf("Unknown transport %q\n", *transportFlag)
+	}
+}
+
+func serveUDP(address string, bufferSize int) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		fmt.Println("Error resolving address:", err)
+		return
+	}
+
+	// Datagrams cannot be authenticated, every sender is an anonymous client.
+	if auth.anonymous == 0 {
+		fmt.Println("Error: udp requires -anonymous read or manage")
+		return
+	}
+
+	conn, err := net.ListenUDP("udp", addr)
 	if err != nil {
 		fmt.Println("Error on socket server listening:", err)
 		return
 	}
 	defer conn.Close()
 
-	buffer := make([]byte, *bufferSizeFlag)
+	buffer := make([]byte, bufferSize)
 
-outer:
 	for {
 		n, remoteAddr, err := conn.ReadFromUDP(buffer)
 		if err != nil {
@@ -50,104 +129,222 @@ outer:
 			break
 		}
 
-		var pcRcv, errr = localnet.Decode(buffer[:n])
-		if errr != nil {
-			fmt.Printf("Error decoding packet. Closing server\n")
-			break
+		var request localnet.Message
+		if errr := request.UnmarshalBinary(buffer[:n]); errr != nil {
+			fmt.Printf("Error decoding packet from %s: %s\n", remoteAddr, errr)
+			continue
 		}
 
-		fmt.Printf("DEBUG %s\n", pcRcv)
-
-		var pcSnd localnet.IPacketCmd = nil
-
-		switch pcRcv.GetCmd() {
-
-		case localnet.CmdConnect:
-
-			if options.Channel != nil {
-				err = fmt.Errorf("error: channel already open, retry later")
-			} else {
-				var pcConn localnet.IPacketConnect = pcRcv.(localnet.IPacketConnect)
-
-				switch pcConn.GetProto() {
-				case "at":
-					options.Channel, err = at.New(pcConn.GetDevice())
-				/*case "ccid":
-				options.Channel, err = ccid.New() */
-				case "mbim":
-					options.Channel, err = mbim.New(pcConn.GetDevice(), pcConn.GetSlot())
-				case "qmi":
-					options.Channel, err = qmi.New(pcConn.GetDevice(), pcConn.GetSlot())
-				case "qrtr":
-					options.Channel, err = qmi.NewQRTR(pcConn.GetSlot())
-				default:
-					err = fmt.Errorf("error: no handler for the specified protocol %s", pcConn.GetProto())
-				}
-			}
+		// Requests wait for their device, so each one is handled apart not to block the other clients.
+		go func() {
+			c, _ := auth.anonymousClient(remoteAddr)
+			response := &localnet.Message{Protocol: request.Protocol, ID: request.ID, Packet: handle(c, remoteAddr.String(), request.Packet)}
 
+			byteArrayResponse, err := response.MarshalBinary()
 			if err != nil {
-				pcSnd = localnet.NewPacketCmdErr(localnet.CmdResponse, err.Error())
-			} else {
-
-				err = options.Channel.Connect()
-				if err != nil {
-					pcSnd = localnet.NewPacketCmdErr(localnet.CmdResponse, err.Error())
-					options.Channel = nil
-				}
+				fmt.Printf("Error encoding response: %s\n", err)
+				return
 			}
 
-		case localnet.CmdDisconnect:
-			err = options.Channel.Disconnect()
-			options.Channel = nil
+			_, err = conn.WriteToUDP(byteArrayResponse, remoteAddr)
 			if err != nil {
-				pcSnd = localnet.NewPacketCmdErr(localnet.CmdResponse, err.Error())
+				fmt.Printf("Error sending response to the client: %s\n", err)
 			}
+		}()
+	}
+}
 
-		case localnet.CmdOpenLogical:
-			var channel byte
-			channel, err = options.Channel.OpenLogicalChannel(pcRcv.(localnet.IPacketBody).GetBody())
-			var bb = []byte{channel}
-			if err != nil {
-				pcSnd = localnet.NewPacketCmdErr(localnet.CmdResponse, err.Error())
-			} else {
-				pcSnd = localnet.NewPacketBody(localnet.CmdResponse, bb)
-			}
+func serveTCP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Printf("error accepting connection %s\n", err)
+			return
+		}
+		go serveConn(conn)
+	}
+}
 
-		case localnet.CmdCloseLogical:
-			err = options.Channel.CloseLogicalChannel(pcRcv.(localnet.IPacketBody).GetBody()[0])
-			if err != nil {
-				pcSnd = localnet.NewPacketCmdErr(localnet.CmdResponse, err.Error())
-			}
+// serveConn answers the length-prefixed messages of a client until it disconnects.
+// The session left open by the client is closed, so other clients can use its device.
+func serveConn(conn net.Conn) {
+	defer conn.Close()
+	c, err := auth.authenticate(conn)
+	if err != nil {
+		fmt.Printf("Rejecting %s: %s\n", conn.RemoteAddr(), err)
+		return
+	}
+	fmt.Printf("Client %s connected with role %s\n", c.name, c.role)
+	address := conn.RemoteAddr().String()
+	defer sessions.closeAddress(address)
 
-		case localnet.CmdTransmit:
-			var bb, err = options.Channel.Transmit(pcRcv.(localnet.IPacketBody).GetBody())
-			if err != nil {
-				fmt.Printf("Error on transmit: %s\n", err)
-				pcSnd = localnet.NewPacketCmdErr(localnet.CmdResponse, err.Error())
-			} else {
-				pcSnd = localnet.NewPacketBody(localnet.CmdResponse, bb)
-			}
-			fmt.Printf("DEBUG %s\n", pcSnd)
+	for {
+		request, err := localnet.ReadMessage(conn)
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			fmt.Printf("Error reading from %s: %s\n", conn.RemoteAddr(), err)
+			return
+		}
 
-		default:
-			fmt.Printf("Receiving unknown command. Closing server\n")
-			break outer
+		response := &localnet.Message{Protocol: request.Protocol, ID: request.ID, Packet: handle(c, address, request.Packet)}
+		if err := localnet.WriteMessage(conn, response); err != nil {
+			fmt.Printf("Error sending response to the client: %s\n", err)
+			return
 		}
+	}
+}
+
+// handle runs a request of the client on its session and returns the response.
+// The session is found by the token of the request, or by the address of the client for clients without token.
+func handle(c *client, address string, pcRcv localnet.IPacketCmd) localnet.IPacketCmd {
+	fmt.Printf("DEBUG %s\n", pcRcv)
 
-		if pcSnd == nil {
-			pcSnd = localnet.NewPacketCmd(localnet.CmdResponse)
+	switch pcRcv.GetCmd() {
+	case localnet.CmdHello:
+		pc, ok := pcRcv.(localnet.IPacketHello)
+		if !ok {
+			return malformed(pcRcv)
 		}
-		byteArrayResponse, err := localnet.Encode(pcSnd)
+		return hello(pc)
+	case localnet.CmdConnect:
+		pc, ok := pcRcv.(localnet.IPacketConnect)
+		if !ok {
+			return malformed(pcRcv)
+		}
+		if c.role != roleManage && pc.GetSlot() != 0 {
+			// Connecting to another slot than the active one switches the slot of the modem.
+			return localnet.NewPacketCmdCode(localnet.CmdResponse, localnet.ErrorPermissionDenied, "error: permission denied, read-only clients use the active slot")
+		}
+		uri, err := channelURI(pc)
 		if err != nil {
-			fmt.Printf("Error encoding response: %s\n", err)
-			break
+			return errorResponse(err)
+		}
+		if err := auth.allows(uri); err != nil {
+			return errorResponse(err)
+		}
+		s, err := sessions.open(c, address, uri)
+		if err != nil {
+			return errorResponse(err)
 		}
+		return localnet.WithSession(localnet.NewPacketCmd(localnet.CmdResponse), s.token)
+	}
+
+	var body []byte
+	switch pcRcv.GetCmd() {
+	case localnet.CmdOpenLogical, localnet.CmdCloseLogical, localnet.CmdTransmit:
+		pb, ok := pcRcv.(localnet.IPacketBody)
+		if !ok || pcRcv.GetCmd() == localnet.CmdCloseLogical && len(pb.GetBody()) != 1 {
+			return malformed(pcRcv)
+		}
+		body = pb.GetBody()
+	}
+
+	s := sessions.lookup(pcRcv.GetSession(), address)
+	if s == nil || s.client.name != c.name {
+		return localnet.NewPacketCmdCode(localnet.CmdResponse, localnet.ErrorNotConnected, "error: channel is not connected")
+	}
+	if pcRcv.GetCmd() == localnet.CmdDisconnect {
+		if err := sessions.close(s); err != nil {
+			return errorResponse(err)
+		}
+		return localnet.NewPacketCmd(localnet.CmdResponse)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return errorResponse(errSessionClosed)
+	}
+
+	var err error
+	var pcSnd localnet.IPacketCmd = nil
+
+	switch pcRcv.GetCmd() {
 
-		_, err = conn.WriteToUDP(byteArrayResponse, remoteAddr)
+	case localnet.CmdOpenLogical:
+		var channel byte
+		channel, err = s.channel.OpenLogicalChannel(body)
+		var bb = []byte{channel}
 		if err != nil {
-			fmt.Printf("Error sending response to the client: %s\n", err)
-			break
+			pcSnd = errorResponse(err)
+		} else {
+			pcSnd = localnet.NewPacketBody(localnet.CmdResponse, bb)
+		}
+
+	case localnet.CmdCloseLogical:
+		err = s.channel.CloseLogicalChannel(body[0])
+		if err != nil {
+			pcSnd = errorResponse(err)
+		}
+
+	case localnet.CmdTransmit:
+		if !s.permits(body) {
+			fmt.Printf("Client %s is not allowed to send %X\n", c.name, body)
+			return localnet.NewPacketCmdCode(localnet.CmdResponse, localnet.ErrorPermissionDenied, "error: permission denied")
+		}
+		var bb, err = s.channel.Transmit(body)
+		if err != nil {
+			fmt.Printf("Error on transmit: %s\n", err)
+			pcSnd = errorResponse(err)
+		} else {
+			pcSnd = localnet.NewPacketBody(localnet.CmdResponse, bb)
 		}
+		fmt.Printf("DEBUG %s\n", pcSnd)
+
+	default:
+		fmt.Printf("Receiving unknown command %q from %s\n", pcRcv.GetCmd(), c.name)
+		return localnet.NewPacketCmdCode(localnet.CmdResponse, localnet.ErrorUnknownCommand, "error: unknown command")
+	}
+
+	if pcSnd == nil {
+		pcSnd = localnet.NewPacketCmd(localnet.CmdResponse)
+	}
+	return pcSnd
+}
+
+// hello answers the version handshake with the version chosen by the server and its capabilities.
+func hello(pcRcv localnet.IPacketHello) localnet.IPacketCmd {
+	if !bytes.Contains(pcRcv.GetVersions(), []byte{localnet.ProtocolVersion}) {
+		return localnet.NewPacketCmdCode(localnet.CmdResponse, localnet.ErrorUnsupportedVersion,
+			fmt.Sprintf("error: unsupported versions %v, the server supports %d", pcRcv.GetVersions(), localnet.ProtocolVersion))
+	}
+	return localnet.NewPacketHello(localnet.CmdResponse, []byte{localnet.ProtocolVersion}, localnet.CapabilitySessions|localnet.CapabilityRoles)
+}
+
+// malformed answers a request missing the fields of its command.
+func malformed(pcRcv localnet.IPacketCmd) localnet.IPacketCmd {
+	return localnet.NewPacketCmdCode(localnet.CmdResponse, localnet.ErrorUnknownCommand, fmt.Sprintf("error: malformed %s request", pcRcv.GetCmd()))
+}
+
+// errorResponse returns the response reporting the error, typed by the server or else an error of the driver.
+func errorResponse(err error) localnet.IPacketCmd {
+	var serverErr *localnet.ServerError
+	if errors.As(err, &serverErr) {
+		return localnet.NewPacketCmdCode(localnet.CmdResponse, serverErr.Code, serverErr.Message)
+	}
+	return localnet.NewPacketCmdCode(localnet.CmdResponse, localnet.ErrorDriver, err.Error())
+}
 
+// channelURI returns the driver URI of a connect request, built from the scheme, the device and the slot only,
+// so that the clients cannot set the options of the driver, e.g. the address of a network driver.
+// A device which is not an absolute path, e.g. "COM3", is the opaque part of the URI.
+func channelURI(pc localnet.IPacketConnect) (string, error) {
+	scheme, device := pc.GetProto(), pc.GetDevice()
+	if scheme == "" || strings.ContainsAny(scheme, ":/?#") {
+		return "", &localnet.ServerError{Code: localnet.ErrorDeviceNotAllowed, Message: fmt.Sprintf("invalid driver %q", scheme)}
+	}
+	u := url.URL{
+		Scheme:   scheme,
+		RawQuery: url.Values{"slot": {strconv.Itoa(int(pc.GetSlot()))}}.Encode(),
+	}
+	if strings.HasPrefix(device, "/") {
+		u.Path = device
+	} else if strings.ContainsAny(device, "?#") {
+		return "", &localnet.ServerError{Code: localnet.ErrorDeviceNotAllowed, Message: fmt.Sprintf("invalid device %q", device)}
+	} else {
+		u.Opaque = device
 	}
+	return u.String(), nil
 }
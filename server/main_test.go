@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/damonto/euicc-go/driver/localnet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelURI(t *testing.T) {
+	for _, test := range []struct {
+		proto, device string
+		slot          uint8
+		uri           string
+	}{
+		{proto: "qmi", device: "/dev/cdc-wdm0", slot: 1, uri: "qmi:///dev/cdc-wdm0?slot=1"},
+		{proto: "at", device: "COM3", uri: "at:COM3?slot=0"},
+		{proto: "qrtr", uri: "qrtr:?slot=0"},
+		{proto: "mbim", device: "/dev/cdc-wdm0?slot=2", uri: "mbim:///dev/cdc-wdm0%3Fslot=2?slot=0"},
+	} {
+		uri, err := channelURI(localnet.NewPacketConnect(test.device, test.proto, test.slot).(localnet.IPacketConnect))
+		assert.NoError(t, err)
+		assert.Equal(t, test.uri, uri)
+	}
+
+	// The driver and an opaque device cannot add their own query or path.
+	for _, test := range []struct{ proto, device string }{
+		{proto: "", device: "/dev/cdc-wdm0"},
+		{proto: "qmi:", device: "/dev/cdc-wdm0"},
+		{proto: "ccid?reader=x", device: ""},
+		{proto: "at", device: "COM3?slot=2"},
+		{proto: "at", device: "COM3#fragment"},
+	} {
+		_, err := channelURI(localnet.NewPacketConnect(test.device, test.proto, 0).(localnet.IPacketConnect))
+		var serverErr *localnet.ServerError
+		if assert.ErrorAs(t, err, &serverErr, test) {
+			assert.Equal(t, localnet.ErrorDeviceNotAllowed, serverErr.Code)
+		}
+	}
+}
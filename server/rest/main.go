@@ -0,0 +1,99 @@
+// Command rest serves the HTTP/JSON API of the rest package for the modems given by their driver URI, e.g.
+//
+//	rest -listen 127.0.0.1:8081 -modem wwan0=qmi:///dev/cdc-wdm0?slot=1 -modem usb=at:///dev/ttyUSB2 -imei 356938035643809
+//
+// The API is described at http://127.0.0.1:8081/openapi.yaml.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/damonto/euicc-go/driver"
+	_ "github.com/damonto/euicc-go/driver/at"
+	_ "github.com/damonto/euicc-go/driver/ccid"
+	_ "github.com/damonto/euicc-go/driver/localnet"
+	_ "github.com/damonto/euicc-go/driver/mbim"
+	_ "github.com/damonto/euicc-go/driver/pcsc"
+	_ "github.com/damonto/euicc-go/driver/qmi"
+	_ "github.com/damonto/euicc-go/driver/simulator"
+	_ "github.com/damonto/euicc-go/driver/vpcd"
+	"github.com/damonto/euicc-go/lpa"
+	"github.com/damonto/euicc-go/rest"
+)
+
+func main() {
+	var modems []rest.Modem
+	listenFlag := flag.String("listen", "127.0.0.1:8081", "Listening address")
+	imeiFlag := flag.String("imei", "", "IMEI sent to the SM-DP+ and SM-DS servers when the request has none")
+	probeFlag := flag.Bool("probe", true, "Probe the known ISD-R AIDs")
+	debugFlag := flag.Bool("debug", false, "Log the APDUs and the HTTP requests")
+	retentionFlag := flag.Duration("jobRetention", time.Hour, "Time a finished job is kept")
+	decisionFlag := flag.Duration("decisionTimeout", 2*time.Minute, "Time a download job waits for the confirmation before rejecting the profile")
+	flag.Func("modem", "Modem as name=driver URI, e.g. wwan0=qmi:///dev/cdc-wdm0?slot=1 (repeatable)", func(value string) error {
+		name, uri, ok := strings.Cut(value, "=")
+		if !ok || name == "" {
+			return errors.New("expected name=URI")
+		}
+		modems = append(modems, rest.Modem{Name: name, Open: func() (*lpa.Client, error) {
+			channel, err := driver.Open(uri)
+			if err != nil {
+				return nil, err
+			}
+			client, err := lpa.New(&lpa.Options{Channel: channel, Probe: *probeFlag})
+			if err != nil {
+				_ = driver.Close(channel)
+			}
+			return client, err
+		}})
+		return nil
+	})
+	flag.Parse()
+
+	if *debugFlag {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+	if len(modems) == 0 {
+		fmt.Println("Error: at least one -modem is required")
+		return
+	}
+	for i := range modems {
+		modems[i].IMEI = *imeiFlag
+	}
+
+	server, err := rest.New(modems, &rest.Options{JobRetention: *retentionFlag, DecisionTimeout: *decisionFlag})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	httpServer := &http.Server{Addr: *listenFlag, Handler: server}
+	served := make(chan error, 1)
+	go func() { served <- httpServer.ListenAndServe() }()
+	fmt.Printf("Serving %d modems on http://%s\n", len(modems), *listenFlag)
+
+	select {
+	case err = <-served:
+		fmt.Println("Error:", err)
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			fmt.Println("Error shutting down:", err)
+		}
+	}
+	// Close cancels the running jobs and closes the LPA clients of the modems.
+	if err := server.Close(); err != nil {
+		fmt.Println("Error closing:", err)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/euicc-go/driver"
+	"github.com/damonto/euicc-go/driver/localnet"
+)
+
+var errSessionClosed = &localnet.ServerError{Code: localnet.ErrorNotConnected, Message: "error: session is closed"}
+
+// session is a client connected to a device. The device is locked by the session until it is closed.
+type session struct {
+	mutex   sync.Mutex
+	token   string
+	address string
+	client  *client
+	device  *device
+	channel apdu.SmartCardChannel
+	closed  bool
+	// reading is set while the STORE DATA blocks of a read request are sent.
+	reading bool
+	// used is the time of the last request, in Unix nanoseconds.
+	used atomic.Int64
+}
+
+// device is a modem or a reader. Only one session uses it at a time, the other sessions wait for the lock.
+type device struct {
+	lock chan struct{}
+}
+
+// sessionManager keeps the sessions by token and by the address they were opened from,
+// so clients that do not send the token still reach their session.
+type sessionManager struct {
+	mutex    sync.Mutex
+	sessions map[string]*session
+	// opening are the addresses whose session is being opened, so a second connect request from them is rejected.
+	opening map[string]bool
+	devices map[string]*device
+	// idle is the time after which an unused session is closed.
+	idle time.Duration
+	// wait is the time to wait for a device used by another session.
+	wait time.Duration
+}
+
+func newSessionManager(idle, wait time.Duration) *sessionManager {
+	m := &sessionManager{
+		sessions: make(map[string]*session),
+		opening:  make(map[string]bool),
+		devices:  make(map[string]*device),
+		idle:     idle,
+		wait:     wait,
+	}
+	go m.expire()
+	return m
+}
+
+// open connects the client to the device of the driver URI and returns the new session.
+func (m *sessionManager) open(c *client, address, uri string) (*session, error) {
+	name, err := deviceName(uri)
+	if err != nil {
+		return nil, err
+	}
+	m.mutex.Lock()
+	if _, ok := m.sessions[address]; ok || m.opening[address] {
+		m.mutex.Unlock()
+		return nil, &localnet.ServerError{Code: localnet.ErrorAlreadyConnected, Message: "error: channel already open, retry later"}
+	}
+	// The address is reserved while the device is awaited and connected.
+	m.opening[address] = true
+	d, ok := m.devices[name]
+	if !ok {
+		d = &device{lock: make(chan struct{}, 1)}
+		m.devices[name] = d
+	}
+	m.mutex.Unlock()
+	release := func() {
+		m.mutex.Lock()
+		delete(m.opening, address)
+		m.mutex.Unlock()
+	}
+
+	select {
+	case d.lock <- struct{}{}:
+	case <-time.After(m.wait):
+		release()
+		return nil, &localnet.ServerError{Code: localnet.ErrorBusy, Message: "error: device " + name + " is busy"}
+	}
+	channel, err := driver.Open(uri)
+	if err == nil {
+		err = channel.Connect()
+	}
+	if err != nil {
+		if channel != nil {
+			_ = driver.Close(channel)
+		}
+		<-d.lock
+		release()
+		return nil, err
+	}
+
+	s := &session{token: newToken(), address: address, client: c, device: d, channel: channel}
+	s.used.Store(time.Now().UnixNano())
+	m.mutex.Lock()
+	delete(m.opening, address)
+	m.sessions[s.token] = s
+	m.sessions[address] = s
+	m.mutex.Unlock()
+	fmt.Printf("Client %s opened session %s on %s\n", c.name, s.id(), name)
+	return s, nil
+}
+
+// lookup returns the session of the token, or of the address if the token is empty.
+func (m *sessionManager) lookup(token, address string) *session {
+	key := token
+	if key == "" {
+		key = address
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s := m.sessions[key]
+	if s != nil {
+		s.used.Store(time.Now().UnixNano())
+	}
+	return s
+}
+
+// close disconnects the channel of the session and unlocks its device.
+// It waits for the request in progress on the session.
+func (m *sessionManager) close(s *session) error {
+	m.mutex.Lock()
+	if m.sessions[s.token] == s {
+		delete(m.sessions, s.token)
+	}
+	if m.sessions[s.address] == s {
+		delete(m.sessions, s.address)
+	}
+	m.mutex.Unlock()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	err := driver.Close(s.channel)
+	<-s.device.lock
+	fmt.Printf("Session %s of client %s closed\n", s.id(), s.client.name)
+	return err
+}
+
+// closeAddress closes the session opened from the address, e.g. when its TCP connection is closed.
+func (m *sessionManager) closeAddress(address string) {
+	m.mutex.Lock()
+	s := m.sessions[address]
+	m.mutex.Unlock()
+	if s != nil {
+		_ = m.close(s)
+	}
+}
+
+// expire closes the sessions unused for longer than the idle timeout, so a client
+// that disappears without disconnecting does not keep its device locked.
+func (m *sessionManager) expire() {
+	for range time.Tick(max(m.idle/4, time.Second)) {
+		var idle []*session
+		m.mutex.Lock()
+		for key, s := range m.sessions {
+			if key == s.token && time.Since(time.Unix(0, s.used.Load())) > m.idle {
+				idle = append(idle, s)
+			}
+		}
+		m.mutex.Unlock()
+		for _, s := range idle {
+			fmt.Printf("Session %s of client %s is idle\n", s.id(), s.client.name)
+			_ = m.close(s)
+		}
+	}
+}
+
+// id returns a prefix of the token identifying the session in the logs, the token itself being a secret.
+func (s *session) id() string {
+	return s.token[:8]
+}
+
+// permits reports whether the client of the session may send the APDU to the eUICC.
+// Read-only clients may only send the STORE DATA of the ES10 read requests and GET RESPONSE.
+func (s *session) permits(command []byte) bool {
+	if s.client.role == roleManage {
+		return true
+	}
+	if len(command) < 4 {
+		return false
+	}
+	switch command[1] {
+	case 0xC0:
+		return true
+	case 0xE2:
+		// The request tag is in the first block only, the next blocks continue the same request.
+		if command[3] == 0 {
+			s.reading = readTags[requestTag(command)]
+		}
+		return s.reading
+	}
+	return false
+}
+
+// requestTag returns the tag of the ES10 request in the first STORE DATA block, after a short Lc
+// or an extended Lc, 00 followed by two bytes. It returns 0 when the block is too short.
+func requestTag(command []byte) uint16 {
+	if len(command) < 5 {
+		return 0
+	}
+	data := command[5:]
+	if command[4] == 0 {
+		if len(command) < 7 {
+			return 0
+		}
+		data = command[7:]
+	}
+	if len(data) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(data)
+}
+
+// deviceName returns the device of a driver URI as "scheme:path", e.g. "qmi:/dev/cdc-wdm0", "at:COM3" or "qrtr:".
+// The path is cleaned, so ".." elements do not escape the patterns of the allowed devices.
+func deviceName(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	device := driver.Device(u)
+	if device != "" {
+		device = path.Clean(device)
+	}
+	return u.Scheme + ":" + device, nil
+}
+
+func newToken() string {
+	token := make([]byte, 16)
+	rand.Read(token)
+	return hex.EncodeToString(token)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/damonto/euicc-go/apdu"
+	"github.com/damonto/euicc-go/driver"
+	"github.com/damonto/euicc-go/driver/localnet"
+	"github.com/damonto/euicc-go/driver/simulator"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowCard is a card whose Connect waits to be released.
+type slowCard struct {
+	*simulator.Simulator
+	connecting chan struct{}
+	release    chan struct{}
+}
+
+func (c *slowCard) Connect() error {
+	c.connecting <- struct{}{}
+	<-c.release
+	return c.Simulator.Connect()
+}
+
+func TestSessionManager_Open(t *testing.T) {
+	s, err := simulator.New(nil)
+	assert.NoError(t, err)
+	card := &slowCard{Simulator: s, connecting: make(chan struct{}), release: make(chan struct{})}
+	driver.Register("slow", func(*url.URL) (apdu.SmartCardChannel, error) { return card, nil })
+	m := newSessionManager(time.Minute, time.Second)
+	c := &client{name: "lpa", role: roleManage}
+
+	opened := make(chan error)
+	go func() {
+		s, err := m.open(c, "127.0.0.1:1234", "slow:")
+		if err == nil {
+			err = m.close(s)
+		}
+		opened <- err
+	}()
+	<-card.connecting
+	// The address is taken while its first session is being opened.
+	_, err = m.open(c, "127.0.0.1:1234", "slow:")
+	var serverErr *localnet.ServerError
+	if assert.True(t, errors.As(err, &serverErr)) {
+		assert.Equal(t, localnet.ErrorAlreadyConnected, serverErr.Code)
+	}
+	close(card.release)
+	assert.NoError(t, <-opened)
+	assert.Empty(t, m.opening)
+}
+
+func TestSession_Permits(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		commands [][]byte
+		permits  []bool
+	}{
+		{
+			name:     "read request",
+			commands: [][]byte{{0x80, 0xE2, 0x91, 0x00, 0x03, 0xBF, 0x3E, 0x00}},
+			permits:  []bool{true},
+		},
+		{
+			name:     "write request",
+			commands: [][]byte{{0x80, 0xE2, 0x91, 0x00, 0x03, 0xBF, 0x31, 0x00}},
+			permits:  []bool{false},
+		},
+		{
+			name: "continuation of a read request",
+			commands: [][]byte{
+				{0x80, 0xE2, 0x11, 0x00, 0x03, 0xBF, 0x2D, 0x00},
+				{0x80, 0xE2, 0x91, 0x01, 0x01, 0x00},
+			},
+			permits: []bool{true, true},
+		},
+		{
+			name: "continuation of a write request",
+			commands: [][]byte{
+				{0x80, 0xE2, 0x11, 0x00, 0x03, 0xBF, 0x36, 0x00},
+				{0x80, 0xE2, 0x91, 0x01, 0x03, 0xBF, 0x2D, 0x00},
+			},
+			permits: []bool{false, false},
+		},
+		{
+			name:     "extended read request",
+			commands: [][]byte{{0x80, 0xE2, 0x91, 0x00, 0x00, 0x00, 0x03, 0xBF, 0x2D, 0x00}},
+			permits:  []bool{true},
+		},
+		{
+			name:     "extended write request",
+			commands: [][]byte{{0x80, 0xE2, 0x91, 0x00, 0x00, 0x00, 0x03, 0xBF, 0x41, 0x00}},
+			permits:  []bool{false},
+		},
+		{
+			name:     "GET RESPONSE",
+			commands: [][]byte{{0x80, 0xC0, 0x00, 0x00, 0x00}},
+			permits:  []bool{true},
+		},
+		{
+			name: "short commands",
+			commands: [][]byte{
+				{0x80, 0xE2, 0x91},
+				{0x80, 0xE2, 0x91, 0x00},
+				{0x80, 0xE2, 0x91, 0x00, 0x01, 0xBF},
+				{0x80, 0xE2, 0x91, 0x00, 0x00, 0x00},
+			},
+			permits: []bool{false, false, false, false},
+		},
+		{
+			name:     "other instruction",
+			commands: [][]byte{{0x00, 0xA4, 0x04, 0x00, 0x00}},
+			permits:  []bool{false},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			reader := &session{client: &client{role: roleRead}}
+			manager := &session{client: &client{role: roleManage}}
+			for i, command := range test.commands {
+				assert.Equal(t, test.permits[i], reader.permits(command), "%X", command)
+				assert.True(t, manager.permits(command), "%X", command)
+			}
+		})
+	}
+}
+
+func TestDeviceName(t *testing.T) {
+	for uri, name := range map[string]string{
+		"qmi:///dev/cdc-wdm0?slot=1":  "qmi:/dev/cdc-wdm0",
+		"at:COM3?slot=1":              "at:COM3",
+		"qrtr:?slot=1":                "qrtr:",
+		"mbim:/dev/../etc/passwd":     "mbim:/etc/passwd",
+		"mbim:///dev/./cdc-wdm0//":    "mbim:/dev/cdc-wdm0",
+		"ccid:?reader=omnikey&slot=1": "ccid:",
+	} {
+		got, err := deviceName(uri)
+		assert.NoError(t, err)
+		assert.Equal(t, name, got, uri)
+	}
+}
@@ -6,11 +6,13 @@ import (
 )
 
 var (
-	ErrUnexpectedTag   = errors.New("unexpected tag")
-	ErrNothingToDelete = errors.New("nothing to delete")
-	ErrICCIDNotFound   = errors.New("iccid not found")
-	ErrCatBusy         = errors.New("cat busy")
-	ErrUndefined       = errors.New("undefined error")
+	ErrUnexpectedTag        = errors.New("unexpected tag")
+	ErrNothingToDelete      = errors.New("nothing to delete")
+	ErrICCIDNotFound        = errors.New("iccid not found")
+	ErrProfileNotFound      = errors.New("iccid or aid not found")
+	ErrNotificationNotFound = errors.New("notification does not exist")
+	ErrCatBusy              = errors.New("cat busy")
+	ErrUndefined            = errors.New("undefined error")
 )
 
 type LoadBoundProfilePackageError struct{ BPPCommandID, ErrorReason byte }
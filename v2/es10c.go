@@ -168,7 +168,7 @@ func (r *ProfileOperationResponse) Valid() error {
 	case 0:
 		return nil
 	case 1:
-		return errors.New("iccid or aid not found")
+		return ErrProfileNotFound
 	case 2:
 		if r.Operation == EnableProfile {
 			return errors.New("profile not in disabled state")
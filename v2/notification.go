@@ -1,7 +1,6 @@
 package sgp22
 
 import (
-	"errors"
 	"slices"
 
 	"github.com/damonto/euicc-go/bertlv"
@@ -86,7 +85,7 @@ func (p *PendingNotification) UnmarshalBERTLV(tlv *bertlv.TLV) error {
 		return ErrUnexpectedTag
 	}
 	if len(tlv.Children) == 0 {
-		return errors.New("notification does not exist")
+		return ErrNotificationNotFound
 	}
 	pendingNotification := tlv.First(bertlv.ContextSpecific.Constructed(55))
 	if pendingNotification == nil {